@@ -0,0 +1,90 @@
+package tcpserver
+
+import (
+	"crypto/tls"
+	"log/slog"
+	"net"
+	"time"
+)
+
+// An AccessLogEntry describes a connection served by the server. It is passed
+// to TCPServer.AccessLog when the connection is closed.
+type AccessLogEntry struct {
+	ConnID     uint64
+	RemoteAddr net.Addr
+	LocalAddr  net.Addr
+
+	// TLS details are empty for plaintext connections.
+	ServerName         string
+	NegotiatedProtocol string
+	TLSVersion         string
+	CipherSuite        string
+
+	Start        time.Time
+	Duration     time.Duration
+	BytesRead    int64
+	BytesWritten int64
+	Reads        int64
+	Writes       int64
+	CloseReason  CloseReason
+}
+
+// Attrs returns the entry as logging attributes.
+func (e *AccessLogEntry) Attrs() []slog.Attr {
+	attrs := []slog.Attr{
+		slog.Uint64("conn_id", e.ConnID),
+		slog.String("remote_addr", e.RemoteAddr.String()),
+		slog.String("local_addr", e.LocalAddr.String()),
+	}
+	if e.TLSVersion != "" {
+		attrs = append(attrs,
+			slog.String("sni", e.ServerName),
+			slog.String("alpn", e.NegotiatedProtocol),
+			slog.String("tls_version", e.TLSVersion),
+			slog.String("cipher", e.CipherSuite))
+	}
+	return append(attrs,
+		slog.Time("start", e.Start),
+		slog.Duration("duration", e.Duration),
+		slog.Int64("bytes_read", e.BytesRead),
+		slog.Int64("bytes_written", e.BytesWritten),
+		slog.Int64("reads", e.Reads),
+		slog.Int64("writes", e.Writes),
+		slog.String("close_reason", e.CloseReason.String()))
+}
+
+// AccessLogger returns a function for TCPServer.AccessLog logging entries to
+// l at info level.
+func AccessLogger(l Logger) func(e *AccessLogEntry) {
+	return func(e *AccessLogEntry) {
+		l.Log(slog.LevelInfo, "access", e.Attrs()...)
+	}
+}
+
+// accessLog passes the entry of the closed connection to AccessLog.
+func (srv *TCPServer) accessLog(cc *connContext, reason CloseReason) {
+	e := &AccessLogEntry{
+		ConnID:      cc.id,
+		RemoteAddr:  cc.conn.RemoteAddr(),
+		LocalAddr:   cc.conn.LocalAddr(),
+		Start:       cc.start,
+		Duration:    time.Since(cc.start),
+		CloseReason: reason,
+	}
+	e.BytesRead, e.BytesWritten, e.Reads, e.Writes = cc.counts()
+	hconn := cc.handlerConn()
+	tc, ok := cc.conn.(*tls.Conn)
+	if !ok {
+		// the connection may be upgraded by StartTLS.
+		tc, ok = hconn.(*tls.Conn)
+	}
+	if ok {
+		if state := tc.ConnectionState(); state.HandshakeComplete {
+			e.ServerName = state.ServerName
+			e.NegotiatedProtocol = state.NegotiatedProtocol
+			e.TLSVersion = tls.VersionName(state.Version)
+			e.CipherSuite = tls.CipherSuiteName(state.CipherSuite)
+		}
+	}
+	srv.AccessLog(e)
+}
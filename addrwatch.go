@@ -0,0 +1,83 @@
+package tcpserver
+
+import (
+	"log/slog"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// DefAddrPollInterval specifies interval of polling network interface
+// addresses on platforms without address change notifications.
+var DefAddrPollInterval = 2 * time.Second
+
+// listen listens on the address. If WaitForAddr is true and the address isn't
+// available, it waits for the address to appear. It returns ErrServerClosed if
+// the server is closed while waiting.
+func (srv *TCPServer) listen(network, addr string) (net.Listener, error) {
+	l, err := srv.listenOnce(network, addr)
+	if err == nil || !srv.WaitForAddr || !isAddrNotAvail(err) {
+		return l, err
+	}
+	srv.log(nil, slog.LevelWarn, "waiting for address", slog.String("addr", addr), slog.Any("error", err))
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	changeCh := watchAddrs(stopCh)
+	ticker := time.NewTicker(1 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-changeCh:
+		case <-ticker.C:
+			if atomic.LoadInt32(&srv.closed) != 0 {
+				return nil, ErrServerClosed
+			}
+			continue
+		}
+		if atomic.LoadInt32(&srv.closed) != 0 {
+			return nil, ErrServerClosed
+		}
+		l, err = srv.listenOnce(network, addr)
+		if err == nil || !isAddrNotAvail(err) {
+			return l, err
+		}
+	}
+}
+
+// pollAddrs polls network interface addresses, and notifies changes to the
+// returned channel until stopCh is closed.
+func pollAddrs(stopCh <-chan struct{}) <-chan struct{} {
+	changeCh := make(chan struct{}, 1)
+	go func() {
+		ticker := time.NewTicker(DefAddrPollInterval)
+		defer ticker.Stop()
+		last := interfaceAddrs()
+		for {
+			select {
+			case <-ticker.C:
+			case <-stopCh:
+				return
+			}
+			if addrs := interfaceAddrs(); addrs != last {
+				last = addrs
+				select {
+				case changeCh <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+	return changeCh
+}
+
+func interfaceAddrs() string {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return ""
+	}
+	var s string
+	for _, a := range addrs {
+		s += a.String() + " "
+	}
+	return s
+}
@@ -0,0 +1,71 @@
+package tcpserver
+
+import (
+	"errors"
+	"syscall"
+)
+
+// netlink multicast groups of address changes, package syscall doesn't define
+// them.
+const (
+	rtmgrpIPv4IfAddr = 0x10
+	rtmgrpIPv6IfAddr = 0x100
+)
+
+func isAddrNotAvail(err error) bool {
+	return errors.Is(err, syscall.EADDRNOTAVAIL)
+}
+
+// watchAddrs notifies address changes of network interfaces to the returned
+// channel by netlink until stopCh is closed. It falls back to polling if
+// netlink isn't available.
+func watchAddrs(stopCh <-chan struct{}) <-chan struct{} {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_ROUTE)
+	if err != nil {
+		return pollAddrs(stopCh)
+	}
+	sa := &syscall.SockaddrNetlink{
+		Family: syscall.AF_NETLINK,
+		Groups: rtmgrpIPv4IfAddr | rtmgrpIPv6IfAddr,
+	}
+	if err = syscall.Bind(fd, sa); err != nil {
+		syscall.Close(fd)
+		return pollAddrs(stopCh)
+	}
+	// receive timeout lets the goroutine check stopCh.
+	tv := syscall.Timeval{Sec: 1}
+	syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv)
+	changeCh := make(chan struct{}, 1)
+	go func() {
+		defer syscall.Close(fd)
+		buf := make([]byte, 4096)
+		for {
+			select {
+			case <-stopCh:
+				return
+			default:
+			}
+			n, _, err := syscall.Recvfrom(fd, buf, 0)
+			if err != nil {
+				if err == syscall.EAGAIN || err == syscall.EINTR {
+					continue
+				}
+				return
+			}
+			msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+			if err != nil {
+				continue
+			}
+			for _, m := range msgs {
+				if m.Header.Type == syscall.RTM_NEWADDR || m.Header.Type == syscall.RTM_DELADDR {
+					select {
+					case changeCh <- struct{}{}:
+					default:
+					}
+					break
+				}
+			}
+		}
+	}()
+	return changeCh
+}
@@ -0,0 +1,21 @@
+//go:build !linux
+
+package tcpserver
+
+import (
+	"errors"
+	"syscall"
+)
+
+// errWSAEADDRNOTAVAIL is WSAEADDRNOTAVAIL error of Windows.
+const errWSAEADDRNOTAVAIL = syscall.Errno(10049)
+
+func isAddrNotAvail(err error) bool {
+	return errors.Is(err, syscall.EADDRNOTAVAIL) || errors.Is(err, errWSAEADDRNOTAVAIL)
+}
+
+// watchAddrs notifies address changes of network interfaces to the returned
+// channel by polling until stopCh is closed.
+func watchAddrs(stopCh <-chan struct{}) <-chan struct{} {
+	return pollAddrs(stopCh)
+}
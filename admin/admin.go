@@ -0,0 +1,311 @@
+// Package admin provides an HTTP endpoint for operating tcpserver servers. It
+// serves JSON with connections, configuration summary and runtime stats of
+// the server, health states of proxy backends, and actions to close
+// connections and to drain the server.
+//
+// Routes:
+//
+//	GET  /connections             connections of the server
+//	POST /connections/{id}/close  closes the connection
+//	GET  /config                  configuration summary
+//	GET  /stats                   runtime stats
+//	GET  /backends                health states of backends of Pool
+//	POST /drain                   enters drain mode
+//	POST /resume                  resumes accepting
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/orkunkaraduman/go-tcpserver"
+	"github.com/orkunkaraduman/go-tcpserver/proxy"
+)
+
+// DefReadHeaderTimeout specifies read header timeout of admin requests.
+var DefReadHeaderTimeout = 10 * time.Second
+
+var (
+	// ErrEndpointClosed is returned by ListenAndServe after Close method called.
+	ErrEndpointClosed = errors.New("admin endpoint closed")
+)
+
+// An Endpoint serves the admin API of Server.
+type Endpoint struct {
+	// Server to operate.
+	Server *tcpserver.TCPServer
+
+	// Pool optionally provides backends of a proxy. If it is nil, GET
+	// /backends responds with not found.
+	Pool *proxy.Pool
+
+	// Addr is the address to listen on, in form of "host:port" or
+	// "unix:///path/to.sock". It should be reachable only by operators.
+	Addr string
+
+	// ErrorLog specifies an optional logger for errors of the endpoint.
+	ErrorLog *log.Logger
+
+	mu      sync.Mutex
+	httpSrv *http.Server
+	closed  bool
+}
+
+// Handler returns the HTTP handler of the admin API.
+func (e *Endpoint) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /connections", e.connections)
+	mux.HandleFunc("POST /connections/{id}/close", e.closeConn)
+	mux.HandleFunc("GET /config", e.config)
+	mux.HandleFunc("GET /stats", e.stats)
+	mux.HandleFunc("GET /backends", e.backends)
+	mux.HandleFunc("POST /drain", e.drain)
+	mux.HandleFunc("POST /resume", e.resume)
+	return mux
+}
+
+// ListenAndServe listens on Addr and serves the admin API. It returns
+// ErrEndpointClosed after Close.
+func (e *Endpoint) ListenAndServe() error {
+	var l net.Listener
+	var err error
+	if path, ok := strings.CutPrefix(e.Addr, "unix://"); ok {
+		l, err = tcpserver.ListenUnix(path, &tcpserver.UnixSocketOptions{RemoveStale: true})
+	} else {
+		l, err = net.Listen("tcp", e.Addr)
+	}
+	if err != nil {
+		return err
+	}
+	return e.Serve(l)
+}
+
+// Serve serves the admin API on l. It returns ErrEndpointClosed after Close.
+func (e *Endpoint) Serve(l net.Listener) error {
+	e.mu.Lock()
+	if e.closed {
+		e.mu.Unlock()
+		l.Close()
+		return ErrEndpointClosed
+	}
+	if e.httpSrv == nil {
+		e.httpSrv = &http.Server{
+			Handler:           e.Handler(),
+			ReadHeaderTimeout: DefReadHeaderTimeout,
+			ErrorLog:          e.ErrorLog,
+		}
+	}
+	httpSrv := e.httpSrv
+	e.mu.Unlock()
+	err := httpSrv.Serve(l)
+	if err == http.ErrServerClosed {
+		err = ErrEndpointClosed
+	}
+	return err
+}
+
+// Close closes the endpoint. It doesn't affect Server.
+func (e *Endpoint) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.closed = true
+	if e.httpSrv == nil {
+		return nil
+	}
+	return e.httpSrv.Close()
+}
+
+// A Connection is a connection in the admin API.
+type Connection struct {
+	ID           uint64        `json:"id"`
+	RemoteAddr   string        `json:"remote_addr"`
+	LocalAddr    string        `json:"local_addr"`
+	Start        time.Time     `json:"start"`
+	Duration     time.Duration `json:"duration"`
+	State        string        `json:"state"`
+	BytesRead    int64         `json:"bytes_read"`
+	BytesWritten int64         `json:"bytes_written"`
+	Reads        int64         `json:"reads"`
+	Writes       int64         `json:"writes"`
+	ReadRate     float64       `json:"read_rate,omitempty"`
+	WriteRate    float64       `json:"write_rate,omitempty"`
+}
+
+// A Config is the effective configuration summary of the server in the admin
+// API, reflecting reloads of the configuration.
+type Config struct {
+	Addr                string        `json:"addr"`
+	Addrs               []string      `json:"addrs,omitempty"`
+	ListenerAddrs       []string      `json:"listener_addrs"`
+	TLS                 bool          `json:"tls"`
+	MaxConns            int           `json:"max_conns"`
+	MaxConnsPerIP       int           `json:"max_conns_per_ip"`
+	TLSHandshakeTimeout time.Duration `json:"tls_handshake_timeout"`
+	ReadTimeout         time.Duration `json:"read_timeout"`
+	WriteTimeout        time.Duration `json:"write_timeout"`
+	IdleTimeout         time.Duration `json:"idle_timeout"`
+	DrainTimeout        time.Duration `json:"drain_timeout"`
+	Draining            bool          `json:"draining"`
+}
+
+// Stats are server counters and runtime stats in the admin API.
+type Stats struct {
+	Accepted        uint64 `json:"accepted"`
+	Rejected        uint64 `json:"rejected"`
+	Active          int    `json:"active"`
+	Closed          uint64 `json:"closed"`
+	HandshakeErrors uint64 `json:"handshake_errors"`
+	Panics          uint64 `json:"panics"`
+	Goroutines      int    `json:"goroutines"`
+	HeapAlloc       uint64 `json:"heap_alloc"`
+	HeapObjects     uint64 `json:"heap_objects"`
+	Sys             uint64 `json:"sys"`
+	NumGC           uint32 `json:"num_gc"`
+}
+
+// A Backend is the health state of a proxy backend in the admin API.
+type Backend struct {
+	Addr      string    `json:"addr"`
+	Healthy   bool      `json:"healthy"`
+	Checks    uint64    `json:"checks"`
+	Failures  uint64    `json:"failures"`
+	LastCheck time.Time `json:"last_check"`
+	LastError string    `json:"last_error,omitempty"`
+}
+
+func (e *Endpoint) connections(w http.ResponseWriter, r *http.Request) {
+	infos := e.Server.Connections()
+	conns := make([]Connection, 0, len(infos))
+	now := time.Now()
+	for _, info := range infos {
+		conns = append(conns, Connection{
+			ID:           info.ID,
+			RemoteAddr:   info.RemoteAddr.String(),
+			LocalAddr:    info.LocalAddr.String(),
+			Start:        info.Start,
+			Duration:     now.Sub(info.Start),
+			State:        info.State.String(),
+			BytesRead:    info.BytesRead,
+			BytesWritten: info.BytesWritten,
+			Reads:        info.Reads,
+			Writes:       info.Writes,
+			ReadRate:     info.ReadRate,
+			WriteRate:    info.WriteRate,
+		})
+	}
+	writeJSON(w, http.StatusOK, conns)
+}
+
+func (e *Endpoint) closeConn(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := e.Server.CloseConn(id); err != nil {
+		status := http.StatusInternalServerError
+		if err == tcpserver.ErrConnNotFound {
+			status = http.StatusNotFound
+		}
+		writeError(w, status, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (e *Endpoint) config(w http.ResponseWriter, r *http.Request) {
+	srv := e.Server
+	ec := srv.EffectiveConfig()
+	c := Config{
+		Addr:                ec.Addr,
+		Addrs:               ec.Addrs,
+		ListenerAddrs:       []string{},
+		TLS:                 srv.TLSConfig != nil,
+		MaxConns:            ec.MaxConns,
+		MaxConnsPerIP:       ec.MaxConnsPerIP,
+		TLSHandshakeTimeout: time.Duration(ec.TLSHandshakeTimeout),
+		ReadTimeout:         time.Duration(ec.ReadTimeout),
+		WriteTimeout:        time.Duration(ec.WriteTimeout),
+		IdleTimeout:         time.Duration(ec.IdleTimeout),
+		DrainTimeout:        time.Duration(ec.DrainTimeout),
+		Draining:            srv.Draining(),
+	}
+	for _, addr := range srv.ListenerAddrs() {
+		c.ListenerAddrs = append(c.ListenerAddrs, addr.String())
+	}
+	writeJSON(w, http.StatusOK, c)
+}
+
+func (e *Endpoint) stats(w http.ResponseWriter, r *http.Request) {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	st := e.Server.Stats()
+	writeJSON(w, http.StatusOK, Stats{
+		Accepted:        st.Accepted,
+		Rejected:        st.Rejected,
+		Active:          st.Active,
+		Closed:          st.Closed,
+		HandshakeErrors: st.HandshakeErrors,
+		Panics:          st.Panics,
+		Goroutines:      runtime.NumGoroutine(),
+		HeapAlloc:       ms.HeapAlloc,
+		HeapObjects:     ms.HeapObjects,
+		Sys:             ms.Sys,
+		NumGC:           ms.NumGC,
+	})
+}
+
+func (e *Endpoint) backends(w http.ResponseWriter, r *http.Request) {
+	if e.Pool == nil {
+		writeError(w, http.StatusNotFound, errors.New("no backend pool"))
+		return
+	}
+	health := e.Pool.Health()
+	backends := make([]Backend, 0, len(health))
+	for addr, h := range health {
+		b := Backend{
+			Addr:      addr,
+			Healthy:   h.Healthy,
+			Checks:    h.Checks,
+			Failures:  h.Failures,
+			LastCheck: h.LastCheck,
+		}
+		if h.LastError != nil {
+			b.LastError = h.LastError.Error()
+		}
+		backends = append(backends, b)
+	}
+	sort.Slice(backends, func(i, j int) bool {
+		return backends[i].Addr < backends[j].Addr
+	})
+	writeJSON(w, http.StatusOK, backends)
+}
+
+func (e *Endpoint) drain(w http.ResponseWriter, r *http.Request) {
+	e.Server.Drain()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (e *Endpoint) resume(w http.ResponseWriter, r *http.Request) {
+	e.Server.Resume()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
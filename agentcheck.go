@@ -0,0 +1,104 @@
+package tcpserver
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefAgentCheckTimeout specifies write timeout of agent check responses if
+// AgentCheck.Timeout is 0.
+var DefAgentCheckTimeout = 5 * time.Second
+
+// AgentState is operational state reported to HAProxy agent check.
+type AgentState string
+
+// Agent states.
+const (
+	AgentUp    AgentState = "up"
+	AgentDown  AgentState = "down"
+	AgentDrain AgentState = "drain"
+	AgentMaint AgentState = "maint"
+	AgentReady AgentState = "ready"
+)
+
+// AgentStatus defines a response of HAProxy agent check.
+type AgentStatus struct {
+	// State to report. If it is empty, only weight is reported.
+	State AgentState
+
+	// Weight to report in percent. If it is negative, weight isn't reported.
+	Weight int
+
+	// Message optionally specifies a description to report.
+	Message string
+}
+
+// String returns the response line of the status without line terminator.
+func (st AgentStatus) String() string {
+	words := make([]string, 0, 3)
+	if st.State != "" {
+		words = append(words, string(st.State))
+	}
+	if st.Weight >= 0 {
+		words = append(words, strconv.Itoa(st.Weight)+"%")
+	}
+	if st.Message != "" {
+		words = append(words, "#"+strings.NewReplacer("\r", " ", "\n", " ").Replace(st.Message))
+	}
+	return strings.Join(words, " ")
+}
+
+// AgentCheck is a Handler that responds HAProxy agent check with state of
+// Server. It reports drain while Server is in drain mode, and weight derived
+// from load of Server if MaxConns is set. StatusFunc overrides them.
+type AgentCheck struct {
+	// Server to report state of.
+	Server *TCPServer
+
+	// MaxConns specifies connection count of Server at full load. If it is
+	// greater than 0, weight decreases as load of Server increases.
+	MaxConns int
+
+	// StatusFunc optionally returns status to report. It is called with
+	// status derived from Server.
+	StatusFunc func(st AgentStatus) AgentStatus
+
+	// Timeout specifies write timeout of responses. If it is 0,
+	// DefAgentCheckTimeout is used.
+	Timeout time.Duration
+}
+
+// Status returns status to report.
+func (ac *AgentCheck) Status() AgentStatus {
+	st := AgentStatus{
+		State:  AgentUp,
+		Weight: -1,
+	}
+	if ac.Server != nil {
+		if ac.Server.Draining() {
+			st.State = AgentDrain
+		}
+		if ac.MaxConns > 0 {
+			st.Weight = 100 - ac.Server.connCount()*100/ac.MaxConns
+			if st.Weight < 1 {
+				st.Weight = 1
+			}
+		}
+	}
+	if ac.StatusFunc != nil {
+		st = ac.StatusFunc(st)
+	}
+	return st
+}
+
+// Serve implements Handler.Serve.
+func (ac *AgentCheck) Serve(conn net.Conn, closeCh <-chan struct{}) {
+	timeout := ac.Timeout
+	if timeout <= 0 {
+		timeout = DefAgentCheckTimeout
+	}
+	conn.SetWriteDeadline(time.Now().Add(timeout))
+	conn.Write([]byte(ac.Status().String() + "\n"))
+}
@@ -0,0 +1,135 @@
+// Package autocert provides automatic certificate management for tcpserver
+// by golang.org/x/crypto/acme/autocert, such as Let's Encrypt certificates.
+// TLS-ALPN-01 challenges are handled on the TLS listener of the server, and
+// HTTP-01 challenges are handled by ListenAndServeHTTP.
+package autocert
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/orkunkaraduman/go-tcpserver"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// NewManager returns a new autocert.Manager that accepts terms of service of
+// the CA, caches certificates in cacheDir, and manages certificates only for
+// hosts. If cacheDir is empty, certificates aren't cached.
+func NewManager(cacheDir string, hosts ...string) *autocert.Manager {
+	m := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(hosts...),
+	}
+	if cacheDir != "" {
+		m.Cache = autocert.DirCache(cacheDir)
+	}
+	return m
+}
+
+// Configure sets TLSConfig of srv to get certificates from m, and handles
+// TLS-ALPN-01 challenge connections. NextProtos of TLSConfig isn't changed:
+// acme-tls/1 is negotiated only in handshakes of clients offering it, so
+// ALPN of other clients isn't affected. Challenge connections are closed
+// after handshake by a middleware of srv.
+func Configure(srv *tcpserver.TCPServer, m *autocert.Manager) {
+	config := srv.TLSConfig
+	if config == nil {
+		config = tcpserver.DefaultTLSConfig()
+	} else {
+		config = config.Clone()
+	}
+	config.GetCertificate = m.GetCertificate
+	challengeConfig := &tls.Config{
+		GetCertificate: m.GetCertificate,
+		NextProtos:     []string{acme.ALPNProto},
+	}
+	getConfigForClient := config.GetConfigForClient
+	config.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		if slices.Contains(hello.SupportedProtos, acme.ALPNProto) {
+			return challengeConfig, nil
+		}
+		if getConfigForClient != nil {
+			return getConfigForClient(hello)
+		}
+		return nil, nil
+	}
+	srv.TLSConfig = config
+	srv.Use(closeChallenges)
+}
+
+// closeChallenges is a middleware closing TLS-ALPN-01 challenge connections
+// without calling next.
+func closeChallenges(next tcpserver.Handler) tcpserver.Handler {
+	h := &challengeHandler{next: next}
+	if hc, ok := next.(tcpserver.HandlerContext); ok {
+		return &challengeHandlerContext{challengeHandler: h, next: hc}
+	}
+	return h
+}
+
+type challengeHandler struct {
+	next tcpserver.Handler
+}
+
+// Serve implements tcpserver.Handler.Serve.
+func (h *challengeHandler) Serve(conn net.Conn, closeCh <-chan struct{}) {
+	if isChallenge(conn) {
+		return
+	}
+	h.next.Serve(conn, closeCh)
+}
+
+type challengeHandlerContext struct {
+	*challengeHandler
+
+	next tcpserver.HandlerContext
+}
+
+// ServeContext implements tcpserver.HandlerContext.ServeContext.
+func (h *challengeHandlerContext) ServeContext(ctx context.Context, conn net.Conn) {
+	if isChallenge(conn) {
+		return
+	}
+	h.next.ServeContext(ctx, conn)
+}
+
+// isChallenge reports whether acme-tls/1 is negotiated on the connection or
+// a connection wrapped by it.
+func isChallenge(conn net.Conn) bool {
+	for {
+		if tc, ok := conn.(*tls.Conn); ok {
+			return tc.ConnectionState().NegotiatedProtocol == acme.ALPNProto
+		}
+		w, ok := conn.(interface{ NetConn() net.Conn })
+		if !ok {
+			return false
+		}
+		conn = w.NetConn()
+	}
+}
+
+// TLSConfig returns a TLS configuration to get certificates from m with sane
+// defaults, for using without TCPServer. It doesn't handle TLS-ALPN-01
+// challenges.
+func TLSConfig(m *autocert.Manager) *tls.Config {
+	config := tcpserver.DefaultTLSConfig()
+	config.GetCertificate = m.GetCertificate
+	return config
+}
+
+// ListenAndServeHTTP listens on the TCP network address addr, such as ":80",
+// and serves HTTP-01 challenges of m. Other requests are replied with not
+// found.
+func ListenAndServeHTTP(addr string, m *autocert.Manager) error {
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           m.HTTPHandler(http.NotFoundHandler()),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	return srv.ListenAndServe()
+}
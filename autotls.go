@@ -0,0 +1,54 @@
+package tcpserver
+
+import (
+	"bufio"
+	"crypto/tls"
+	"net"
+	"time"
+)
+
+// DefAutoTLSPeekTimeout specifies peek timeout of Router returned by AutoTLS.
+var DefAutoTLSPeekTimeout = 10 * time.Second
+
+// MatchTLS matches connections starting with a TLS handshake record, such as
+// ClientHello.
+func MatchTLS(r *bufio.Reader) bool {
+	buf, err := r.Peek(3)
+	if err != nil {
+		return false
+	}
+	// record type handshake, and major version 3.
+	return buf[0] == 0x16 && buf[1] == 0x03 && buf[2] <= 0x04
+}
+
+// TLSHandler returns a Handler that runs TLS handshake as server on the
+// connection using config, and invokes h with *tls.Conn. If timeout is 0,
+// DefTLSHandshakeTimeout is used. Connections failed handshake are closed.
+func TLSHandler(config *tls.Config, timeout time.Duration, h Handler) Handler {
+	return HandlerFunc(func(conn net.Conn, closeCh <-chan struct{}) {
+		tlsConn, err := StartTLS(conn, config, timeout)
+		if err != nil {
+			return
+		}
+		defer tlsConn.Close()
+		h.Serve(tlsConn, closeCh)
+	})
+}
+
+// AutoTLS returns a Router that serves TLS and plaintext connections on the
+// same listener. Connections starting with a TLS handshake are served over
+// TLS using config by tlsHandler, others are served by plainHandler. If
+// plainHandler is nil, tlsHandler serves plaintext connections too. The
+// protocol must be client speaks first, because detection waits for first
+// bytes of connections.
+func AutoTLS(config *tls.Config, tlsHandler, plainHandler Handler) *Router {
+	if plainHandler == nil {
+		plainHandler = tlsHandler
+	}
+	rt := &Router{
+		PeekTimeout: DefAutoTLSPeekTimeout,
+	}
+	rt.Handle(MatchTLS, TLSHandler(config, 0, tlsHandler))
+	rt.Handle(MatchAny, plainHandler)
+	return rt
+}
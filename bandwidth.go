@@ -0,0 +1,133 @@
+package tcpserver
+
+import (
+	"math"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefBandwidthWindow specifies smoothing window of bandwidth estimation if
+// window is 0.
+var DefBandwidthWindow = 5 * time.Second
+
+// bandwidthSampleInterval specifies minimum interval between samples.
+const bandwidthSampleInterval = 100 * time.Millisecond
+
+// A BandwidthConn is a net.Conn that estimates its throughput continuously.
+// Rates are exponentially weighted moving averages of bytes per second, with
+// the smoothing window as time constant.
+type BandwidthConn struct {
+	net.Conn
+
+	rd rateEstimator
+	wr rateEstimator
+}
+
+// NewBandwidthConn returns a new BandwidthConn with the smoothing window.
+func NewBandwidthConn(conn net.Conn, window time.Duration) *BandwidthConn {
+	if window <= 0 {
+		window = DefBandwidthWindow
+	}
+	now := time.Now()
+	return &BandwidthConn{
+		Conn: conn,
+		rd:   rateEstimator{window: window, last: now},
+		wr:   rateEstimator{window: window, last: now},
+	}
+}
+
+// Read reads data from the connection.
+func (c *BandwidthConn) Read(b []byte) (n int, err error) {
+	n, err = c.Conn.Read(b)
+	c.rd.add(n)
+	return
+}
+
+// Write writes data to the connection.
+func (c *BandwidthConn) Write(b []byte) (n int, err error) {
+	n, err = c.Conn.Write(b)
+	c.wr.add(n)
+	return
+}
+
+// ReadRate returns estimated read throughput in bytes per second.
+func (c *BandwidthConn) ReadRate() float64 {
+	return c.rd.value()
+}
+
+// WriteRate returns estimated write throughput in bytes per second.
+func (c *BandwidthConn) WriteRate() float64 {
+	return c.wr.value()
+}
+
+// ConnBandwidth returns estimated read and write throughput of the
+// connection in bytes per second. It reports false if neither conn nor a
+// connection wrapped by it is a BandwidthConn.
+func ConnBandwidth(conn net.Conn) (readRate, writeRate float64, ok bool) {
+	c := findBandwidthConn(conn)
+	if c == nil {
+		return 0, 0, false
+	}
+	return c.ReadRate(), c.WriteRate(), true
+}
+
+// findBandwidthConn returns the *BandwidthConn of wrapped connection.
+func findBandwidthConn(conn net.Conn) *BandwidthConn {
+	for {
+		if bc, ok := conn.(*BandwidthConn); ok {
+			return bc
+		}
+		w, ok := conn.(interface{ NetConn() net.Conn })
+		if !ok {
+			return nil
+		}
+		conn = w.NetConn()
+	}
+}
+
+type rateEstimator struct {
+	window time.Duration
+	total  int64
+
+	mu        sync.Mutex
+	last      time.Time
+	lastTotal int64
+	rate      float64
+}
+
+func (e *rateEstimator) add(n int) {
+	if n <= 0 {
+		return
+	}
+	atomic.AddInt64(&e.total, int64(n))
+	e.mu.Lock()
+	e.update(time.Now())
+	e.mu.Unlock()
+}
+
+func (e *rateEstimator) value() float64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.update(time.Now())
+	return e.rate
+}
+
+func (e *rateEstimator) update(now time.Time) {
+	elapsed := now.Sub(e.last)
+	if elapsed < bandwidthSampleInterval {
+		return
+	}
+	total := atomic.LoadInt64(&e.total)
+	instant := float64(total-e.lastTotal) / elapsed.Seconds()
+	alpha := 1 - math.Exp(-float64(elapsed)/float64(e.window))
+	e.rate += alpha * (instant - e.rate)
+	e.last = now
+	e.lastTotal = total
+}
+
+// NetConn returns the wrapped connection.
+func (c *BandwidthConn) NetConn() net.Conn {
+	return c.Conn
+}
@@ -0,0 +1,143 @@
+package tcpserver
+
+import (
+	"crypto/tls"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefCertReloadInterval specifies interval of checking certificate files if
+// CertReloader.Interval is 0.
+var DefCertReloadInterval = 10 * time.Second
+
+// CertReloader reloads a certificate and its key from files when they change,
+// by checking their modification times periodically. Use GetCertificate in
+// tls.Config, so new connections are served with the current certificate
+// without restarting the server or dropping existing connections.
+type CertReloader struct {
+	// Interval specifies interval of checking files. If it is 0,
+	// DefCertReloadInterval is used.
+	Interval time.Duration
+
+	// Reload callback. It will be called after each reload attempt. If err
+	// isn't nil, the previous certificate is kept.
+	OnReload func(err error)
+
+	certFile string
+	keyFile  string
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	modTime time.Time
+	stopCh  chan struct{}
+	doneCh  chan struct{}
+}
+
+// NewCertReloader returns a new CertReloader with the certificate loaded from
+// files.
+func NewCertReloader(certFile, keyFile string) (*CertReloader, error) {
+	cr := &CertReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+	}
+	if err := cr.Reload(); err != nil {
+		return nil, err
+	}
+	return cr, nil
+}
+
+// GetCertificate returns the current certificate. It can be used as
+// tls.Config.GetCertificate.
+func (cr *CertReloader) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cr.mu.RLock()
+	defer cr.mu.RUnlock()
+	return cr.cert, nil
+}
+
+// Reload loads the certificate from files immediately.
+func (cr *CertReloader) Reload() error {
+	modTime, err := cr.filesModTime()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(cr.certFile, cr.keyFile)
+	if err != nil {
+		return err
+	}
+	cr.mu.Lock()
+	cr.cert = &cert
+	cr.modTime = modTime
+	cr.mu.Unlock()
+	return nil
+}
+
+// Start starts checking files.
+func (cr *CertReloader) Start() {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	if cr.stopCh != nil {
+		return
+	}
+	cr.stopCh = make(chan struct{})
+	cr.doneCh = make(chan struct{})
+	go cr.run(cr.stopCh, cr.doneCh)
+}
+
+// Stop stops checking files.
+func (cr *CertReloader) Stop() {
+	cr.mu.Lock()
+	stopCh, doneCh := cr.stopCh, cr.doneCh
+	cr.stopCh, cr.doneCh = nil, nil
+	cr.mu.Unlock()
+	if stopCh == nil {
+		return
+	}
+	close(stopCh)
+	<-doneCh
+}
+
+func (cr *CertReloader) run(stopCh, doneCh chan struct{}) {
+	defer close(doneCh)
+	interval := cr.Interval
+	if interval <= 0 {
+		interval = DefCertReloadInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-stopCh:
+			return
+		}
+		modTime, err := cr.filesModTime()
+		if err == nil {
+			cr.mu.RLock()
+			changed := !modTime.Equal(cr.modTime)
+			cr.mu.RUnlock()
+			if !changed {
+				continue
+			}
+			err = cr.Reload()
+		}
+		if cr.OnReload != nil {
+			cr.OnReload(err)
+		}
+	}
+}
+
+// filesModTime returns the latest modification time of the files.
+func (cr *CertReloader) filesModTime() (time.Time, error) {
+	var modTime time.Time
+	for _, name := range []string{cr.certFile, cr.keyFile} {
+		fi, err := os.Stat(name)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if fi.ModTime().After(modTime) {
+			modTime = fi.ModTime()
+		}
+	}
+	return modTime, nil
+}
@@ -0,0 +1,159 @@
+package tcpserver
+
+import (
+	"errors"
+	"io"
+	"sync/atomic"
+	"syscall"
+)
+
+// A CloseReason represents why a connection of TCPServer ended.
+type CloseReason int32
+
+// Close reasons.
+const (
+	// CloseUnknown is the reason of a connection not closed yet.
+	CloseUnknown CloseReason = iota
+
+	// CloseHandlerReturned is the reason of a connection closed after
+	// Handler returned.
+	CloseHandlerReturned
+
+	// ClosePeerClosed is the reason of a connection closed by the peer.
+	// Peer closes are detected if CountBytes, AccessLog or IdleTimeout is set.
+	ClosePeerClosed
+
+	// ClosePeerReset is the reason of a connection reset by the peer. Peer
+	// resets are detected if CountBytes, AccessLog or IdleTimeout is set.
+	ClosePeerReset
+
+	// CloseIdleTimeout is the reason of a connection closed by IdleTimeout.
+	CloseIdleTimeout
+
+	// CloseFirstByteTimeout is the reason of a connection closed by
+	// FirstByteTimeout.
+	CloseFirstByteTimeout
+
+	// CloseShutdown is the reason of a connection ended by Shutdown.
+	CloseShutdown
+
+	// CloseForced is the reason of a connection closed by Close or by
+	// expired Shutdown.
+	CloseForced
+
+	// CloseKilled is the reason of a connection closed by CloseConn.
+	CloseKilled
+
+	// CloseHandshakeFailed is the reason of a connection with failed TLS
+	// handshake.
+	CloseHandshakeFailed
+
+	// CloseProxyError is the reason of a connection with an invalid PROXY
+	// protocol header.
+	CloseProxyError
+
+	// CloseFiltered is the reason of a connection denied by IPFilter.
+	CloseFiltered
+
+	// CloseTooManyPerIP is the reason of a connection exceeded
+	// MaxConnsPerIP.
+	CloseTooManyPerIP
+
+	// CloseRejected is the reason of a connection rejected by OnConnect.
+	CloseRejected
+
+	// CloseGreetingFailed is the reason of a connection failed to write
+	// greeting.
+	CloseGreetingFailed
+
+	// ClosePanic is the reason of a connection closed after Handler panicked.
+	ClosePanic
+
+	// CloseHijacked is the reason of a hijacked connection.
+	CloseHijacked
+)
+
+var closeReasonNames = map[CloseReason]string{
+	CloseUnknown:          "unknown",
+	CloseHandlerReturned:  "handler returned",
+	ClosePeerClosed:       "peer closed",
+	ClosePeerReset:        "peer reset",
+	CloseIdleTimeout:      "idle timeout",
+	CloseFirstByteTimeout: "first byte timeout",
+	CloseShutdown:         "server shutdown",
+	CloseForced:           "force close",
+	CloseKilled:           "killed",
+	CloseHandshakeFailed:  "TLS handshake failed",
+	CloseProxyError:       "PROXY protocol error",
+	CloseFiltered:         "IP filtered",
+	CloseTooManyPerIP:     "too many connections per IP",
+	CloseRejected:         "rejected by OnConnect",
+	CloseGreetingFailed:   "greeting failed",
+	ClosePanic:            "handler panic",
+	CloseHijacked:         "hijacked",
+}
+
+func (r CloseReason) String() string {
+	if name, ok := closeReasonNames[r]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// A CloseError is passed to OnDisconnect when a connection ends by any reason
+// other than CloseHandlerReturned.
+type CloseError struct {
+	Reason CloseReason
+
+	// Err is the underlying error if any, like TLS handshake error,
+	// ErrHandlerPanic or the read error from the peer.
+	Err error
+}
+
+func (e *CloseError) Error() string {
+	if e.Err == nil {
+		return "connection closed: " + e.Reason.String()
+	}
+	return "connection closed: " + e.Reason.String() + ": " + e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *CloseError) Unwrap() error {
+	return e.Err
+}
+
+// setCloseReason sets close reason of the connection if it isn't set, and
+// reports whether it is set.
+func (cc *connContext) setCloseReason(r CloseReason) bool {
+	return atomic.CompareAndSwapInt32(&cc.closeReason, int32(CloseUnknown), int32(r))
+}
+
+// forceCloseReason sets close reason of the connection, overriding the
+// previous one.
+func (cc *connContext) forceCloseReason(r CloseReason) {
+	atomic.StoreInt32(&cc.closeReason, int32(r))
+}
+
+// getCloseReason returns close reason of the connection.
+func (cc *connContext) getCloseReason() CloseReason {
+	return CloseReason(atomic.LoadInt32(&cc.closeReason))
+}
+
+// observeReadErr sets close reason of the connection by the read error
+// caused by the peer.
+func (cc *connContext) observeReadErr(err error) {
+	var r CloseReason
+	switch {
+	case err == nil:
+		return
+	case err == io.EOF:
+		r = ClosePeerClosed
+	case errors.Is(err, syscall.ECONNRESET):
+		r = ClosePeerReset
+	default:
+		return
+	}
+	if cc.setCloseReason(r) {
+		cc.peerErr.Store(&err)
+	}
+}
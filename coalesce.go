@@ -0,0 +1,115 @@
+package tcpserver
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// DefCoalesceWindow specifies flush window of CoalescingConn if window is 0.
+var DefCoalesceWindow = 1 * time.Millisecond
+
+// DefCoalesceSize specifies flush size of CoalescingConn if size is 0.
+var DefCoalesceSize = 32 * 1024
+
+// A CoalescingConn is a net.Conn that batches small writes into fewer
+// writes to the underlying connection. Buffered data is written when flush
+// window passes after the first buffered write, or buffered data reaches
+// flush size. Errors of delayed writes are returned by subsequent calls.
+type CoalescingConn struct {
+	net.Conn
+
+	window time.Duration
+	size   int
+
+	mu    sync.Mutex
+	buf   []byte
+	timer *time.Timer
+	err   error
+}
+
+// NewCoalescingConn returns a new CoalescingConn with the flush window and
+// flush size.
+func NewCoalescingConn(conn net.Conn, window time.Duration, size int) *CoalescingConn {
+	if window <= 0 {
+		window = DefCoalesceWindow
+	}
+	if size <= 0 {
+		size = DefCoalesceSize
+	}
+	return &CoalescingConn{
+		Conn:   conn,
+		window: window,
+		size:   size,
+	}
+}
+
+// Write buffers b, or writes it to the connection if flush size is reached.
+func (c *CoalescingConn) Write(b []byte) (n int, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return 0, c.err
+	}
+	if len(c.buf) == 0 && len(b) >= c.size {
+		n, c.err = c.Conn.Write(b)
+		return n, c.err
+	}
+	c.buf = append(c.buf, b...)
+	if len(c.buf) >= c.size {
+		if err = c.flush(); err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+	if c.timer == nil {
+		c.timer = time.AfterFunc(c.window, func() {
+			c.mu.Lock()
+			c.timer = nil
+			c.flush()
+			c.mu.Unlock()
+		})
+	}
+	return len(b), nil
+}
+
+// Flush writes buffered data to the connection.
+func (c *CoalescingConn) Flush() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.flush()
+}
+
+func (c *CoalescingConn) flush() error {
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+	if c.err != nil || len(c.buf) == 0 {
+		return c.err
+	}
+	_, c.err = c.Conn.Write(c.buf)
+	c.buf = c.buf[:0]
+	return c.err
+}
+
+// Close flushes buffered data and closes the connection.
+func (c *CoalescingConn) Close() error {
+	c.Flush()
+	return c.Conn.Close()
+}
+
+// CoalesceWrites returns a Handler that invokes h with CoalescingConn of the
+// connection. Buffered data is flushed after h returns.
+func CoalesceWrites(h Handler, window time.Duration, size int) Handler {
+	return HandlerFunc(func(conn net.Conn, closeCh <-chan struct{}) {
+		c := NewCoalescingConn(conn, window, size)
+		defer c.Flush()
+		h.Serve(c, closeCh)
+	})
+}
+
+// NetConn returns the wrapped connection.
+func (c *CoalescingConn) NetConn() net.Conn {
+	return c.Conn
+}
@@ -0,0 +1,380 @@
+// Package compress provides stream compression layer for tcpserver.
+package compress
+
+import (
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/orkunkaraduman/go-tcpserver"
+)
+
+// An Algorithm is a compression algorithm.
+type Algorithm string
+
+// Compression algorithms.
+const (
+	None   Algorithm = "none"
+	Gzip   Algorithm = "gzip"
+	Snappy Algorithm = "snappy"
+	Zstd   Algorithm = "zstd"
+)
+
+// DefNegotiationTimeout specifies negotiation timeout if
+// Server.NegotiationTimeout is 0.
+var DefNegotiationTimeout = 10 * time.Second
+
+// maxNegotiationLineSize is maximum size of negotiation line.
+const maxNegotiationLineSize = 256
+
+var (
+	// ErrUnknownAlgorithm is returned when compression algorithm is unknown.
+	ErrUnknownAlgorithm = errors.New("unknown compression algorithm")
+)
+
+var (
+	gzipWriterPool   sync.Pool
+	gzipReaderPool   sync.Pool
+	snappyWriterPool sync.Pool
+	snappyReaderPool sync.Pool
+	zstdWriterPool   sync.Pool
+	zstdReaderPool   sync.Pool
+)
+
+type flushWriteCloser interface {
+	io.WriteCloser
+	Flush() error
+}
+
+// A Conn is a net.Conn compressing written data and decompressing read data.
+// Written data is flushed at end of each Write.
+type Conn struct {
+	net.Conn
+
+	alg Algorithm
+
+	rdMu      sync.Mutex
+	rd        io.Reader
+	rdRelease func()
+	wrMu      sync.Mutex
+	wr        flushWriteCloser
+	wrRelease func()
+	closeOnce sync.Once
+	rdClosed  bool
+	wrClosed  bool
+}
+
+// NewConn returns a new Conn using the algorithm on conn.
+func NewConn(conn net.Conn, alg Algorithm) (*Conn, error) {
+	switch alg {
+	case None, Gzip, Snappy, Zstd:
+	default:
+		return nil, ErrUnknownAlgorithm
+	}
+	c := &Conn{
+		Conn: conn,
+		alg:  alg,
+	}
+	switch alg {
+	case Gzip:
+		w, _ := gzipWriterPool.Get().(*gzip.Writer)
+		if w == nil {
+			w = gzip.NewWriter(conn)
+		} else {
+			w.Reset(conn)
+		}
+		c.wr = w
+		c.wrRelease = func() { gzipWriterPool.Put(w) }
+	case Snappy:
+		w, _ := snappyWriterPool.Get().(*snappy.Writer)
+		if w == nil {
+			w = snappy.NewBufferedWriter(conn)
+		} else {
+			w.Reset(conn)
+		}
+		c.wr = w
+		c.wrRelease = func() { snappyWriterPool.Put(w) }
+	case Zstd:
+		w, _ := zstdWriterPool.Get().(*zstd.Encoder)
+		if w == nil {
+			var err error
+			w, err = zstd.NewWriter(conn, zstd.WithEncoderConcurrency(1))
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			w.Reset(conn)
+		}
+		c.wr = w
+		c.wrRelease = func() { zstdWriterPool.Put(w) }
+	}
+	return c, nil
+}
+
+// Algorithm returns compression algorithm of the connection.
+func (c *Conn) Algorithm() Algorithm {
+	return c.alg
+}
+
+// reader initializes decompressor lazily, because some decompressors read
+// stream header on creation.
+func (c *Conn) reader() (io.Reader, error) {
+	if c.rd != nil {
+		return c.rd, nil
+	}
+	switch c.alg {
+	case None:
+		c.rd = c.Conn
+	case Gzip:
+		r, _ := gzipReaderPool.Get().(*gzip.Reader)
+		var err error
+		if r == nil {
+			r, err = gzip.NewReader(c.Conn)
+		} else {
+			err = r.Reset(c.Conn)
+		}
+		if err != nil {
+			return nil, err
+		}
+		r.Multistream(false)
+		c.rd = r
+		c.rdRelease = func() { gzipReaderPool.Put(r) }
+	case Snappy:
+		r, _ := snappyReaderPool.Get().(*snappy.Reader)
+		if r == nil {
+			r = snappy.NewReader(c.Conn)
+		} else {
+			r.Reset(c.Conn)
+		}
+		c.rd = r
+		c.rdRelease = func() { snappyReaderPool.Put(r) }
+	case Zstd:
+		r, _ := zstdReaderPool.Get().(*zstd.Decoder)
+		var err error
+		if r == nil {
+			r, err = zstd.NewReader(c.Conn, zstd.WithDecoderConcurrency(1))
+		} else {
+			err = r.Reset(c.Conn)
+		}
+		if err != nil {
+			return nil, err
+		}
+		c.rd = r
+		c.rdRelease = func() {
+			r.Reset(nil)
+			zstdReaderPool.Put(r)
+		}
+	}
+	return c.rd, nil
+}
+
+// Read reads decompressed data from the connection.
+func (c *Conn) Read(b []byte) (n int, err error) {
+	c.rdMu.Lock()
+	defer c.rdMu.Unlock()
+	if c.rdClosed {
+		return 0, net.ErrClosed
+	}
+	rd, err := c.reader()
+	if err != nil {
+		return
+	}
+	return rd.Read(b)
+}
+
+// Write compresses and writes data to the connection.
+func (c *Conn) Write(b []byte) (n int, err error) {
+	c.wrMu.Lock()
+	defer c.wrMu.Unlock()
+	if c.wrClosed {
+		return 0, net.ErrClosed
+	}
+	if c.wr == nil {
+		return c.Conn.Write(b)
+	}
+	if n, err = c.wr.Write(b); err != nil {
+		return
+	}
+	err = c.wr.Flush()
+	return
+}
+
+// Close finishes compressed stream, releases compressors and closes the
+// connection. Reads and writes after Close return net.ErrClosed.
+func (c *Conn) Close() error {
+	err := net.ErrClosed
+	c.closeOnce.Do(func() {
+		c.wrMu.Lock()
+		c.wrClosed = true
+		if c.wr != nil {
+			c.wr.Close()
+			c.wrRelease()
+			c.wr, c.wrRelease = nil, nil
+		}
+		c.wrMu.Unlock()
+		// closing the connection unblocks pending reads.
+		err = c.Conn.Close()
+		c.rdMu.Lock()
+		c.rdClosed = true
+		if c.rdRelease != nil {
+			c.rdRelease()
+		}
+		c.rd, c.rdRelease = nil, nil
+		c.rdMu.Unlock()
+	})
+	return err
+}
+
+// Server defines parameters for compression layer as a tcpserver.Handler. It
+// wraps the connection with *Conn and invokes Handler with it.
+//
+// If Negotiate is true, client starts with a line of comma separated
+// algorithms in order of preference, and server replies a line of chosen
+// algorithm. Lines are terminated by LF. Chosen algorithm is the first
+// algorithm of client supported in Algorithms, or "none".
+type Server struct {
+	// Handler to invoke. It should close the connection to finish compressed
+	// stream cleanly.
+	Handler tcpserver.Handler
+
+	// Algorithm to use if Negotiate is false.
+	Algorithm Algorithm
+
+	// Negotiate enables negotiation of algorithm.
+	Negotiate bool
+
+	// Algorithms supported in negotiation. If it is empty, all algorithms
+	// are supported.
+	Algorithms []Algorithm
+
+	// NegotiationTimeout specifies maximum duration of negotiation.
+	NegotiationTimeout time.Duration
+
+	// MemoryBudget optionally limits memory of codecs. Approximate memory of
+	// codecs of the algorithm is reserved for each connection, and the
+	// connection is closed if it exceeds the budget.
+	MemoryBudget *tcpserver.MemoryBudget
+}
+
+// codecMemory returns approximate memory of a compressor and a decompressor of
+// the algorithm in bytes.
+func codecMemory(alg Algorithm) int {
+	switch alg {
+	case Gzip:
+		return 1 << 20
+	case Snappy:
+		return 256 << 10
+	case Zstd:
+		return 8 << 20
+	}
+	return 0
+}
+
+// Serve implements tcpserver.Handler.Serve.
+func (srv *Server) Serve(conn net.Conn, closeCh <-chan struct{}) {
+	alg := srv.Algorithm
+	if srv.Negotiate {
+		var err error
+		if alg, conn, err = srv.negotiate(conn); err != nil {
+			return
+		}
+	}
+	if srv.MemoryBudget != nil {
+		mem := srv.MemoryBudget.NewAccount()
+		defer mem.Close()
+		if mem.Reserve(codecMemory(alg)) != nil {
+			return
+		}
+	}
+	c, err := NewConn(conn, alg)
+	if err != nil {
+		return
+	}
+	defer c.Close()
+	if srv.Handler != nil {
+		srv.Handler.Serve(c, closeCh)
+	}
+}
+
+func (srv *Server) supports(alg Algorithm) bool {
+	if len(srv.Algorithms) == 0 {
+		switch alg {
+		case None, Gzip, Snappy, Zstd:
+			return true
+		}
+		return false
+	}
+	for _, a := range srv.Algorithms {
+		if a == alg {
+			return true
+		}
+	}
+	return false
+}
+
+func (srv *Server) negotiate(conn net.Conn) (alg Algorithm, _ net.Conn, err error) {
+	timeout := srv.NegotiationTimeout
+	if timeout <= 0 {
+		timeout = DefNegotiationTimeout
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+	defer conn.SetDeadline(time.Time{})
+	rd := bufio.NewReader(conn)
+	line, err := tcpserver.ReadBytesLimit(rd, '\n', maxNegotiationLineSize)
+	if err != nil {
+		return
+	}
+	alg = None
+	for _, s := range strings.Split(strings.TrimSpace(string(line)), ",") {
+		if a := Algorithm(strings.TrimSpace(s)); srv.supports(a) {
+			alg = a
+			break
+		}
+	}
+	if _, err = conn.Write([]byte(string(alg) + "\n")); err != nil {
+		return
+	}
+	return alg, &bufferedConn{Conn: conn, rd: rd}, nil
+}
+
+// bufferedConn is a net.Conn reading from buffered reader.
+type bufferedConn struct {
+	net.Conn
+
+	rd *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.rd.Read(b)
+}
+
+// Negotiate negotiates algorithm as client on conn with algorithms in order of
+// preference, and returns a new Conn using the chosen algorithm. If timeout is
+// 0, DefNegotiationTimeout is used.
+func Negotiate(conn net.Conn, algs []Algorithm, timeout time.Duration) (*Conn, error) {
+	if timeout <= 0 {
+		timeout = DefNegotiationTimeout
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+	defer conn.SetDeadline(time.Time{})
+	names := make([]string, 0, len(algs))
+	for _, a := range algs {
+		names = append(names, string(a))
+	}
+	if _, err := conn.Write([]byte(strings.Join(names, ",") + "\n")); err != nil {
+		return nil, err
+	}
+	rd := bufio.NewReader(conn)
+	line, err := tcpserver.ReadBytesLimit(rd, '\n', maxNegotiationLineSize)
+	if err != nil {
+		return nil, err
+	}
+	return NewConn(&bufferedConn{Conn: conn, rd: rd}, Algorithm(strings.TrimSpace(string(line))))
+}
@@ -0,0 +1,313 @@
+package tcpserver
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"io"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// A Duration is a time.Duration marshaled as text like "1m30s", for
+// configuration files.
+type Duration time.Duration
+
+// MarshalText implements encoding.TextMarshaler.
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// A ServerConfig is a serializable configuration of TCPServer, for loading
+// from JSON or YAML files. Zero values keep defaults of the server.
+type ServerConfig struct {
+	Addr  string   `json:"addr,omitempty" yaml:"addr,omitempty"`
+	Addrs []string `json:"addrs,omitempty" yaml:"addrs,omitempty"`
+
+	// TLSCertFile and TLSKeyFile enable TLS with the certificate of the
+	// files.
+	TLSCertFile string `json:"tls_cert_file,omitempty" yaml:"tls_cert_file,omitempty"`
+	TLSKeyFile  string `json:"tls_key_file,omitempty" yaml:"tls_key_file,omitempty"`
+
+	TLSHandshakeTimeout Duration `json:"tls_handshake_timeout,omitempty" yaml:"tls_handshake_timeout,omitempty"`
+	ReadTimeout         Duration `json:"read_timeout,omitempty" yaml:"read_timeout,omitempty"`
+	WriteTimeout        Duration `json:"write_timeout,omitempty" yaml:"write_timeout,omitempty"`
+	IdleTimeout         Duration `json:"idle_timeout,omitempty" yaml:"idle_timeout,omitempty"`
+	DrainTimeout        Duration `json:"drain_timeout,omitempty" yaml:"drain_timeout,omitempty"`
+
+	MaxConns      int `json:"max_conns,omitempty" yaml:"max_conns,omitempty"`
+	MaxConnsPerIP int `json:"max_conns_per_ip,omitempty" yaml:"max_conns_per_ip,omitempty"`
+}
+
+// LoadConfig decodes a JSON configuration from r. YAML configurations can be
+// decoded into ServerConfig by a YAML library supporting
+// encoding.TextUnmarshaler.
+func LoadConfig(r io.Reader) (*ServerConfig, error) {
+	c := &ServerConfig{}
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(c); err != nil {
+		return nil, err
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// LoadConfigFile decodes a JSON configuration from the file.
+func LoadConfigFile(name string) (*ServerConfig, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return LoadConfig(f)
+}
+
+// Validate checks the configuration for invalid values.
+func (c *ServerConfig) Validate() error {
+	durations := []struct {
+		field string
+		d     Duration
+	}{
+		{"TLSHandshakeTimeout", c.TLSHandshakeTimeout},
+		{"ReadTimeout", c.ReadTimeout},
+		{"WriteTimeout", c.WriteTimeout},
+		{"IdleTimeout", c.IdleTimeout},
+		{"DrainTimeout", c.DrainTimeout},
+	}
+	for _, d := range durations {
+		if d.d < 0 {
+			return &ConfigError{Field: d.field, Reason: "is negative"}
+		}
+	}
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		return &ConfigError{Field: "TLSCertFile", Reason: "needs TLSKeyFile"}
+	}
+	if c.MaxConns < 0 && c.MaxConns != AutoMaxConns {
+		return &ConfigError{Field: "MaxConns", Reason: "is negative"}
+	}
+	if c.MaxConnsPerIP < 0 {
+		return &ConfigError{Field: "MaxConnsPerIP", Reason: "is negative"}
+	}
+	if c.MaxConns > 0 && c.MaxConnsPerIP > c.MaxConns {
+		return &ConfigError{Field: "MaxConnsPerIP", Reason: "exceeds MaxConns"}
+	}
+	return nil
+}
+
+// ApplyConfig sets fields of the server from the configuration. It should be
+// called before serving, use ReloadConfig for a running server. If TLS files
+// are set, TLSConfig is set to DefaultTLSConfig with the certificate, so the
+// server should be served by ListenAndServeTLS with empty file names.
+func (srv *TCPServer) ApplyConfig(c *ServerConfig) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	if c.TLSCertFile != "" {
+		cr, err := NewCertReloader(c.TLSCertFile, c.TLSKeyFile)
+		if err != nil {
+			return err
+		}
+		srv.configCert.Store(cr)
+		config := DefaultTLSConfig()
+		config.GetCertificate = func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return srv.configCert.Load().GetCertificate(hello)
+		}
+		srv.TLSConfig = config
+	}
+	srv.Addr, srv.Addrs = c.Addr, c.Addrs
+	srv.TLSHandshakeTimeout = time.Duration(c.TLSHandshakeTimeout)
+	srv.ReadTimeout = time.Duration(c.ReadTimeout)
+	srv.WriteTimeout = time.Duration(c.WriteTimeout)
+	srv.IdleTimeout = time.Duration(c.IdleTimeout)
+	srv.DrainTimeout = time.Duration(c.DrainTimeout)
+	srv.MaxConns = c.MaxConns
+	srv.MaxConnsPerIP = c.MaxConnsPerIP
+	return nil
+}
+
+// ReloadConfig applies the configuration to the running server without
+// restarting listeners. Timeouts and limits apply to new connections, and
+// MaxConns applies to next accepts. The certificate is reloaded if the server
+// is configured with TLS files by ApplyConfig. Changes of addresses and of
+// enabling TLS need restart, and they are ignored. The configuration replaces
+// all of the fields it covers, but the fields of the server aren't modified.
+func (srv *TCPServer) ReloadConfig(c *ServerConfig) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	if c.TLSCertFile != "" && srv.configCert.Load() != nil {
+		cr, err := NewCertReloader(c.TLSCertFile, c.TLSKeyFile)
+		if err != nil {
+			return err
+		}
+		srv.configCert.Store(cr)
+	}
+	c2 := *c
+	srv.reloaded.Store(&c2)
+	return nil
+}
+
+// EffectiveConfig returns the configuration the server currently applies to
+// new connections, reflecting the last ReloadConfig. Addresses are always
+// taken from the fields of the server, because reloads don't change them.
+// TLS file names are set only if they are given by the last ReloadConfig.
+func (srv *TCPServer) EffectiveConfig() ServerConfig {
+	c := ServerConfig{
+		Addr:                srv.Addr,
+		Addrs:               srv.Addrs,
+		TLSHandshakeTimeout: Duration(srv.tlsHandshakeTimeout()),
+		ReadTimeout:         Duration(srv.readTimeout()),
+		WriteTimeout:        Duration(srv.writeTimeout()),
+		IdleTimeout:         Duration(srv.idleTimeout()),
+		DrainTimeout:        Duration(srv.drainTimeout()),
+		MaxConns:            srv.maxConnsField(),
+		MaxConnsPerIP:       srv.maxConnsPerIP(),
+	}
+	if r := srv.reloaded.Load(); r != nil {
+		c.TLSCertFile, c.TLSKeyFile = r.TLSCertFile, r.TLSKeyFile
+	}
+	return c
+}
+
+// Accessors of the fields changed by ReloadConfig.
+
+func (srv *TCPServer) tlsHandshakeTimeout() time.Duration {
+	if c := srv.reloaded.Load(); c != nil {
+		return time.Duration(c.TLSHandshakeTimeout)
+	}
+	return srv.TLSHandshakeTimeout
+}
+
+func (srv *TCPServer) readTimeout() time.Duration {
+	if c := srv.reloaded.Load(); c != nil {
+		return time.Duration(c.ReadTimeout)
+	}
+	return srv.ReadTimeout
+}
+
+func (srv *TCPServer) writeTimeout() time.Duration {
+	if c := srv.reloaded.Load(); c != nil {
+		return time.Duration(c.WriteTimeout)
+	}
+	return srv.WriteTimeout
+}
+
+func (srv *TCPServer) idleTimeout() time.Duration {
+	if c := srv.reloaded.Load(); c != nil {
+		return time.Duration(c.IdleTimeout)
+	}
+	return srv.IdleTimeout
+}
+
+func (srv *TCPServer) drainTimeout() time.Duration {
+	if c := srv.reloaded.Load(); c != nil {
+		return time.Duration(c.DrainTimeout)
+	}
+	return srv.DrainTimeout
+}
+
+func (srv *TCPServer) maxConnsField() int {
+	if c := srv.reloaded.Load(); c != nil {
+		return c.MaxConns
+	}
+	return srv.MaxConns
+}
+
+func (srv *TCPServer) maxConnsPerIP() int {
+	if c := srv.reloaded.Load(); c != nil {
+		return c.MaxConnsPerIP
+	}
+	return srv.MaxConnsPerIP
+}
+
+// A ConfigReloader reloads the configuration of Server from a JSON file when
+// the process receives Signal.
+type ConfigReloader struct {
+	// Server to reload.
+	Server *TCPServer
+
+	// Path of the configuration file.
+	Path string
+
+	// Signal triggering reloads. If it is nil, SIGHUP is used.
+	Signal os.Signal
+
+	// Reload callback. It will be called after each reload attempt. If err
+	// isn't nil, the previous configuration is kept.
+	OnReload func(err error)
+
+	mu     sync.Mutex
+	stopCh chan struct{}
+	doneCh chan struct{}
+}
+
+// Reload reloads the configuration file immediately.
+func (cr *ConfigReloader) Reload() error {
+	c, err := LoadConfigFile(cr.Path)
+	if err != nil {
+		return err
+	}
+	return cr.Server.ReloadConfig(c)
+}
+
+// Start starts listening for the signal.
+func (cr *ConfigReloader) Start() {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	if cr.stopCh != nil {
+		return
+	}
+	cr.stopCh = make(chan struct{})
+	cr.doneCh = make(chan struct{})
+	sig := cr.Signal
+	if sig == nil {
+		sig = syscall.SIGHUP
+	}
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, sig)
+	go cr.run(sigCh, cr.stopCh, cr.doneCh)
+}
+
+// Stop stops listening for the signal.
+func (cr *ConfigReloader) Stop() {
+	cr.mu.Lock()
+	stopCh, doneCh := cr.stopCh, cr.doneCh
+	cr.stopCh, cr.doneCh = nil, nil
+	cr.mu.Unlock()
+	if stopCh == nil {
+		return
+	}
+	close(stopCh)
+	<-doneCh
+}
+
+func (cr *ConfigReloader) run(sigCh chan os.Signal, stopCh, doneCh chan struct{}) {
+	defer close(doneCh)
+	defer signal.Stop(sigCh)
+	for {
+		select {
+		case <-sigCh:
+		case <-stopCh:
+			return
+		}
+		err := cr.Reload()
+		if cr.OnReload != nil {
+			cr.OnReload(err)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package tcpserver
+
+import (
+	"net"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// A ConnInfo is a snapshot of a connection tracked by the server.
+type ConnInfo struct {
+	// ID is the identifier of the connection. IDs are assigned in order of
+	// accept, starting from 1, and they aren't reused.
+	ID         uint64
+	RemoteAddr net.Addr
+	LocalAddr  net.Addr
+	Start      time.Time
+	State      ConnState
+
+	// Counters are counted if CountBytes or AccessLog is set.
+	BytesRead    int64
+	BytesWritten int64
+	Reads        int64
+	Writes       int64
+
+	// Rates are estimated in bytes per second if EstimateBandwidth is set.
+	ReadRate  float64
+	WriteRate float64
+}
+
+// info returns the snapshot of the connection.
+func (cc *connContext) info() ConnInfo {
+	info := ConnInfo{
+		ID:         cc.id,
+		RemoteAddr: cc.conn.RemoteAddr(),
+		LocalAddr:  cc.conn.LocalAddr(),
+		Start:      cc.start,
+		State:      ConnState(atomic.LoadInt32(&cc.state)),
+	}
+	info.BytesRead, info.BytesWritten, info.Reads, info.Writes = cc.counts()
+	if bc := cc.bandwidth; bc != nil {
+		info.ReadRate, info.WriteRate = bc.ReadRate(), bc.WriteRate()
+	}
+	return info
+}
+
+// Connections returns snapshots of the connections tracked by the server,
+// ordered by ID.
+func (srv *TCPServer) Connections() []ConnInfo {
+	srv.connsMu.RLock()
+	ccs := make([]*connContext, 0, len(srv.conns))
+	for _, cc := range srv.conns {
+		ccs = append(ccs, cc)
+	}
+	srv.connsMu.RUnlock()
+	infos := make([]ConnInfo, 0, len(ccs))
+	for _, cc := range ccs {
+		infos = append(infos, cc.info())
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].ID < infos[j].ID
+	})
+	return infos
+}
+
+// ConnID returns ID of the connection passed to Handler or the underlying one.
+// It returns false if the connection isn't tracked by the server.
+func (srv *TCPServer) ConnID(conn net.Conn) (uint64, bool) {
+	if cc := srv.lookupConn(conn); cc != nil {
+		return cc.id, true
+	}
+	return 0, false
+}
+
+// CloseConn closes the connection with the ID. It returns ErrConnNotFound if
+// the connection isn't tracked by the server.
+func (srv *TCPServer) CloseConn(id uint64) error {
+	srv.connsMu.RLock()
+	var c *connContext
+	for _, cc := range srv.conns {
+		if cc.id == id {
+			c = cc
+			break
+		}
+	}
+	srv.connsMu.RUnlock()
+	if c == nil {
+		return ErrConnNotFound
+	}
+	c.forceCloseReason(CloseKilled)
+	return c.conn.Close()
+}
@@ -0,0 +1,44 @@
+package tcpserver
+
+// A ConnState represents the state of a connection of TCPServer.
+type ConnState int
+
+// Connection states.
+const (
+	// StateNew is the state of a connection just accepted.
+	StateNew ConnState = iota
+
+	// StateTLSHandshake is the state of a TLS connection in handshake.
+	StateTLSHandshake
+
+	// StateActive is the state of a connection served by Handler.
+	StateActive
+
+	// StateIdle is the state of a connection reported as idle by Handler by
+	// TCPServer.SetConnState.
+	StateIdle
+
+	// StateHijacked is the state of a hijacked connection. It is a terminal
+	// state.
+	StateHijacked
+
+	// StateClosed is the state of a closed connection. It is a terminal
+	// state.
+	StateClosed
+)
+
+var connStateNames = map[ConnState]string{
+	StateNew:          "new",
+	StateTLSHandshake: "tls-handshake",
+	StateActive:       "active",
+	StateIdle:         "idle",
+	StateHijacked:     "hijacked",
+	StateClosed:       "closed",
+}
+
+func (c ConnState) String() string {
+	if name, ok := connStateNames[c]; ok {
+		return name
+	}
+	return "unknown"
+}
@@ -0,0 +1,76 @@
+package tcpserver
+
+import (
+	"net"
+	"sync/atomic"
+)
+
+// A CountingConn is a net.Conn counting bytes and operations of reads and
+// writes.
+type CountingConn struct {
+	net.Conn
+
+	bytesRead    atomic.Int64
+	bytesWritten atomic.Int64
+	reads        atomic.Int64
+	writes       atomic.Int64
+
+	cc *connContext
+}
+
+// NewCountingConn returns a new CountingConn.
+func NewCountingConn(conn net.Conn) *CountingConn {
+	return &CountingConn{Conn: conn}
+}
+
+// Read reads data from the connection.
+func (c *CountingConn) Read(b []byte) (n int, err error) {
+	n, err = c.Conn.Read(b)
+	c.bytesRead.Add(int64(n))
+	c.reads.Add(1)
+	if c.cc != nil {
+		c.cc.observeReadErr(err)
+	}
+	return
+}
+
+// Write writes data to the connection.
+func (c *CountingConn) Write(b []byte) (n int, err error) {
+	n, err = c.Conn.Write(b)
+	c.bytesWritten.Add(int64(n))
+	c.writes.Add(1)
+	return
+}
+
+// NetConn returns the wrapped connection.
+func (c *CountingConn) NetConn() net.Conn {
+	return c.Conn
+}
+
+// BytesRead returns count of bytes read.
+func (c *CountingConn) BytesRead() int64 {
+	return c.bytesRead.Load()
+}
+
+// BytesWritten returns count of bytes written.
+func (c *CountingConn) BytesWritten() int64 {
+	return c.bytesWritten.Load()
+}
+
+// Reads returns count of Read calls.
+func (c *CountingConn) Reads() int64 {
+	return c.reads.Load()
+}
+
+// Writes returns count of Write calls.
+func (c *CountingConn) Writes() int64 {
+	return c.writes.Load()
+}
+
+// counts returns counters of the connection, or zeros if it isn't counted.
+func (cc *connContext) counts() (bytesRead, bytesWritten, reads, writes int64) {
+	if c := cc.counter; c != nil {
+		return c.BytesRead(), c.BytesWritten(), c.Reads(), c.Writes()
+	}
+	return
+}
@@ -0,0 +1,87 @@
+package tcpserver
+
+import "sync"
+
+// Credits is a credit counter for credit-based flow control. Readers acquire
+// a credit before reading each message, or credits of bytes before reading
+// bytes, and the application grants credits when it is ready to process more.
+type Credits struct {
+	mu       sync.Mutex
+	n        int
+	notifyCh chan struct{}
+}
+
+// NewCredits returns a new Credits with n credits.
+func NewCredits(n int) *Credits {
+	return &Credits{
+		n:        n,
+		notifyCh: make(chan struct{}, 1),
+	}
+}
+
+// Grant grants n credits.
+func (c *Credits) Grant(n int) {
+	if n <= 0 {
+		return
+	}
+	c.mu.Lock()
+	c.n += n
+	c.mu.Unlock()
+	select {
+	case c.notifyCh <- struct{}{}:
+	default:
+	}
+}
+
+// Available returns available credits.
+func (c *Credits) Available() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.n
+}
+
+// Acquire waits for and acquires a credit. It returns false if it receives
+// from closeCh1 or closeCh2 before acquiring. Nil channels are ignored.
+func (c *Credits) Acquire(closeCh1, closeCh2 <-chan struct{}) bool {
+	return c.AcquireUpTo(1, closeCh1, closeCh2) > 0
+}
+
+// AcquireUpTo waits for at least one credit, and acquires up to n credits.
+// It returns count of acquired credits, or 0 if it receives from closeCh1 or
+// closeCh2 before acquiring. Nil channels are ignored.
+//
+// It allows byte credits: a reader acquires up to len(b) credits before
+// reading into b, reads at most the acquired count, and grants back the
+// credits of bytes that aren't read.
+func (c *Credits) AcquireUpTo(n int, closeCh1, closeCh2 <-chan struct{}) int {
+	if n <= 0 {
+		return 0
+	}
+	for {
+		c.mu.Lock()
+		if c.n > 0 {
+			if n > c.n {
+				n = c.n
+			}
+			c.n -= n
+			remaining := c.n
+			c.mu.Unlock()
+			if remaining > 0 {
+				// wakes up another waiter for the remaining credits.
+				select {
+				case c.notifyCh <- struct{}{}:
+				default:
+				}
+			}
+			return n
+		}
+		c.mu.Unlock()
+		select {
+		case <-c.notifyCh:
+		case <-closeCh1:
+			return 0
+		case <-closeCh2:
+			return 0
+		}
+	}
+}
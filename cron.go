@@ -0,0 +1,137 @@
+package tcpserver
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrInvalidCron is returned when a cron expression is invalid.
+	ErrInvalidCron = errors.New("invalid cron expression")
+)
+
+// cronSchedule is a parsed cron expression with fields of minute, hour, day
+// of month, month and day of week.
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	domStar, dowStar              bool
+}
+
+var cronFieldRanges = [5][2]int{
+	{0, 59},
+	{0, 23},
+	{1, 31},
+	{1, 12},
+	{0, 6},
+}
+
+// parseCron parses a cron expression of 5 fields. Fields support '*', lists,
+// ranges and steps like "*/15", "1-5" and "0,30".
+func parseCron(spec string) (*cronSchedule, error) {
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, ErrInvalidCron
+	}
+	var bits [5]uint64
+	for i, field := range fields {
+		b, err := parseCronField(field, cronFieldRanges[i][0], cronFieldRanges[i][1])
+		if err != nil {
+			return nil, err
+		}
+		bits[i] = b
+	}
+	// 7 is sunday too.
+	if bits[4]&(1<<7) != 0 {
+		bits[4] |= 1
+	}
+	return &cronSchedule{
+		minute:  bits[0],
+		hour:    bits[1],
+		dom:     bits[2],
+		month:   bits[3],
+		dow:     bits[4],
+		domStar: fields[2] == "*",
+		dowStar: fields[4] == "*",
+	}, nil
+}
+
+func parseCronField(field string, min, max int) (bits uint64, err error) {
+	if min == 0 && max == 6 {
+		max = 7
+	}
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.IndexByte(part, '/'); i >= 0 {
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step <= 0 {
+				return 0, ErrInvalidCron
+			}
+			part = part[:i]
+		}
+		lo, hi := min, max
+		if part != "*" {
+			if i := strings.IndexByte(part, '-'); i >= 0 {
+				lo, err = strconv.Atoi(part[:i])
+				if err != nil {
+					return 0, ErrInvalidCron
+				}
+				hi, err = strconv.Atoi(part[i+1:])
+				if err != nil {
+					return 0, ErrInvalidCron
+				}
+			} else {
+				if lo, err = strconv.Atoi(part); err != nil {
+					return 0, ErrInvalidCron
+				}
+				hi = lo
+				if step > 1 {
+					hi = max
+				}
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, ErrInvalidCron
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return
+}
+
+func (cs *cronSchedule) matchDay(t time.Time) bool {
+	domMatch := cs.dom&(1<<uint(t.Day())) != 0
+	dowMatch := cs.dow&(1<<uint(t.Weekday())) != 0
+	if cs.domStar || cs.dowStar {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
+
+// next returns the first time matching the schedule after t. It returns zero
+// time if there is no match in 5 years.
+func (cs *cronSchedule) next(t time.Time) time.Time {
+	t = t.Add(time.Minute - time.Duration(t.Second())*time.Second - time.Duration(t.Nanosecond()))
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if cs.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !cs.matchDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if cs.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if cs.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
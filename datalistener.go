@@ -0,0 +1,186 @@
+package tcpserver
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DefDataListenerTimeout specifies lifetime of data listeners if
+// DataListenerManager.Timeout is 0.
+var DefDataListenerTimeout = 1 * time.Minute
+
+var (
+	// ErrNoDataPort is returned when there is no available port in port
+	// range of DataListenerManager.
+	ErrNoDataPort = errors.New("no available data port")
+)
+
+// A DataListenerManager allocates secondary listeners tied to a parent
+// connection, for protocols opening separate data channels like passive mode
+// of FTP.
+type DataListenerManager struct {
+	// Host to listen on. If it is empty, listeners listen on all addresses.
+	Host string
+
+	// MinPort and MaxPort specify port range of listeners. If MinPort is 0,
+	// port is chosen by system.
+	MinPort, MaxPort int
+
+	// Timeout specifies lifetime of listeners.
+	Timeout time.Duration
+
+	// AllowForeignPeers allows listeners to accept connections from IP
+	// addresses other than the remote IP address of parent connection.
+	AllowForeignPeers bool
+
+	mu        sync.Mutex
+	ports     map[int]struct{}
+	listeners map[net.Conn]map[*DataListener]struct{}
+	nextPort  int
+}
+
+// A DataListener is a secondary listener allocated by DataListenerManager.
+type DataListener struct {
+	net.Listener
+
+	m        *DataListenerManager
+	parent   net.Conn
+	port     int
+	timer    *time.Timer
+	closeErr error
+	once     sync.Once
+}
+
+// Listen allocates a listener tied to the parent connection. The listener is
+// closed after its lifetime, or when Close or Release called.
+func (m *DataListenerManager) Listen(parent net.Conn) (dl *DataListener, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.ports == nil {
+		m.ports = make(map[int]struct{})
+		m.listeners = make(map[net.Conn]map[*DataListener]struct{})
+	}
+
+	dl = &DataListener{
+		m:      m,
+		parent: parent,
+	}
+	if m.MinPort <= 0 {
+		dl.Listener, err = net.Listen("tcp", net.JoinHostPort(m.Host, "0"))
+		if err != nil {
+			return nil, err
+		}
+		dl.port = dl.Listener.Addr().(*net.TCPAddr).Port
+	} else {
+		maxPort := m.MaxPort
+		if maxPort < m.MinPort {
+			maxPort = m.MinPort
+		}
+		if m.nextPort < m.MinPort || m.nextPort > maxPort {
+			m.nextPort = m.MinPort
+		}
+		for i := 0; i <= maxPort-m.MinPort && dl.Listener == nil; i++ {
+			port := m.nextPort
+			m.nextPort++
+			if m.nextPort > maxPort {
+				m.nextPort = m.MinPort
+			}
+			if _, ok := m.ports[port]; ok {
+				continue
+			}
+			l, e := net.Listen("tcp", net.JoinHostPort(m.Host, strconv.Itoa(port)))
+			if e != nil {
+				continue
+			}
+			dl.Listener = l
+			dl.port = port
+		}
+		if dl.Listener == nil {
+			return nil, ErrNoDataPort
+		}
+	}
+
+	m.ports[dl.port] = struct{}{}
+	if m.listeners[parent] == nil {
+		m.listeners[parent] = make(map[*DataListener]struct{})
+	}
+	m.listeners[parent][dl] = struct{}{}
+
+	timeout := m.Timeout
+	if timeout <= 0 {
+		timeout = DefDataListenerTimeout
+	}
+	dl.timer = time.AfterFunc(timeout, func() {
+		dl.Close()
+	})
+	return dl, nil
+}
+
+// Release closes all listeners tied to the parent connection. It should be
+// called when the parent connection is closing.
+func (m *DataListenerManager) Release(parent net.Conn) {
+	m.mu.Lock()
+	var dls []*DataListener
+	for dl := range m.listeners[parent] {
+		dls = append(dls, dl)
+	}
+	m.mu.Unlock()
+	for _, dl := range dls {
+		dl.Close()
+	}
+}
+
+// Port returns the port of listener.
+func (dl *DataListener) Port() int {
+	return dl.port
+}
+
+// Parent returns the parent connection of listener.
+func (dl *DataListener) Parent() net.Conn {
+	return dl.parent
+}
+
+// Accept waits for and returns the next connection to the listener.
+// Connections from foreign peers are closed unless
+// DataListenerManager.AllowForeignPeers is true.
+func (dl *DataListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := dl.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if dl.m.AllowForeignPeers || sameHost(conn.RemoteAddr(), dl.parent.RemoteAddr()) {
+			return conn, nil
+		}
+		conn.Close()
+	}
+}
+
+// Close closes the listener and releases its port.
+func (dl *DataListener) Close() error {
+	dl.once.Do(func() {
+		m := dl.m
+		m.mu.Lock()
+		dl.timer.Stop()
+		dl.closeErr = dl.Listener.Close()
+		delete(m.ports, dl.port)
+		delete(m.listeners[dl.parent], dl)
+		if len(m.listeners[dl.parent]) == 0 {
+			delete(m.listeners, dl.parent)
+		}
+		m.mu.Unlock()
+	})
+	return dl.closeErr
+}
+
+func sameHost(a, b net.Addr) bool {
+	ta, ok1 := a.(*net.TCPAddr)
+	tb, ok2 := b.(*net.TCPAddr)
+	if !ok1 || !ok2 {
+		return false
+	}
+	return ta.IP.Equal(tb.IP)
+}
@@ -0,0 +1,157 @@
+package tcpserver
+
+import (
+	"sync"
+	"time"
+)
+
+// A DrainWindow defines a recurring window of drain mode.
+type DrainWindow struct {
+	// Start is a cron expression of window starts, with fields of minute,
+	// hour, day of month, month and day of week.
+	Start string
+
+	// Duration of window.
+	Duration time.Duration
+}
+
+// A DrainScheduler puts a TCPServer into drain mode during scheduled
+// windows, and resumes accepting after them. It supports maintenance windows
+// of services fronted by DNS or load balancer rotation.
+type DrainScheduler struct {
+	// Server to drain.
+	Server *TCPServer
+
+	// Windows of drain mode.
+	Windows []DrainWindow
+
+	// Location of cron expressions. If it is nil, time.Local is used.
+	Location *time.Location
+
+	// BeforeDrain callback. It will be called before entering drain mode.
+	BeforeDrain func()
+
+	// AfterDrain callback. It will be called after resuming accepting.
+	AfterDrain func()
+
+	mu      sync.Mutex
+	stopCh  chan struct{}
+	doneCh  chan struct{}
+	windows []scheduledWindow
+}
+
+type scheduledWindow struct {
+	schedule *cronSchedule
+	duration time.Duration
+}
+
+// Start parses windows and starts the scheduler. If a window is active, drain
+// mode is entered immediately.
+func (ds *DrainScheduler) Start() error {
+	windows := make([]scheduledWindow, 0, len(ds.Windows))
+	for _, w := range ds.Windows {
+		cs, err := parseCron(w.Start)
+		if err != nil {
+			return err
+		}
+		windows = append(windows, scheduledWindow{
+			schedule: cs,
+			duration: w.Duration,
+		})
+	}
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	if ds.stopCh != nil {
+		return nil
+	}
+	ds.windows = windows
+	ds.stopCh = make(chan struct{})
+	ds.doneCh = make(chan struct{})
+	go ds.run(ds.stopCh, ds.doneCh)
+	return nil
+}
+
+// Stop stops the scheduler. If the scheduler put the server into drain mode,
+// accepting is resumed.
+func (ds *DrainScheduler) Stop() {
+	ds.mu.Lock()
+	stopCh, doneCh := ds.stopCh, ds.doneCh
+	ds.stopCh, ds.doneCh = nil, nil
+	ds.mu.Unlock()
+	if stopCh == nil {
+		return
+	}
+	close(stopCh)
+	<-doneCh
+}
+
+func (ds *DrainScheduler) run(stopCh, doneCh chan struct{}) {
+	defer close(doneCh)
+	loc := ds.Location
+	if loc == nil {
+		loc = time.Local
+	}
+	draining := false
+	defer func() {
+		if draining {
+			ds.resume()
+		}
+	}()
+	for {
+		now := time.Now().In(loc)
+		var activeEnd, nextStart time.Time
+		for _, w := range ds.windows {
+			start := w.schedule.next(now.Add(-w.duration))
+			if start.IsZero() {
+				continue
+			}
+			if !start.After(now) {
+				if end := start.Add(w.duration); end.After(activeEnd) {
+					activeEnd = end
+				}
+				continue
+			}
+			if nextStart.IsZero() || start.Before(nextStart) {
+				nextStart = start
+			}
+		}
+		var wakeAt time.Time
+		if !activeEnd.IsZero() {
+			if !draining {
+				if ds.BeforeDrain != nil {
+					ds.BeforeDrain()
+				}
+				ds.Server.Drain()
+				draining = true
+			}
+			wakeAt = activeEnd
+		} else {
+			if draining {
+				ds.resume()
+				draining = false
+			}
+			wakeAt = nextStart
+		}
+		var timer *time.Timer
+		var wakeCh <-chan time.Time
+		if !wakeAt.IsZero() {
+			timer = time.NewTimer(time.Until(wakeAt))
+			wakeCh = timer.C
+		}
+		select {
+		case <-wakeCh:
+		case <-stopCh:
+			if timer != nil {
+				timer.Stop()
+			}
+			return
+		}
+	}
+}
+
+func (ds *DrainScheduler) resume() {
+	ds.Server.Resume()
+	if ds.AfterDrain != nil {
+		ds.AfterDrain()
+	}
+}
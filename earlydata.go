@@ -0,0 +1,32 @@
+package tcpserver
+
+import "net"
+
+// EarlyDataConn is implemented by connections of TLS stacks that can accept
+// TLS 1.3 early data (0-RTT).
+type EarlyDataConn interface {
+	// EarlyData reports whether bytes read so far arrived as early data,
+	// before the handshake completed. Early data can be replayed by an
+	// attacker.
+	EarlyData() bool
+}
+
+// IsEarlyData reports whether bytes read so far from the connection arrived as
+// replayable TLS 1.3 early data, so protocols can defer non-idempotent actions
+// until it returns false. It follows wrapped connections to find an
+// EarlyDataConn.
+//
+// crypto/tls rejects early data on server side, so clients resend it after
+// the handshake, and IsEarlyData always returns false for *tls.Conn.
+func IsEarlyData(conn net.Conn) bool {
+	for {
+		if ec, ok := conn.(EarlyDataConn); ok {
+			return ec.EarlyData()
+		}
+		w, ok := conn.(interface{ NetConn() net.Conn })
+		if !ok {
+			return false
+		}
+		conn = w.NetConn()
+	}
+}
@@ -0,0 +1,33 @@
+package tcpserver
+
+import "net"
+
+// An AcceptError is returned by Serve when accepting from the listener fails
+// with a non-temporary error.
+type AcceptError struct {
+	Err error
+}
+
+func (e *AcceptError) Error() string {
+	return "accept: " + e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *AcceptError) Unwrap() error {
+	return e.Err
+}
+
+// A TLSHandshakeError describes a failed TLS handshake of a connection.
+type TLSHandshakeError struct {
+	RemoteAddr net.Addr
+	Err        error
+}
+
+func (e *TLSHandshakeError) Error() string {
+	return "TLS handshake error from " + e.RemoteAddr.String() + ": " + e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *TLSHandshakeError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,257 @@
+package tcpserver
+
+import (
+	"errors"
+	"log/slog"
+	"net"
+	"runtime"
+	"sync"
+	"syscall"
+	"time"
+)
+
+var (
+	// ErrEventLoopNotSupported is returned when event loop is not supported on
+	// the running platform.
+	ErrEventLoopNotSupported = errors.New("event loop not supported")
+
+	// ErrNoFD is returned when file descriptor of the connection can't be got.
+	ErrNoFD = errors.New("file descriptor not available")
+)
+
+// errEventHandlerPanic is set as error of ServeEvent when it panics.
+var errEventHandlerPanic = errors.New("event handler panic")
+
+// DefEventLoopWorkers specifies maximum count of concurrent ServeEvent calls
+// if EventLoop.Workers is 0.
+var DefEventLoopWorkers = 4 * runtime.NumCPU()
+
+// eventLoopWaitTimeout is timeout of waiting events to check stopping.
+const eventLoopWaitTimeout = 100 * time.Millisecond
+
+// An EventHandler serves readable events of connections of EventLoop.
+type EventHandler interface {
+	// ServeEvent is called when the connection is readable. It should read
+	// available data and return without blocking long, because it occupies a
+	// worker. If it returns an error, the connection is closed.
+	ServeEvent(conn net.Conn) error
+}
+
+// The EventHandlerFunc type is an adapter to allow the use of ordinary
+// functions as EventHandler.
+type EventHandlerFunc func(conn net.Conn) error
+
+// ServeEvent calls f(conn).
+func (f EventHandlerFunc) ServeEvent(conn net.Conn) error {
+	return f(conn)
+}
+
+// An EventLoop is a Handler serving connections by readiness events of epoll
+// on Linux or kqueue on BSD, instead of blocking reads of a goroutine per
+// connection. Connections are hijacked from Server and registered to the
+// event loop, and Handler is invoked by a worker on each readable event. It
+// reduces memory per connection for servers handling many mostly idle
+// connections.
+//
+// TLS connections aren't suitable, because decrypted data buffered in the
+// connection doesn't raise readable events.
+type EventLoop struct {
+	// Server to hijack connections from. It is required, because connections
+	// which aren't hijacked are closed by Server after Serve returns. If it is
+	// nil, connections aren't registered.
+	Server *TCPServer
+
+	// Handler to invoke on readable events.
+	Handler EventHandler
+
+	// Workers specifies maximum count of concurrent ServeEvent calls. If it
+	// is 0, DefEventLoopWorkers is used.
+	Workers int
+
+	// OnClose optionally is called after closing each connection.
+	OnClose func(conn net.Conn)
+
+	mu     sync.Mutex
+	p      poller
+	conns  map[int]net.Conn
+	sem    chan struct{}
+	wg     sync.WaitGroup
+	stopCh chan struct{}
+	doneCh chan struct{}
+}
+
+// poller is a readiness notifier of file descriptors. File descriptors are
+// registered as one-shot and must be rearmed after each event.
+type poller interface {
+	add(fd int) error
+	rearm(fd int) error
+	remove(fd int) error
+	wait(fds []int, timeout time.Duration) (int, error)
+	close() error
+}
+
+// Start starts the event loop.
+func (el *EventLoop) Start() error {
+	el.mu.Lock()
+	defer el.mu.Unlock()
+	if el.stopCh != nil {
+		return nil
+	}
+	p, err := newPoller()
+	if err != nil {
+		return err
+	}
+	workers := el.Workers
+	if workers <= 0 {
+		workers = DefEventLoopWorkers
+	}
+	el.p = p
+	el.conns = make(map[int]net.Conn)
+	el.sem = make(chan struct{}, workers)
+	el.stopCh = make(chan struct{})
+	el.doneCh = make(chan struct{})
+	go el.run(p, el.stopCh, el.doneCh)
+	return nil
+}
+
+// Stop stops the event loop, and closes its connections.
+func (el *EventLoop) Stop() {
+	el.mu.Lock()
+	stopCh, doneCh := el.stopCh, el.doneCh
+	el.stopCh, el.doneCh = nil, nil
+	el.mu.Unlock()
+	if stopCh == nil {
+		return
+	}
+	close(stopCh)
+	<-doneCh
+	el.wg.Wait()
+	el.mu.Lock()
+	conns := el.conns
+	el.conns = nil
+	el.p.close()
+	el.mu.Unlock()
+	for _, conn := range conns {
+		el.closed(conn)
+	}
+}
+
+// Conns returns count of connections of the event loop.
+func (el *EventLoop) Conns() int {
+	el.mu.Lock()
+	defer el.mu.Unlock()
+	return len(el.conns)
+}
+
+// connFD returns file descriptor of the innermost connection.
+func connFD(conn net.Conn) (fd int, err error) {
+	sc, ok := unwrapConn(conn).(syscall.Conn)
+	if !ok {
+		return -1, ErrNoFD
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return -1, err
+	}
+	err = rc.Control(func(f uintptr) {
+		fd = int(f)
+	})
+	return
+}
+
+// Serve implements Handler.Serve. It hijacks the connection from Server,
+// registers it to the event loop and returns immediately. It doesn't register
+// the connection if Server is nil.
+func (el *EventLoop) Serve(conn net.Conn, closeCh <-chan struct{}) {
+	fd, err := connFD(conn)
+	if err != nil {
+		return
+	}
+	el.mu.Lock()
+	defer el.mu.Unlock()
+	if el.stopCh == nil {
+		return
+	}
+	if el.Server == nil || el.Server.Hijack(conn) != nil {
+		return
+	}
+	el.conns[fd] = conn
+	if el.p.add(fd) != nil {
+		delete(el.conns, fd)
+		go el.closed(conn)
+	}
+}
+
+func (el *EventLoop) run(p poller, stopCh, doneCh chan struct{}) {
+	defer close(doneCh)
+	fds := make([]int, 128)
+	for {
+		select {
+		case <-stopCh:
+			return
+		default:
+		}
+		n, err := p.wait(fds, eventLoopWaitTimeout)
+		if err != nil {
+			if err == syscall.EINTR {
+				continue
+			}
+			return
+		}
+		for _, fd := range fds[:n] {
+			el.mu.Lock()
+			conn := el.conns[fd]
+			el.mu.Unlock()
+			if conn == nil {
+				continue
+			}
+			select {
+			case el.sem <- struct{}{}:
+			case <-stopCh:
+				return
+			}
+			el.wg.Add(1)
+			go el.dispatch(p, fd, conn)
+		}
+	}
+}
+
+func (el *EventLoop) dispatch(p poller, fd int, conn net.Conn) {
+	defer el.wg.Done()
+	defer func() { <-el.sem }()
+	var err error
+	func() {
+		defer func() {
+			if e := recover(); e != nil {
+				err = errEventHandlerPanic
+				el.Server.counters.panics.Add(1)
+				el.Server.log(nil, slog.LevelError, "event handler panic",
+					slog.String("remote_addr", conn.RemoteAddr().String()), slog.Any("panic", e))
+			}
+		}()
+		err = el.Handler.ServeEvent(conn)
+	}()
+	el.mu.Lock()
+	if el.conns[fd] != conn {
+		el.mu.Unlock()
+		return
+	}
+	if err == nil {
+		err = p.rearm(fd)
+	}
+	if err != nil {
+		delete(el.conns, fd)
+		p.remove(fd)
+	}
+	el.mu.Unlock()
+	if err != nil {
+		el.closed(conn)
+	}
+}
+
+func (el *EventLoop) closed(conn net.Conn) {
+	conn.Close()
+	if el.OnClose != nil {
+		el.OnClose(conn)
+	}
+}
@@ -0,0 +1,65 @@
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+
+package tcpserver
+
+import (
+	"syscall"
+	"time"
+)
+
+type kqueuePoller struct {
+	kq     int
+	events []syscall.Kevent_t
+}
+
+func newPoller() (poller, error) {
+	kq, err := syscall.Kqueue()
+	if err != nil {
+		return nil, err
+	}
+	syscall.CloseOnExec(kq)
+	return &kqueuePoller{kq: kq}, nil
+}
+
+func (p *kqueuePoller) ctl(fd, flags int) error {
+	var ev syscall.Kevent_t
+	syscall.SetKevent(&ev, fd, syscall.EVFILT_READ, flags)
+	_, err := syscall.Kevent(p.kq, []syscall.Kevent_t{ev}, nil, nil)
+	return err
+}
+
+func (p *kqueuePoller) add(fd int) error {
+	return p.ctl(fd, syscall.EV_ADD|syscall.EV_ONESHOT)
+}
+
+func (p *kqueuePoller) rearm(fd int) error {
+	return p.ctl(fd, syscall.EV_ADD|syscall.EV_ONESHOT)
+}
+
+func (p *kqueuePoller) remove(fd int) error {
+	err := p.ctl(fd, syscall.EV_DELETE)
+	if err == syscall.ENOENT {
+		// one-shot event is already deleted.
+		err = nil
+	}
+	return err
+}
+
+func (p *kqueuePoller) wait(fds []int, timeout time.Duration) (int, error) {
+	if len(p.events) < len(fds) {
+		p.events = make([]syscall.Kevent_t, len(fds))
+	}
+	ts := syscall.NsecToTimespec(int64(timeout))
+	n, err := syscall.Kevent(p.kq, nil, p.events[:len(fds)], &ts)
+	if err != nil {
+		return 0, err
+	}
+	for i := 0; i < n; i++ {
+		fds[i] = int(p.events[i].Ident)
+	}
+	return n, nil
+}
+
+func (p *kqueuePoller) close() error {
+	return syscall.Close(p.kq)
+}
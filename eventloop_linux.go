@@ -0,0 +1,57 @@
+package tcpserver
+
+import (
+	"syscall"
+	"time"
+)
+
+type epoller struct {
+	epfd   int
+	events []syscall.EpollEvent
+}
+
+func newPoller() (poller, error) {
+	epfd, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+	return &epoller{epfd: epfd}, nil
+}
+
+func (p *epoller) ctl(op, fd int) error {
+	ev := &syscall.EpollEvent{
+		Events: syscall.EPOLLIN | syscall.EPOLLRDHUP | syscall.EPOLLONESHOT,
+		Fd:     int32(fd),
+	}
+	return syscall.EpollCtl(p.epfd, op, fd, ev)
+}
+
+func (p *epoller) add(fd int) error {
+	return p.ctl(syscall.EPOLL_CTL_ADD, fd)
+}
+
+func (p *epoller) rearm(fd int) error {
+	return p.ctl(syscall.EPOLL_CTL_MOD, fd)
+}
+
+func (p *epoller) remove(fd int) error {
+	return syscall.EpollCtl(p.epfd, syscall.EPOLL_CTL_DEL, fd, nil)
+}
+
+func (p *epoller) wait(fds []int, timeout time.Duration) (int, error) {
+	if len(p.events) < len(fds) {
+		p.events = make([]syscall.EpollEvent, len(fds))
+	}
+	n, err := syscall.EpollWait(p.epfd, p.events[:len(fds)], int(timeout/time.Millisecond))
+	if err != nil {
+		return 0, err
+	}
+	for i := 0; i < n; i++ {
+		fds[i] = int(p.events[i].Fd)
+	}
+	return n, nil
+}
+
+func (p *epoller) close() error {
+	return syscall.Close(p.epfd)
+}
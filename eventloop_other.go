@@ -0,0 +1,7 @@
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd
+
+package tcpserver
+
+func newPoller() (poller, error) {
+	return nil, ErrEventLoopNotSupported
+}
@@ -0,0 +1,37 @@
+package tcpserver
+
+import (
+	"expvar"
+)
+
+// DefExpvarPrefix specifies prefix of expvar variables if prefix is empty.
+var DefExpvarPrefix = "tcpserver"
+
+// PublishExpvar publishes counters of the server via expvar, as
+// prefix.accepted, prefix.rejected, prefix.active, prefix.closed,
+// prefix.handshake_errors and prefix.panics. If prefix is empty,
+// DefExpvarPrefix is used. Like expvar.Publish, it panics if any of the names
+// is already registered.
+func (srv *TCPServer) PublishExpvar(prefix string) {
+	if prefix == "" {
+		prefix = DefExpvarPrefix
+	}
+	expvar.Publish(prefix+".accepted", expvar.Func(func() interface{} {
+		return srv.counters.accepted.Load()
+	}))
+	expvar.Publish(prefix+".rejected", expvar.Func(func() interface{} {
+		return srv.counters.rejected.Load()
+	}))
+	expvar.Publish(prefix+".active", expvar.Func(func() interface{} {
+		return srv.ConnCount()
+	}))
+	expvar.Publish(prefix+".closed", expvar.Func(func() interface{} {
+		return srv.counters.closed.Load()
+	}))
+	expvar.Publish(prefix+".handshake_errors", expvar.Func(func() interface{} {
+		return srv.counters.handshakeErrors.Load()
+	}))
+	expvar.Publish(prefix+".panics", expvar.Func(func() interface{} {
+		return srv.counters.panics.Load()
+	}))
+}
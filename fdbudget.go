@@ -0,0 +1,79 @@
+package tcpserver
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+var (
+	// ErrFDBudgetNotSupported is returned when file descriptor budget can't be
+	// detected on the running platform.
+	ErrFDBudgetNotSupported = errors.New("file descriptor budget not supported")
+)
+
+// DefFDReserve specifies count of file descriptors reserved for purposes
+// other than connections, like files and listeners.
+var DefFDReserve = 64
+
+// An FDBudget defines file descriptor budget of the process.
+type FDBudget struct {
+	// Limit is soft limit of RLIMIT_NOFILE.
+	Limit uint64
+
+	// Open is count of open file descriptors. It is -1 if it can't be
+	// detected.
+	Open int
+}
+
+// CurrentFDBudget returns current file descriptor budget of the process.
+func CurrentFDBudget() (*FDBudget, error) {
+	limit, err := fdLimit()
+	if err != nil {
+		return nil, err
+	}
+	return &FDBudget{
+		Limit: limit,
+		Open:  openFDs(),
+	}, nil
+}
+
+// SafeMaxConns returns maximum count of connections fitting into the budget
+// after reserving DefFDReserve descriptors.
+func (b *FDBudget) SafeMaxConns() int {
+	n := int64(b.Limit) - int64(DefFDReserve)
+	if b.Open > 0 {
+		n -= int64(b.Open)
+	}
+	if n < 1 {
+		n = 1
+	}
+	if n > int64(int(^uint(0)>>1)) {
+		n = int64(int(^uint(0) >> 1))
+	}
+	return int(n)
+}
+
+// openFDs returns count of open file descriptors, or -1 if it can't be
+// detected.
+func openFDs() int {
+	for _, dir := range []string{"/proc/self/fd", "/dev/fd"} {
+		f, err := os.Open(dir)
+		if err != nil {
+			continue
+		}
+		names, err := f.Readdirnames(-1)
+		f.Close()
+		if err != nil {
+			continue
+		}
+		// the descriptor of dir is included.
+		return len(names) - 1
+	}
+	return -1
+}
+
+// isFDExhausted reports whether err is caused by file descriptor exhaustion.
+func isFDExhausted(err error) bool {
+	return errors.Is(err, syscall.EMFILE) || errors.Is(err, syscall.ENFILE)
+}
@@ -0,0 +1,7 @@
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd
+
+package tcpserver
+
+func fdLimit() (uint64, error) {
+	return 0, ErrFDBudgetNotSupported
+}
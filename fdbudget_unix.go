@@ -0,0 +1,13 @@
+//go:build linux || darwin || dragonfly || freebsd || netbsd || openbsd
+
+package tcpserver
+
+import "syscall"
+
+func fdLimit() (uint64, error) {
+	var rlim syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rlim); err != nil {
+		return 0, err
+	}
+	return uint64(rlim.Cur), nil
+}
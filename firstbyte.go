@@ -0,0 +1,40 @@
+package tcpserver
+
+import (
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// firstByteConn is a net.Conn recording whether any data is read from the
+// connection.
+type firstByteConn struct {
+	net.Conn
+
+	received int32
+}
+
+func (c *firstByteConn) Read(b []byte) (n int, err error) {
+	n, err = c.Conn.Read(b)
+	if n > 0 && atomic.LoadInt32(&c.received) == 0 {
+		atomic.StoreInt32(&c.received, 1)
+	}
+	return
+}
+
+// NetConn returns the wrapped connection.
+func (c *firstByteConn) NetConn() net.Conn {
+	return c.Conn
+}
+
+// watchFirstByte closes the connection if no data is read from fc within
+// timeout. The returned function stops watching.
+func watchFirstByte(cc *connContext, fc *firstByteConn, timeout time.Duration) func() {
+	timer := time.AfterFunc(timeout, func() {
+		if atomic.LoadInt32(&fc.received) == 0 {
+			cc.setCloseReason(CloseFirstByteTimeout)
+			cc.conn.Close()
+		}
+	})
+	return func() { timer.Stop() }
+}
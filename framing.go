@@ -0,0 +1,386 @@
+package tcpserver
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+)
+
+// DefMaxFrameSize specifies maximum frame size if MaxFrameSize of codecs is 0.
+var DefMaxFrameSize = 64 * 1024
+
+var (
+	// ErrFrameTooLarge is returned by codecs when a frame exceeds maximum
+	// frame size.
+	ErrFrameTooLarge = errors.New("frame too large")
+
+	// ErrInvalidFrame is returned by codecs when a frame header is invalid.
+	ErrInvalidFrame = errors.New("invalid frame")
+
+	// ErrInvalidHeaderSize is returned by LengthPrefixCodec when its header
+	// size isn't 1, 2, 4 or 8.
+	ErrInvalidHeaderSize = errors.New("invalid frame header size")
+)
+
+// A Codec reads and writes frames of a message framing.
+type Codec interface {
+	// ReadFrame reads the next frame from r. The returned frame isn't reused
+	// by next reads.
+	ReadFrame(r *bufio.Reader) ([]byte, error)
+
+	// WriteFrame writes the frame to w.
+	WriteFrame(w io.Writer, frame []byte) error
+}
+
+// A LengthPrefixCodec is a Codec of frames prefixed with their lengths.
+type LengthPrefixCodec struct {
+	// HeaderSize specifies size of length header in bytes, 1, 2, 4 or 8. If
+	// it is 0, 4 is used.
+	HeaderSize int
+
+	// ByteOrder specifies byte order of length header. If it is nil,
+	// binary.BigEndian is used.
+	ByteOrder binary.ByteOrder
+
+	// IncludeHeader specifies whether the length includes the header.
+	IncludeHeader bool
+
+	// MaxFrameSize specifies maximum frame size without header. If it is 0,
+	// DefMaxFrameSize is used.
+	MaxFrameSize int
+}
+
+func (c *LengthPrefixCodec) params() (headerSize int, order binary.ByteOrder, maxFrameSize int, err error) {
+	headerSize, order, maxFrameSize = c.HeaderSize, c.ByteOrder, c.MaxFrameSize
+	if headerSize == 0 {
+		headerSize = 4
+	}
+	switch headerSize {
+	case 1, 2, 4, 8:
+	default:
+		err = ErrInvalidHeaderSize
+	}
+	if order == nil {
+		order = binary.BigEndian
+	}
+	if maxFrameSize <= 0 {
+		maxFrameSize = DefMaxFrameSize
+	}
+	return
+}
+
+// ReadFrame implements Codec.ReadFrame.
+func (c *LengthPrefixCodec) ReadFrame(r *bufio.Reader) ([]byte, error) {
+	headerSize, order, maxFrameSize, err := c.params()
+	if err != nil {
+		return nil, err
+	}
+	var hdr [8]byte
+	if _, err := io.ReadFull(r, hdr[:headerSize]); err != nil {
+		return nil, err
+	}
+	var n uint64
+	switch headerSize {
+	case 1:
+		n = uint64(hdr[0])
+	case 2:
+		n = uint64(order.Uint16(hdr[:]))
+	case 4:
+		n = uint64(order.Uint32(hdr[:]))
+	case 8:
+		n = order.Uint64(hdr[:])
+	}
+	if c.IncludeHeader {
+		if n < uint64(headerSize) {
+			return nil, ErrInvalidFrame
+		}
+		n -= uint64(headerSize)
+	}
+	if n > uint64(maxFrameSize) {
+		return nil, ErrFrameTooLarge
+	}
+	frame := make([]byte, n)
+	if _, err := io.ReadFull(r, frame); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return frame, nil
+}
+
+// WriteFrame implements Codec.WriteFrame.
+func (c *LengthPrefixCodec) WriteFrame(w io.Writer, frame []byte) error {
+	headerSize, order, maxFrameSize, err := c.params()
+	if err != nil {
+		return err
+	}
+	if len(frame) > maxFrameSize {
+		return ErrFrameTooLarge
+	}
+	n := uint64(len(frame))
+	if c.IncludeHeader {
+		n += uint64(headerSize)
+	}
+	if headerSize < 8 && n >= 1<<(8*headerSize) {
+		return ErrFrameTooLarge
+	}
+	var hdr [8]byte
+	switch headerSize {
+	case 1:
+		hdr[0] = byte(n)
+	case 2:
+		order.PutUint16(hdr[:], uint16(n))
+	case 4:
+		order.PutUint32(hdr[:], uint32(n))
+	case 8:
+		order.PutUint64(hdr[:], n)
+	}
+	if _, err := w.Write(hdr[:headerSize]); err != nil {
+		return err
+	}
+	_, err = w.Write(frame)
+	return err
+}
+
+// A DelimiterCodec is a Codec of frames terminated by a delimiter. Frames
+// are returned without the delimiter.
+type DelimiterCodec struct {
+	// Delimiter of frames. If it is empty, "\n" is used.
+	Delimiter []byte
+
+	// MaxFrameSize specifies maximum frame size with delimiter. If it is 0,
+	// DefMaxFrameSize is used.
+	MaxFrameSize int
+}
+
+func (c *DelimiterCodec) params() (delim []byte, maxFrameSize int) {
+	delim, maxFrameSize = c.Delimiter, c.MaxFrameSize
+	if len(delim) == 0 {
+		delim = []byte("\n")
+	}
+	if maxFrameSize <= 0 {
+		maxFrameSize = DefMaxFrameSize
+	}
+	return
+}
+
+// ReadFrame implements Codec.ReadFrame.
+func (c *DelimiterCodec) ReadFrame(r *bufio.Reader) ([]byte, error) {
+	delim, maxFrameSize := c.params()
+	last := delim[len(delim)-1]
+	var frame []byte
+	for {
+		buf, err := ReadBytesLimit(r, last, maxFrameSize-len(frame))
+		frame = append(frame, buf...)
+		if err == bufio.ErrBufferFull || err == ErrBufferLimitExceeded {
+			return nil, ErrFrameTooLarge
+		}
+		if err != nil {
+			if err == io.EOF && len(frame) > 0 {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		if bytes.HasSuffix(frame, delim) {
+			return frame[:len(frame)-len(delim)], nil
+		}
+	}
+}
+
+// WriteFrame implements Codec.WriteFrame.
+func (c *DelimiterCodec) WriteFrame(w io.Writer, frame []byte) error {
+	delim, maxFrameSize := c.params()
+	if len(frame)+len(delim) > maxFrameSize {
+		return ErrFrameTooLarge
+	}
+	if _, err := w.Write(frame); err != nil {
+		return err
+	}
+	_, err := w.Write(delim)
+	return err
+}
+
+// FrameHandler defines parameters for Handler of framed protocols. Frames
+// are decoded by Codec, and passed to OnFrame in order.
+type FrameHandler struct {
+	// Codec of frames.
+	Codec Codec
+
+	// Accept callback. It will be called before reading frames.
+	OnAccept func(ctx *FrameContext)
+
+	// Quit callback. It will be called before closing.
+	OnQuit func(ctx *FrameContext)
+
+	// Frame callback. It will be called for each frame.
+	OnFrame func(ctx *FrameContext, frame []byte)
+
+	// Error callback. It will be called with the read error except io.EOF
+	// before closing, to send a protocol error for example.
+	OnError func(ctx *FrameContext, err error)
+
+	// FlowControl enables credit-based flow control. Contexts read a frame
+	// only if they have a credit, and stop reading from connection when
+	// credits are exhausted. Credits are granted by Grant method of context.
+	FlowControl bool
+
+	// ByteCredits makes a credit stand for a byte read from connection
+	// instead of a frame, if FlowControl is true. Bytes are read into the
+	// read buffer in advance of frames, so the credits of a frame may be
+	// acquired before the previous frame is passed to OnFrame.
+	ByteCredits bool
+
+	// InitialCredits specifies credits of contexts on accept if FlowControl
+	// is true.
+	InitialCredits int
+
+	// User data to use free.
+	UserData interface{}
+}
+
+// Serve implements Handler.Serve.
+func (fh *FrameHandler) Serve(conn net.Conn, closeCh <-chan struct{}) {
+	ctx := &FrameContext{
+		Handler:  fh,
+		Conn:     conn,
+		closeCh:  closeCh,
+		closeCh2: make(chan struct{}, 1),
+		wr:       bufio.NewWriter(conn),
+	}
+	var r io.Reader = conn
+	if fh.FlowControl {
+		ctx.credits = NewCredits(fh.InitialCredits)
+		if fh.ByteCredits {
+			ctx.cr = &creditReader{
+				r:        conn,
+				credits:  ctx.credits,
+				closeCh:  closeCh,
+				closeCh2: ctx.closeCh2,
+			}
+			r = ctx.cr
+		}
+	}
+	ctx.rd = bufio.NewReader(r)
+	ctx.serve()
+}
+
+// FrameContext defines parameters for framed protocol context.
+type FrameContext struct {
+	// Pointer of FrameHandler struct handled by this context.
+	Handler *FrameHandler
+
+	// Connection handled by this context.
+	Conn net.Conn
+
+	// User data to use free.
+	UserData interface{}
+
+	closeCh  <-chan struct{}
+	closeCh2 chan struct{}
+	credits  *Credits
+	cr       *creditReader
+	rd       *bufio.Reader
+	wr       *bufio.Writer
+	wrMu     sync.Mutex
+}
+
+// Close closes context.
+func (ctx *FrameContext) Close() {
+	select {
+	case ctx.closeCh2 <- struct{}{}:
+	default:
+	}
+}
+
+// Grant grants n credits to read frames or bytes if flow control is enabled.
+func (ctx *FrameContext) Grant(n int) {
+	if ctx.credits != nil {
+		ctx.credits.Grant(n)
+	}
+}
+
+// Credits returns available credits to read frames or bytes. It returns -1
+// if flow control isn't enabled.
+func (ctx *FrameContext) Credits() int {
+	if ctx.credits == nil {
+		return -1
+	}
+	return ctx.credits.Available()
+}
+
+func (ctx *FrameContext) serve() {
+	if ctx.Handler.OnAccept != nil {
+		ctx.Handler.OnAccept(ctx)
+	}
+mainloop:
+	for {
+		select {
+		case <-ctx.closeCh:
+			break mainloop
+		case <-ctx.closeCh2:
+			break mainloop
+		default:
+		}
+		if ctx.credits != nil && ctx.cr == nil && !ctx.credits.Acquire(ctx.closeCh, ctx.closeCh2) {
+			break mainloop
+		}
+		frame, err := ctx.Handler.Codec.ReadFrame(ctx.rd)
+		if err != nil {
+			closed := ctx.cr != nil && ctx.cr.closed
+			if err != io.EOF && !closed && ctx.Handler.OnError != nil {
+				ctx.Handler.OnError(ctx, err)
+			}
+			break
+		}
+		if ctx.Handler.OnFrame != nil {
+			ctx.Handler.OnFrame(ctx, frame)
+		}
+	}
+	if ctx.Handler.OnQuit != nil {
+		ctx.Handler.OnQuit(ctx)
+	}
+}
+
+// WriteFrame encodes the frame by Codec and writes it to connection. It is
+// safe to call concurrently.
+func (ctx *FrameContext) WriteFrame(frame []byte) error {
+	ctx.wrMu.Lock()
+	defer ctx.wrMu.Unlock()
+	err := ctx.Handler.Codec.WriteFrame(ctx.wr, frame)
+	if err == nil {
+		err = ctx.wr.Flush()
+	}
+	if err != nil && err != ErrFrameTooLarge {
+		ctx.Close()
+	}
+	return err
+}
+
+// creditReader is a reader acquiring a credit for each byte before reading.
+type creditReader struct {
+	r        io.Reader
+	credits  *Credits
+	closeCh  <-chan struct{}
+	closeCh2 <-chan struct{}
+	closed   bool
+}
+
+func (cr *creditReader) Read(b []byte) (n int, err error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+	acquired := cr.credits.AcquireUpTo(len(b), cr.closeCh, cr.closeCh2)
+	if acquired == 0 {
+		// the context is closed while waiting for credits.
+		cr.closed = true
+		return 0, io.EOF
+	}
+	n, err = cr.r.Read(b[:acquired])
+	cr.credits.Grant(acquired - n)
+	return
+}
@@ -1,6 +1,9 @@
 package tcpserver
 
-import "net"
+import (
+	"context"
+	"net"
+)
 
 // A Handler responds to an TCP incoming connection.
 type Handler interface {
@@ -16,3 +19,68 @@ type HandlerFunc func(conn net.Conn, closeCh <-chan struct{})
 func (f HandlerFunc) Serve(conn net.Conn, closeCh <-chan struct{}) {
 	f(conn, closeCh)
 }
+
+// A HandlerContext responds to an TCP incoming connection with a context
+// instead of closeCh. The context is cancelled when the connection should be
+// closed by Shutdown or Close.
+type HandlerContext interface {
+	ServeContext(ctx context.Context, conn net.Conn)
+}
+
+// The HandlerContextFunc type is an adapter to allow the use of ordinary
+// functions as context-aware handlers. HandlerContextFunc(f) is both a
+// HandlerContext and a Handler that calls f, so it can be used as
+// TCPServer.Handler directly.
+type HandlerContextFunc func(ctx context.Context, conn net.Conn)
+
+// ServeContext calls f(ctx, conn)
+func (f HandlerContextFunc) ServeContext(ctx context.Context, conn net.Conn) {
+	f(ctx, conn)
+}
+
+// Serve calls f with a context cancelled when closeCh is filled.
+func (f HandlerContextFunc) Serve(conn net.Conn, closeCh <-chan struct{}) {
+	ctx, cancel := closeContext(context.Background(), closeCh)
+	defer cancel()
+	f(ctx, conn)
+}
+
+// ContextHandler returns a Handler that calls h.ServeContext. TCPServer calls
+// ServeContext of the returned Handler directly with a context of the
+// connection, other callers of Serve get a context cancelled when closeCh is
+// filled.
+func ContextHandler(h HandlerContext) Handler {
+	return &contextHandler{
+		h: h,
+	}
+}
+
+type contextHandler struct {
+	h HandlerContext
+}
+
+// Serve implements Handler.Serve.
+func (ch *contextHandler) Serve(conn net.Conn, closeCh <-chan struct{}) {
+	ctx, cancel := closeContext(context.Background(), closeCh)
+	defer cancel()
+	ch.h.ServeContext(ctx, conn)
+}
+
+// ServeContext implements HandlerContext.ServeContext.
+func (ch *contextHandler) ServeContext(ctx context.Context, conn net.Conn) {
+	ch.h.ServeContext(ctx, conn)
+}
+
+// closeContext returns a context derived from parent, which is cancelled when
+// closeCh is filled or cancel is called.
+func closeContext(parent context.Context, closeCh <-chan struct{}) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
+	go func() {
+		select {
+		case <-closeCh:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
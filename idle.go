@@ -0,0 +1,89 @@
+package tcpserver
+
+import (
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// MinIdleReapInterval specifies minimum interval of the reaper of
+// TCPServer.IdleTimeout.
+var MinIdleReapInterval = 100 * time.Millisecond
+
+// idleConn is a net.Conn recording last activity of the connection.
+type idleConn struct {
+	net.Conn
+
+	cc *connContext
+}
+
+func (c *idleConn) Read(b []byte) (n int, err error) {
+	n, err = c.Conn.Read(b)
+	if n > 0 {
+		c.cc.touch()
+	}
+	c.cc.observeReadErr(err)
+	return
+}
+
+func (c *idleConn) Write(b []byte) (n int, err error) {
+	n, err = c.Conn.Write(b)
+	if n > 0 {
+		c.cc.touch()
+	}
+	return
+}
+
+// NetConn returns the wrapped connection.
+func (c *idleConn) NetConn() net.Conn {
+	return c.Conn
+}
+
+// touch records activity of the connection.
+func (cc *connContext) touch() {
+	atomic.StoreInt64(&cc.lastActive, time.Now().UnixNano())
+}
+
+// startReaper starts the reaper closing idle connections, if it isn't running.
+// The reaper stops when there is no connection.
+func (srv *TCPServer) startReaper() {
+	if !atomic.CompareAndSwapInt32(&srv.reaping, 0, 1) {
+		return
+	}
+	go srv.reap()
+}
+
+func (srv *TCPServer) reap() {
+	for {
+		timeout := srv.idleTimeout()
+		interval := timeout / 2
+		if interval < MinIdleReapInterval {
+			interval = MinIdleReapInterval
+		}
+		time.Sleep(interval)
+		now := time.Now().UnixNano()
+		var idle []*connContext
+		srv.connsMu.RLock()
+		count := len(srv.conns)
+		for _, c := range srv.conns {
+			if timeout > 0 && c.idle && now-atomic.LoadInt64(&c.lastActive) >= int64(timeout) {
+				idle = append(idle, c)
+			}
+		}
+		srv.connsMu.RUnlock()
+		for _, c := range idle {
+			c.setCloseReason(CloseIdleTimeout)
+			c.conn.Close()
+		}
+		if count == 0 {
+			atomic.StoreInt32(&srv.reaping, 0)
+			// a connection may be registered before clearing the flag.
+			srv.connsMu.RLock()
+			count = len(srv.conns)
+			srv.connsMu.RUnlock()
+			if count == 0 || !atomic.CompareAndSwapInt32(&srv.reaping, 0, 1) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package tcpserver
+
+import (
+	"time"
+)
+
+// acquireIP acquires a connection slot of the IP address for MaxConnsPerIP.
+// It waits up to MaxConnsPerIPWait for a free slot, and reports whether the
+// slot is acquired.
+func (srv *TCPServer) acquireIP(ip string, closeCh <-chan struct{}) bool {
+	var timeoutCh <-chan time.Time
+	if srv.MaxConnsPerIPWait > 0 {
+		timer := time.NewTimer(srv.MaxConnsPerIPWait)
+		defer timer.Stop()
+		timeoutCh = timer.C
+	}
+	for {
+		srv.ipConnsMu.Lock()
+		if srv.ipConns == nil {
+			srv.ipConns = make(map[string]int)
+		}
+		if n := srv.ipConns[ip]; n < srv.maxConnsPerIP() {
+			srv.ipConns[ip] = n + 1
+			srv.ipConnsMu.Unlock()
+			return true
+		}
+		if timeoutCh == nil {
+			srv.ipConnsMu.Unlock()
+			return false
+		}
+		if srv.ipFreedCh == nil {
+			srv.ipFreedCh = make(chan struct{})
+		}
+		ipFreedCh := srv.ipFreedCh
+		srv.ipConnsMu.Unlock()
+		select {
+		case <-ipFreedCh:
+		case <-timeoutCh:
+			return false
+		case <-closeCh:
+			return false
+		}
+	}
+}
+
+// releaseIP releases a connection slot of the IP address, and wakes up the
+// waiters in acquireIP.
+func (srv *TCPServer) releaseIP(ip string) {
+	srv.ipConnsMu.Lock()
+	if n := srv.ipConns[ip] - 1; n > 0 {
+		srv.ipConns[ip] = n
+	} else {
+		delete(srv.ipConns, ip)
+	}
+	if srv.ipFreedCh != nil {
+		close(srv.ipFreedCh)
+		srv.ipFreedCh = nil
+	}
+	srv.ipConnsMu.Unlock()
+}
+
+// ConnsPerIP returns count of connections of the IP address counted for
+// MaxConnsPerIP.
+func (srv *TCPServer) ConnsPerIP(ip string) int {
+	srv.ipConnsMu.Lock()
+	defer srv.ipConnsMu.Unlock()
+	return srv.ipConns[ip]
+}
@@ -0,0 +1,182 @@
+package tcpserver
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// An IPFilter filters remote IP addresses by CIDR based allow and deny lists.
+// An address is allowed if it isn't in the deny list, and it is in the allow
+// list or the allow list is empty. Entries can be added and removed at
+// runtime atomically. The zero value allows all addresses.
+type IPFilter struct {
+	mu    sync.Mutex
+	lists atomic.Value // *ipFilterLists
+}
+
+type ipFilterLists struct {
+	allow []*net.IPNet
+	deny  []*net.IPNet
+}
+
+// parseIPNet parses a CIDR or a single IP address.
+func parseIPNet(s string) (*net.IPNet, error) {
+	if !strings.Contains(s, "/") {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, &net.ParseError{Type: "IP address", Text: s}
+		}
+		if ip4 := ip.To4(); ip4 != nil {
+			return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+	}
+	_, ipNet, err := net.ParseCIDR(s)
+	return ipNet, err
+}
+
+func (f *IPFilter) load() *ipFilterLists {
+	l, _ := f.lists.Load().(*ipFilterLists)
+	if l == nil {
+		l = &ipFilterLists{}
+	}
+	return l
+}
+
+// update copies the lists, applies fn to the copy and stores it.
+func (f *IPFilter) update(fn func(l *ipFilterLists)) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	old := f.load()
+	l := &ipFilterLists{
+		allow: append([]*net.IPNet(nil), old.allow...),
+		deny:  append([]*net.IPNet(nil), old.deny...),
+	}
+	fn(l)
+	f.lists.Store(l)
+}
+
+func addIPNet(list []*net.IPNet, ipNet *net.IPNet) []*net.IPNet {
+	for _, n := range list {
+		if n.String() == ipNet.String() {
+			return list
+		}
+	}
+	return append(list, ipNet)
+}
+
+func removeIPNet(list []*net.IPNet, ipNet *net.IPNet) []*net.IPNet {
+	for i, n := range list {
+		if n.String() == ipNet.String() {
+			return append(list[:i], list[i+1:]...)
+		}
+	}
+	return list
+}
+
+// Allow adds a CIDR or an IP address to the allow list.
+func (f *IPFilter) Allow(cidr string) error {
+	ipNet, err := parseIPNet(cidr)
+	if err != nil {
+		return err
+	}
+	f.update(func(l *ipFilterLists) { l.allow = addIPNet(l.allow, ipNet) })
+	return nil
+}
+
+// Deny adds a CIDR or an IP address to the deny list.
+func (f *IPFilter) Deny(cidr string) error {
+	ipNet, err := parseIPNet(cidr)
+	if err != nil {
+		return err
+	}
+	f.update(func(l *ipFilterLists) { l.deny = addIPNet(l.deny, ipNet) })
+	return nil
+}
+
+// RemoveAllow removes a CIDR or an IP address from the allow list.
+func (f *IPFilter) RemoveAllow(cidr string) error {
+	ipNet, err := parseIPNet(cidr)
+	if err != nil {
+		return err
+	}
+	f.update(func(l *ipFilterLists) { l.allow = removeIPNet(l.allow, ipNet) })
+	return nil
+}
+
+// RemoveDeny removes a CIDR or an IP address from the deny list.
+func (f *IPFilter) RemoveDeny(cidr string) error {
+	ipNet, err := parseIPNet(cidr)
+	if err != nil {
+		return err
+	}
+	f.update(func(l *ipFilterLists) { l.deny = removeIPNet(l.deny, ipNet) })
+	return nil
+}
+
+// Set replaces both lists atomically.
+func (f *IPFilter) Set(allow, deny []string) error {
+	l := &ipFilterLists{}
+	for _, s := range allow {
+		ipNet, err := parseIPNet(s)
+		if err != nil {
+			return err
+		}
+		l.allow = addIPNet(l.allow, ipNet)
+	}
+	for _, s := range deny {
+		ipNet, err := parseIPNet(s)
+		if err != nil {
+			return err
+		}
+		l.deny = addIPNet(l.deny, ipNet)
+	}
+	f.mu.Lock()
+	f.lists.Store(l)
+	f.mu.Unlock()
+	return nil
+}
+
+// Lists returns copies of the allow and deny lists.
+func (f *IPFilter) Lists() (allow, deny []string) {
+	l := f.load()
+	for _, n := range l.allow {
+		allow = append(allow, n.String())
+	}
+	for _, n := range l.deny {
+		deny = append(deny, n.String())
+	}
+	return
+}
+
+// Allowed reports whether the IP address is allowed.
+func (f *IPFilter) Allowed(ip net.IP) bool {
+	l := f.load()
+	for _, n := range l.deny {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	if len(l.allow) == 0 {
+		return true
+	}
+	for _, n := range l.allow {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowedConn reports whether remote IP address of the connection is allowed.
+// Connections without an IP address are allowed only if the allow list is
+// empty.
+func (f *IPFilter) allowedConn(conn net.Conn) bool {
+	ip := net.ParseIP(remoteIP(conn))
+	if ip == nil {
+		return len(f.load().allow) == 0
+	}
+	return f.Allowed(ip)
+}
@@ -0,0 +1,340 @@
+package tcpserver
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// Default values of IPQuota parameters.
+var (
+	DefQuotaWindow      = 1 * time.Minute
+	DefQuotaBanDuration = 10 * time.Minute
+)
+
+// quotaBuckets is count of buckets of sliding window of IPQuota.
+const quotaBuckets = 10
+
+var (
+	// ErrQuotaExceeded is returned by connections of IPQuota after byte quota
+	// is exceeded.
+	ErrQuotaExceeded = errors.New("quota exceeded")
+)
+
+// QuotaAction specifies the action when a byte quota is exceeded.
+type QuotaAction int
+
+// Quota actions.
+const (
+	// QuotaThrottle blocks reads and writes of the IP until usage in window
+	// falls under the quota.
+	QuotaThrottle QuotaAction = iota
+
+	// QuotaDisconnect closes the connection that exceeds the quota.
+	QuotaDisconnect
+
+	// QuotaBan closes all connections of the IP, and rejects new connections
+	// of the IP for BanDuration.
+	QuotaBan
+)
+
+// An IPQuota is a Handler that limits aggregate bytes of all connections of
+// each source IP, for fairness across clients that open many connections.
+type IPQuota struct {
+	// Handler to invoke.
+	Handler Handler
+
+	// BytesPerSecond specifies aggregate transfer rate cap of each direction
+	// for connections of an IP. If it is 0, there is no cap.
+	BytesPerSecond int
+
+	// Quota specifies maximum bytes read and written for connections of an IP
+	// in sliding Window. If it is 0, there is no quota.
+	Quota int64
+
+	// Window specifies duration of sliding window of Quota. If it is 0,
+	// DefQuotaWindow is used.
+	Window time.Duration
+
+	// Action specifies the action when Quota is exceeded.
+	Action QuotaAction
+
+	// BanDuration specifies duration of bans by QuotaBan action. If it is 0,
+	// DefQuotaBanDuration is used.
+	BanDuration time.Duration
+
+	// Exceed callback. It will be called when an IP exceeds Quota.
+	OnExceed func(ip string, action QuotaAction)
+
+	mu        sync.Mutex
+	clients   map[string]*ipClient
+	lastSweep time.Time
+}
+
+type ipClient struct {
+	mu          sync.Mutex
+	conns       map[*quotaConn]struct{}
+	buckets     [quotaBuckets]int64
+	head        int
+	headTime    time.Time
+	lastActive  time.Time
+	bannedUntil time.Time
+	rdBucket    *tokenBucket
+	wrBucket    *tokenBucket
+}
+
+// Serve implements Handler.Serve.
+func (q *IPQuota) Serve(conn net.Conn, closeCh <-chan struct{}) {
+	ip := remoteIP(conn)
+	now := time.Now()
+
+	q.mu.Lock()
+	if q.clients == nil {
+		q.clients = make(map[string]*ipClient)
+	}
+	q.sweep(now)
+	c := q.clients[ip]
+	if c == nil {
+		c = &ipClient{
+			conns:    make(map[*quotaConn]struct{}),
+			headTime: now,
+		}
+		if q.BytesPerSecond > 0 {
+			c.rdBucket = newTokenBucket(float64(q.BytesPerSecond), q.BytesPerSecond)
+			c.wrBucket = newTokenBucket(float64(q.BytesPerSecond), q.BytesPerSecond)
+		}
+		q.clients[ip] = c
+	}
+	qc := &quotaConn{
+		Conn:    conn,
+		quota:   q,
+		ip:      ip,
+		client:  c,
+		closeCh: make(chan struct{}),
+	}
+	// the connection is registered with lock held, so the client isn't
+	// removed by sweep before.
+	c.mu.Lock()
+	if now.Before(c.bannedUntil) {
+		c.mu.Unlock()
+		q.mu.Unlock()
+		return
+	}
+	c.conns[qc] = struct{}{}
+	c.lastActive = now
+	c.mu.Unlock()
+	q.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.conns, qc)
+		c.lastActive = time.Now()
+		c.mu.Unlock()
+	}()
+
+	// closeCh is forwarded to Handler, so throttled reads and writes are
+	// woken up by it too.
+	hCloseCh := make(chan struct{}, 1)
+	doneCh := make(chan struct{})
+	defer close(doneCh)
+	go func() {
+		select {
+		case <-closeCh:
+			qc.signalClose()
+			hCloseCh <- struct{}{}
+		case <-doneCh:
+		}
+	}()
+
+	var hconn net.Conn = qc
+	if c.rdBucket != nil {
+		hconn = &throttledConn{
+			Conn:      qc,
+			rdBuckets: []*tokenBucket{c.rdBucket},
+			wrBuckets: []*tokenBucket{c.wrBucket},
+		}
+	}
+	q.Handler.Serve(hconn, hCloseCh)
+}
+
+// Banned reports whether the IP is banned.
+func (q *IPQuota) Banned(ip string) bool {
+	q.mu.Lock()
+	c := q.clients[ip]
+	q.mu.Unlock()
+	if c == nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return time.Now().Before(c.bannedUntil)
+}
+
+// Usage returns bytes read and written by connections of the IP in window.
+func (q *IPQuota) Usage(ip string) int64 {
+	q.mu.Lock()
+	c := q.clients[ip]
+	q.mu.Unlock()
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return q.add(c, time.Now(), 0)
+}
+
+func (q *IPQuota) window() time.Duration {
+	if q.Window <= 0 {
+		return DefQuotaWindow
+	}
+	return q.Window
+}
+
+// sweep removes idle clients once per window. It must be called with lock
+// held.
+func (q *IPQuota) sweep(now time.Time) {
+	window := q.window()
+	if now.Sub(q.lastSweep) < window {
+		return
+	}
+	q.lastSweep = now
+	for ip, c := range q.clients {
+		c.mu.Lock()
+		if len(c.conns) == 0 && now.Sub(c.lastActive) >= window && !now.Before(c.bannedUntil) {
+			delete(q.clients, ip)
+		}
+		c.mu.Unlock()
+	}
+}
+
+// add adds n bytes to the sliding window of the client, and returns usage in
+// window. It must be called with lock of the client held.
+func (q *IPQuota) add(c *ipClient, now time.Time, n int) int64 {
+	width := q.window() / quotaBuckets
+	if steps := int(now.Sub(c.headTime) / width); steps > 0 {
+		if steps > quotaBuckets {
+			steps = quotaBuckets
+		}
+		for i := 0; i < steps; i++ {
+			c.head = (c.head + 1) % quotaBuckets
+			c.buckets[c.head] = 0
+		}
+		c.headTime = now.Truncate(width)
+	}
+	c.buckets[c.head] += int64(n)
+	var total int64
+	for _, b := range c.buckets {
+		total += b
+	}
+	return total
+}
+
+// account accounts n bytes transferred by the connection, and applies the
+// action if quota is exceeded.
+func (q *IPQuota) account(qc *quotaConn, n int) error {
+	if q.Quota <= 0 {
+		return nil
+	}
+	c := qc.client
+	c.mu.Lock()
+	now := time.Now()
+	c.lastActive = now
+	usage := q.add(c, now, n)
+	if usage <= q.Quota {
+		c.mu.Unlock()
+		return nil
+	}
+	var conns []*quotaConn
+	notify := true
+	switch q.Action {
+	case QuotaDisconnect:
+		conns = []*quotaConn{qc}
+	case QuotaBan:
+		banDuration := q.BanDuration
+		if banDuration <= 0 {
+			banDuration = DefQuotaBanDuration
+		}
+		notify = !now.Before(c.bannedUntil)
+		c.bannedUntil = now.Add(banDuration)
+		for cc := range c.conns {
+			conns = append(conns, cc)
+		}
+	}
+	c.mu.Unlock()
+	if notify && q.OnExceed != nil {
+		q.OnExceed(qc.ip, q.Action)
+	}
+	if q.Action == QuotaThrottle {
+		q.throttle(qc)
+		return nil
+	}
+	for _, cc := range conns {
+		cc.Close()
+	}
+	return ErrQuotaExceeded
+}
+
+// throttle waits until usage of the client falls under quota, or the
+// connection is closed or signalled to close.
+func (q *IPQuota) throttle(qc *quotaConn) {
+	width := q.window() / quotaBuckets
+	timer := time.NewTimer(width)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+		case <-qc.closeCh:
+			return
+		}
+		qc.client.mu.Lock()
+		usage := q.add(qc.client, time.Now(), 0)
+		qc.client.mu.Unlock()
+		if usage <= q.Quota {
+			return
+		}
+		timer.Reset(width)
+	}
+}
+
+// quotaConn is a net.Conn accounting bytes of its IP.
+type quotaConn struct {
+	net.Conn
+
+	quota     *IPQuota
+	ip        string
+	client    *ipClient
+	closeCh   chan struct{}
+	closeOnce sync.Once
+}
+
+func (c *quotaConn) Read(b []byte) (n int, err error) {
+	n, err = c.Conn.Read(b)
+	if e := c.quota.account(c, n); e != nil && err == nil {
+		err = e
+	}
+	return
+}
+
+func (c *quotaConn) Write(b []byte) (n int, err error) {
+	n, err = c.Conn.Write(b)
+	if e := c.quota.account(c, n); e != nil && err == nil {
+		err = e
+	}
+	return
+}
+
+func (c *quotaConn) Close() error {
+	c.signalClose()
+	return c.Conn.Close()
+}
+
+// signalClose wakes up throttled reads and writes of the connection.
+func (c *quotaConn) signalClose() {
+	c.closeOnce.Do(func() {
+		close(c.closeCh)
+	})
+}
+
+func (c *quotaConn) NetConn() net.Conn {
+	return c.Conn
+}
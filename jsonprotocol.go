@@ -0,0 +1,388 @@
+package tcpserver
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// DefMaxMessageSize specifies maximum message size if
+// JSONProtocol.MaxMessageSize is 0.
+var DefMaxMessageSize = 64 * 1024
+
+// DefTypeField specifies name of type field if JSONProtocol.TypeField is
+// empty.
+var DefTypeField = "type"
+
+// JSONProtocol defines parameters for Handler of streaming JSON protocol.
+// Messages are JSON objects without any delimiter, and dispatched to
+// callbacks by their type field.
+type JSONProtocol struct {
+	// Accept callback. It will be called before reading messages.
+	OnAccept func(ctx *JSONProtocolContext)
+
+	// Quit callback. It will be called before closing.
+	OnQuit func(ctx *JSONProtocolContext)
+
+	// Message callback. It will be called for messages of unregistered
+	// types.
+	OnMessage func(ctx *JSONProtocolContext, typ string, msg json.RawMessage)
+
+	// Timeout callback. It will be called when a callback exceeds its
+	// message timeout, to send a protocol error for example.
+	OnTimeout func(ctx *JSONProtocolContext, typ string, msg json.RawMessage)
+
+	// MaxMessageSize specifies approximately maximum message size.
+	MaxMessageSize int
+
+	// MessageTimeout specifies default timeout of callbacks of messages. When
+	// it exceeds, context of callback is cancelled, OnTimeout is called and
+	// next message is read without waiting for callback to return. If it is 0,
+	// there is no timeout.
+	MessageTimeout time.Duration
+
+	// MessageTimeoutFunc optionally returns timeout of the message to
+	// override MessageTimeout. If it returns 0, MessageTimeout is used.
+	MessageTimeoutFunc func(typ string, msg json.RawMessage) time.Duration
+
+	// TypeField specifies name of type field of messages.
+	TypeField string
+
+	// FlowControl enables credit-based flow control. Contexts read a message
+	// only if they have a credit, and stop reading from connection when
+	// credits are exhausted. Credits are granted by Grant method of context.
+	FlowControl bool
+
+	// InitialCredits specifies credits of contexts on accept if FlowControl
+	// is true.
+	InitialCredits int
+
+	// MemoryBudget optionally limits memory of messages of contexts while
+	// their callbacks run. If a message exceeds the budget, it is ignored by
+	// MemoryReject policy, or the context is closed by MemoryEvict policy.
+	MemoryBudget *MemoryBudget
+
+	// ErrorLog specifies an optional logger for panics of callbacks abandoned
+	// by message timeout after the context quits. If it is nil, the standard
+	// logger is used.
+	ErrorLog *log.Logger
+
+	// User data to use free.
+	UserData interface{}
+
+	handlersMu sync.RWMutex
+	handlers   map[string]jsonMessageFunc
+}
+
+type jsonMessageFunc func(c context.Context, ctx *JSONProtocolContext, msg json.RawMessage)
+
+// Handle registers the callback for messages of the type.
+func (prt *JSONProtocol) Handle(typ string, f func(ctx *JSONProtocolContext, msg json.RawMessage)) {
+	prt.HandleContext(typ, func(c context.Context, ctx *JSONProtocolContext, msg json.RawMessage) {
+		f(ctx, msg)
+	})
+}
+
+// HandleContext registers the callback for messages of the type, which
+// receives a context cancelled when message timeout exceeds or connection
+// closes.
+func (prt *JSONProtocol) HandleContext(typ string, f func(c context.Context, ctx *JSONProtocolContext, msg json.RawMessage)) {
+	prt.handlersMu.Lock()
+	if prt.handlers == nil {
+		prt.handlers = make(map[string]jsonMessageFunc)
+	}
+	prt.handlers[typ] = f
+	prt.handlersMu.Unlock()
+}
+
+// HandleJSON registers the callback for messages of the type, which receives
+// messages unmarshaled into T. Messages can't be unmarshaled are ignored.
+func HandleJSON[T any](prt *JSONProtocol, typ string, f func(ctx *JSONProtocolContext, msg *T)) {
+	prt.Handle(typ, func(ctx *JSONProtocolContext, raw json.RawMessage) {
+		msg := new(T)
+		if err := json.Unmarshal(raw, msg); err != nil {
+			return
+		}
+		f(ctx, msg)
+	})
+}
+
+func (prt *JSONProtocol) handler(typ string) jsonMessageFunc {
+	prt.handlersMu.RLock()
+	defer prt.handlersMu.RUnlock()
+	return prt.handlers[typ]
+}
+
+// Serve implements Handler.Serve.
+func (prt *JSONProtocol) Serve(conn net.Conn, closeCh <-chan struct{}) {
+	maxMessageSize := prt.MaxMessageSize
+	if maxMessageSize <= 0 {
+		maxMessageSize = DefMaxMessageSize
+	}
+	lr := &limitReader{
+		r: conn,
+	}
+	ctx := &JSONProtocolContext{
+		Prt:      prt,
+		Conn:     conn,
+		closeCh:  closeCh,
+		closeCh2: make(chan struct{}, 1),
+		lr:       lr,
+		limit:    maxMessageSize,
+		dec:      json.NewDecoder(lr),
+		enc:      json.NewEncoder(conn),
+	}
+	if prt.FlowControl {
+		ctx.credits = NewCredits(prt.InitialCredits)
+	}
+	if prt.MemoryBudget != nil {
+		ctx.mem = prt.MemoryBudget.NewAccount()
+		defer ctx.mem.Close()
+	}
+	ctx.serve()
+}
+
+// JSONProtocolContext defines parameters for streaming JSON protocol
+// context.
+type JSONProtocolContext struct {
+	// Pointer of JSONProtocol struct handled by this context.
+	Prt *JSONProtocol
+
+	// Connection handled by this context.
+	Conn net.Conn
+
+	// User data to use free.
+	UserData interface{}
+
+	closeCh  <-chan struct{}
+	closeCh2 chan struct{}
+	credits  *Credits
+	mem      *MemoryAccount
+	lr       *limitReader
+	limit    int
+	dec      *json.Decoder
+	enc      *json.Encoder
+	encMu    sync.Mutex
+
+	panicMu  sync.Mutex
+	panicked interface{}
+	quitted  bool
+}
+
+// Close closes context.
+func (ctx *JSONProtocolContext) Close() {
+	select {
+	case ctx.closeCh2 <- struct{}{}:
+	default:
+	}
+}
+
+// Grant grants n credits to read messages if flow control is enabled.
+func (ctx *JSONProtocolContext) Grant(n int) {
+	if ctx.credits != nil {
+		ctx.credits.Grant(n)
+	}
+}
+
+// Credits returns available credits to read messages. It returns -1 if flow
+// control isn't enabled.
+func (ctx *JSONProtocolContext) Credits() int {
+	if ctx.credits == nil {
+		return -1
+	}
+	return ctx.credits.Available()
+}
+
+func (ctx *JSONProtocolContext) serve() {
+	typeField := ctx.Prt.TypeField
+	if typeField == "" {
+		typeField = DefTypeField
+	}
+	// connCtx is cancelled when closeCh or closeCh2 is filled, so it is
+	// checked instead of them.
+	connCtx, cancel := closeContext(context.Background(), ctx.closeCh)
+	defer cancel()
+	connCtx, cancel2 := closeContext(connCtx, ctx.closeCh2)
+	defer cancel2()
+	if ctx.Prt.OnAccept != nil {
+		ctx.Prt.OnAccept(ctx)
+	}
+mainloop:
+	for {
+		ctx.repanic()
+		select {
+		case <-connCtx.Done():
+			break mainloop
+		default:
+		}
+		if ctx.credits != nil && !ctx.credits.Acquire(connCtx.Done(), nil) {
+			break mainloop
+		}
+		ctx.lr.n = ctx.limit
+		var msg json.RawMessage
+		if err := ctx.dec.Decode(&msg); err != nil {
+			break mainloop
+		}
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(msg, &fields); err != nil {
+			break mainloop
+		}
+		var typ string
+		json.Unmarshal(fields[typeField], &typ)
+		h := ctx.Prt.handler(typ)
+		if h == nil && ctx.Prt.OnMessage != nil {
+			h = func(c context.Context, ctx *JSONProtocolContext, msg json.RawMessage) {
+				ctx.Prt.OnMessage(ctx, typ, msg)
+			}
+		}
+		if h == nil {
+			continue
+		}
+		if ctx.mem != nil && ctx.mem.Reserve(len(msg)) != nil {
+			if ctx.Prt.MemoryBudget.Policy == MemoryEvict {
+				break mainloop
+			}
+			continue
+		}
+		ctx.handle(connCtx, h, typ, msg)
+	}
+	if ctx.Prt.OnQuit != nil {
+		ctx.Prt.OnQuit(ctx)
+	}
+	ctx.panicMu.Lock()
+	ctx.quitted = true
+	ctx.panicMu.Unlock()
+	ctx.repanic()
+}
+
+// repanic re-raises the panic of an abandoned callback in serving goroutine,
+// so it is recovered and logged by the server like panics of Handler.
+func (ctx *JSONProtocolContext) repanic() {
+	ctx.panicMu.Lock()
+	recovered := ctx.panicked
+	ctx.panicked = nil
+	ctx.panicMu.Unlock()
+	if recovered != nil {
+		panic(recovered)
+	}
+}
+
+// abandonedPanic passes the panic of an abandoned callback to serving
+// goroutine, or logs it if the context quitted.
+func (ctx *JSONProtocolContext) abandonedPanic(recovered interface{}, stack []byte) {
+	ctx.panicMu.Lock()
+	defer ctx.panicMu.Unlock()
+	if !ctx.quitted && ctx.panicked == nil {
+		ctx.panicked = recovered
+		return
+	}
+	errorLog := ctx.Prt.ErrorLog
+	if errorLog == nil {
+		errorLog = log.Default()
+	}
+	errorLog.Printf("json protocol: abandoned callback panic: %v\n%s", recovered, stack)
+}
+
+// handle calls the callback of message, and waits for it to return until
+// message timeout exceeds.
+func (ctx *JSONProtocolContext) handle(connCtx context.Context, h jsonMessageFunc, typ string, msg json.RawMessage) {
+	timeout := ctx.Prt.MessageTimeout
+	if ctx.Prt.MessageTimeoutFunc != nil {
+		if t := ctx.Prt.MessageTimeoutFunc(typ, msg); t > 0 {
+			timeout = t
+		}
+	}
+	if timeout <= 0 {
+		defer ctx.release(msg)
+		h(connCtx, ctx, msg)
+		return
+	}
+	c, cancel := context.WithTimeout(connCtx, timeout)
+	defer cancel()
+	doneCh := make(chan struct{})
+	var mu sync.Mutex
+	var recovered interface{}
+	abandoned := false
+	go func() {
+		defer close(doneCh)
+		// memory of message is released when callback returns, even if
+		// it is abandoned by timeout.
+		defer ctx.release(msg)
+		defer func() {
+			// panic is re-raised in serving goroutine, immediately unless
+			// timed out.
+			e := recover()
+			if e == nil {
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if abandoned {
+				ctx.abandonedPanic(e, debug.Stack())
+				return
+			}
+			recovered = e
+		}()
+		h(c, ctx, msg)
+	}()
+	select {
+	case <-doneCh:
+		if recovered != nil {
+			panic(recovered)
+		}
+	case <-c.Done():
+		mu.Lock()
+		abandoned = true
+		e := recovered
+		mu.Unlock()
+		if e != nil {
+			panic(e)
+		}
+		if c.Err() == context.DeadlineExceeded && ctx.Prt.OnTimeout != nil {
+			ctx.Prt.OnTimeout(ctx, typ, msg)
+		}
+	}
+}
+
+// release releases memory of the message reserved from memory budget.
+func (ctx *JSONProtocolContext) release(msg json.RawMessage) {
+	if ctx.mem != nil {
+		ctx.mem.Release(len(msg))
+	}
+}
+
+// WriteMessage writes JSON encoding of v to connection. It is safe to call
+// concurrently.
+func (ctx *JSONProtocolContext) WriteMessage(v interface{}) error {
+	ctx.encMu.Lock()
+	defer ctx.encMu.Unlock()
+	if err := ctx.enc.Encode(v); err != nil {
+		ctx.Close()
+		return err
+	}
+	return nil
+}
+
+// limitReader reads from r at most n bytes, and returns
+// ErrBufferLimitExceeded after.
+type limitReader struct {
+	r io.Reader
+	n int
+}
+
+func (lr *limitReader) Read(p []byte) (n int, err error) {
+	if lr.n <= 0 {
+		return 0, ErrBufferLimitExceeded
+	}
+	if len(p) > lr.n {
+		p = p[:lr.n]
+	}
+	n, err = lr.r.Read(p)
+	lr.n -= n
+	return
+}
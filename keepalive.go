@@ -0,0 +1,18 @@
+package tcpserver
+
+import (
+	"net"
+)
+
+// setKeepAlive applies keepalive parameters of the server to the TCP
+// connection.
+func (srv *TCPServer) setKeepAlive(conn net.Conn) {
+	if !srv.KeepAlive {
+		return
+	}
+	tc, ok := unwrapConn(conn).(*net.TCPConn)
+	if !ok {
+		return
+	}
+	setKeepAlive(tc, srv.KeepAlivePeriod, srv.KeepAliveInterval, srv.KeepAliveCount)
+}
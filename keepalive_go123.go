@@ -0,0 +1,17 @@
+//go:build go1.23
+
+package tcpserver
+
+import (
+	"net"
+	"time"
+)
+
+func setKeepAlive(tc *net.TCPConn, idle, interval time.Duration, count int) {
+	tc.SetKeepAliveConfig(net.KeepAliveConfig{
+		Enable:   true,
+		Idle:     idle,
+		Interval: interval,
+		Count:    count,
+	})
+}
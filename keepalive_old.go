@@ -0,0 +1,16 @@
+//go:build !go1.23
+
+package tcpserver
+
+import (
+	"net"
+	"time"
+)
+
+// setKeepAlive ignores interval and count, they need Go 1.23 or later.
+func setKeepAlive(tc *net.TCPConn, idle, interval time.Duration, count int) {
+	tc.SetKeepAlive(true)
+	if idle > 0 {
+		tc.SetKeepAlivePeriod(idle)
+	}
+}
@@ -0,0 +1,72 @@
+package tcpserver
+
+import (
+	"net"
+	"sync"
+)
+
+// A ConcurrencyLimiter is a Handler that limits concurrently executing
+// Serve methods of its Handler. Separate limiters can be used on each
+// listener or protocol, so an expensive Handler can't starve others on the
+// same server.
+type ConcurrencyLimiter struct {
+	// Handler to invoke.
+	Handler Handler
+
+	// Max specifies maximum count of concurrently executing Handler. If it is
+	// 0, there is no limit.
+	Max int
+
+	// Wait makes connections wait for a free slot instead of being closed when
+	// limit is reached. Waiting stops when closeCh is filled.
+	Wait bool
+
+	// Reject callback. It will be called before closing a connection rejected
+	// by limit, to send a busy response for example.
+	OnReject func(conn net.Conn)
+
+	once sync.Once
+	sem  chan struct{}
+}
+
+// Serve implements Handler.Serve.
+func (cl *ConcurrencyLimiter) Serve(conn net.Conn, closeCh <-chan struct{}) {
+	if cl.Max <= 0 {
+		cl.Handler.Serve(conn, closeCh)
+		return
+	}
+	cl.once.Do(func() {
+		cl.sem = make(chan struct{}, cl.Max)
+	})
+	if cl.Wait {
+		select {
+		case cl.sem <- struct{}{}:
+		case <-closeCh:
+			return
+		}
+	} else {
+		select {
+		case cl.sem <- struct{}{}:
+		default:
+			if cl.OnReject != nil {
+				cl.OnReject(conn)
+			}
+			return
+		}
+	}
+	defer func() {
+		<-cl.sem
+	}()
+	cl.Handler.Serve(conn, closeCh)
+}
+
+// Active returns count of executing Handler.
+func (cl *ConcurrencyLimiter) Active() int {
+	if cl.Max <= 0 {
+		return 0
+	}
+	cl.once.Do(func() {
+		cl.sem = make(chan struct{}, cl.Max)
+	})
+	return len(cl.sem)
+}
@@ -0,0 +1,142 @@
+package tcpserver
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+)
+
+// LineHandler is a Handler of line-oriented text protocols. It reads lines
+// terminated by LF or CRLF, and passes them to OnLine without the terminator.
+type LineHandler struct {
+	// Accept callback. It will be called before reading lines, to write a
+	// greeting for example.
+	OnAccept func(w *LineWriter)
+
+	// Line callback. It will be called for each line.
+	OnLine func(w *LineWriter, line string)
+
+	// LineTooLong callback. It will be called when a line exceeds
+	// MaxLineSize, and the rest of the line is discarded. If it is nil, the
+	// connection is closed.
+	OnLineTooLong func(w *LineWriter)
+
+	// MaxLineSize specifies maximum line size with terminator. If it is 0,
+	// DefMaxLineSize is used.
+	MaxLineSize int
+
+	// Newline specifies terminator of written lines. If it is empty, "\r\n"
+	// is used.
+	Newline string
+}
+
+// Serve implements Handler.Serve.
+func (lh *LineHandler) Serve(conn net.Conn, closeCh <-chan struct{}) {
+	maxLineSize := lh.MaxLineSize
+	if maxLineSize <= 0 {
+		maxLineSize = DefMaxLineSize
+	}
+	newline := lh.Newline
+	if newline == "" {
+		newline = "\r\n"
+	}
+	w := &LineWriter{
+		Conn:     conn,
+		newline:  newline,
+		closeCh2: make(chan struct{}, 1),
+		wr:       bufio.NewWriter(conn),
+	}
+	rd := bufio.NewReader(conn)
+	if lh.OnAccept != nil {
+		lh.OnAccept(w)
+	}
+	for {
+		select {
+		case <-closeCh:
+			return
+		case <-w.closeCh2:
+			return
+		default:
+		}
+		line, err := ReadBytesLimit(rd, '\n', maxLineSize)
+		if err == bufio.ErrBufferFull || err == ErrBufferLimitExceeded {
+			if lh.OnLineTooLong == nil {
+				return
+			}
+			if err == bufio.ErrBufferFull {
+				if err = discardLine(rd); err != nil {
+					return
+				}
+			}
+			lh.OnLineTooLong(w)
+			continue
+		}
+		if err != nil {
+			return
+		}
+		line = bytes.TrimSuffix(line, []byte("\n"))
+		line = bytes.TrimSuffix(line, []byte("\r"))
+		if lh.OnLine != nil {
+			lh.OnLine(w, string(line))
+		}
+	}
+}
+
+// discardLine discards data until the next LF.
+func discardLine(rd *bufio.Reader) error {
+	for {
+		_, err := rd.ReadSlice('\n')
+		if err != bufio.ErrBufferFull {
+			return err
+		}
+	}
+}
+
+// A LineWriter writes responses of LineHandler.
+type LineWriter struct {
+	// Connection handled by the LineHandler.
+	Conn net.Conn
+
+	// User data to use free.
+	UserData interface{}
+
+	newline  string
+	closeCh2 chan struct{}
+	wr       *bufio.Writer
+}
+
+// WriteLine writes the line with terminator, and flushes.
+func (w *LineWriter) WriteLine(line string) error {
+	w.wr.WriteString(line)
+	w.wr.WriteString(w.newline)
+	return w.Flush()
+}
+
+// Printf writes the formatted line with terminator, and flushes.
+func (w *LineWriter) Printf(format string, a ...interface{}) error {
+	return w.WriteLine(fmt.Sprintf(format, a...))
+}
+
+// Write writes buf without flushing, for responses of multiple parts.
+func (w *LineWriter) Write(buf []byte) (int, error) {
+	return w.wr.Write(buf)
+}
+
+// Flush writes buffered data to connection. If it fails, the LineHandler is
+// closed.
+func (w *LineWriter) Flush() error {
+	if err := w.wr.Flush(); err != nil {
+		w.Close()
+		return err
+	}
+	return nil
+}
+
+// Close closes the LineHandler after the current callback returns.
+func (w *LineWriter) Close() {
+	select {
+	case w.closeCh2 <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,147 @@
+package tcpserver
+
+import (
+	"context"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+)
+
+// listenOnce listens on the address by ListenConfig. If ReusePort is set,
+// SO_REUSEPORT is set on the socket before Control of ListenConfig.
+func (srv *TCPServer) listenOnce(network, addr string) (net.Listener, error) {
+	lc := &net.ListenConfig{}
+	if srv.ListenConfig != nil {
+		*lc = *srv.ListenConfig
+	}
+	if srv.ReusePort != 0 {
+		control := lc.Control
+		lc.Control = func(network, address string, c syscall.RawConn) error {
+			if err := reusePortControl(network, address, c); err != nil {
+				return err
+			}
+			if control != nil {
+				return control(network, address, c)
+			}
+			return nil
+		}
+	}
+	return lc.Listen(context.Background(), network, addr)
+}
+
+// listenAddr listens on the unix domain socket address, or on the address by
+// ReusePort listeners.
+func (srv *TCPServer) listenAddr(network, addr string) ([]net.Listener, error) {
+	if path, ok := unixAddr(addr); ok {
+		opts := srv.UnixSocketOptions
+		if opts == nil {
+			opts = &UnixSocketOptions{RemoveStale: true}
+		}
+		l, err := ListenUnix(path, opts)
+		if err != nil {
+			return nil, err
+		}
+		return []net.Listener{l}, nil
+	}
+	n := srv.reusePortCount()
+	ls := make([]net.Listener, 0, n)
+	for i := 0; i < n; i++ {
+		l, err := srv.listen(network, addr)
+		if err != nil {
+			closeListeners(ls)
+			return nil, err
+		}
+		ls = append(ls, l)
+	}
+	return ls, nil
+}
+
+// listenAndServe listens on Addr and Addrs, and calls serve for each listener
+// concurrently by serveAll.
+func (srv *TCPServer) listenAndServe(network string, serve func(l net.Listener) error) error {
+	addrs := append([]string{srv.Addr}, srv.Addrs...)
+	var ls []net.Listener
+	for _, addr := range addrs {
+		l, err := srv.listenAddr(network, addr)
+		if err != nil {
+			closeListeners(ls)
+			return err
+		}
+		ls = append(ls, l...)
+	}
+	return serveAll(ls, serve)
+}
+
+// ServeListeners is like Serve, but it serves the listeners concurrently. If
+// serving any listener returns, the other listeners are closed. It returns
+// error of the first returned listener.
+func (srv *TCPServer) ServeListeners(ls []net.Listener) error {
+	return serveAll(ls, srv.Serve)
+}
+
+// serveAll calls serve for each listener concurrently. If any serve returns,
+// the other listeners are closed. It returns error of the first returned
+// serve.
+func serveAll(ls []net.Listener, serve func(l net.Listener) error) error {
+	if len(ls) == 1 {
+		return serve(ls[0])
+	}
+	var wg sync.WaitGroup
+	var once sync.Once
+	var err error
+	for _, l := range ls {
+		wg.Add(1)
+		go func(l net.Listener) {
+			defer wg.Done()
+			e := serve(l)
+			once.Do(func() {
+				err = e
+				closeListeners(ls)
+			})
+		}(l)
+	}
+	wg.Wait()
+	return err
+}
+
+func closeListeners(ls []net.Listener) {
+	for _, l := range ls {
+		l.Close()
+	}
+}
+
+// ListenerAddrs returns addresses of the listeners served by the server.
+func (srv *TCPServer) ListenerAddrs() []net.Addr {
+	srv.connsMu.RLock()
+	defer srv.connsMu.RUnlock()
+	addrs := make([]net.Addr, 0, len(srv.listeners))
+	for _, l := range srv.listeners {
+		addrs = append(addrs, l.Addr())
+	}
+	return addrs
+}
+
+// FileListener returns a listener of the inherited file descriptor, like a
+// socket pre-opened by a supervisor. The file descriptor is closed, and the
+// listener uses a duplicate of it. So closing the listener releases the
+// socket completely.
+func FileListener(fd uintptr) (net.Listener, error) {
+	f := os.NewFile(fd, "listener")
+	if f == nil {
+		return nil, ErrNoFD
+	}
+	defer f.Close()
+	return net.FileListener(f)
+}
+
+// ServeFD is like Serve, but it serves the listener of the inherited file
+// descriptor by FileListener. Close and Shutdown close the listener like
+// others.
+func (srv *TCPServer) ServeFD(fd uintptr) error {
+	l, err := FileListener(fd)
+	if err != nil {
+		return err
+	}
+	return srv.Serve(l)
+}
@@ -0,0 +1,75 @@
+package tcpserver
+
+import (
+	"context"
+	"crypto/tls"
+	"log"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// ListenerConfig defines per-listener overrides of TCPServer parameters. Zero
+// fields fall back to parameters of TCPServer.
+type ListenerConfig struct {
+	// Handler to invoke instead of TCPServer.Handler.
+	Handler Handler
+
+	// TLSConfig optionally provides a TLS configuration. If it isn't nil,
+	// connections of the listener are served over TLS.
+	TLSConfig *tls.Config
+
+	// TLSHandshakeTimeout overrides TCPServer.TLSHandshakeTimeout.
+	TLSHandshakeTimeout time.Duration
+
+	// ErrorLog specifies an optional logger for errors in Handler.
+	ErrorLog *log.Logger
+
+	// Logger optionally overrides TCPServer.Logger.
+	Logger Logger
+
+	// Greeting and GreetingFunc override TCPServer.Greeting and
+	// TCPServer.GreetingFunc if any of them is set.
+	Greeting     []byte
+	GreetingFunc func(conn net.Conn) []byte
+
+	// GreetingTimeout overrides TCPServer.GreetingTimeout.
+	GreetingTimeout time.Duration
+
+	// MaxConns specifies maximum count of concurrent connections of the
+	// listener. Connections over the limit are closed immediately after
+	// accept. If it is 0, there is no limit.
+	MaxConns int
+
+	// OnAccept optionally is called in the accept loop after accepting each
+	// connection. If it returns false, the connection is closed. It shouldn't
+	// block long, because it blocks accepting. If ProxyProtocol is enabled,
+	// RemoteAddr of the connection reads the PROXY header, so it should be
+	// avoided or checked in Handler instead.
+	OnAccept func(conn net.Conn) bool
+}
+
+// listenerState defines state of a listener served by TCPServer.
+type listenerState struct {
+	config *ListenerConfig
+	ctx    context.Context
+	conns  int32
+}
+
+// accept reports whether the connection is accepted by the listener. If it
+// returns true, release must be called after the connection is closed.
+func (ls *listenerState) accept(conn net.Conn) bool {
+	if n := atomic.AddInt32(&ls.conns, 1); ls.config.MaxConns > 0 && int(n) > ls.config.MaxConns {
+		atomic.AddInt32(&ls.conns, -1)
+		return false
+	}
+	if ls.config.OnAccept != nil && !ls.config.OnAccept(conn) {
+		atomic.AddInt32(&ls.conns, -1)
+		return false
+	}
+	return true
+}
+
+func (ls *listenerState) release() {
+	atomic.AddInt32(&ls.conns, -1)
+}
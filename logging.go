@@ -0,0 +1,93 @@
+package tcpserver
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+	"strings"
+)
+
+// A Logger logs structured events of the server. Adapters are provided for
+// log, log/slog, zap and zerolog.
+type Logger interface {
+	// Enabled reports whether events of the level are logged.
+	Enabled(level slog.Level) bool
+
+	// Log logs the event with the attributes.
+	Log(level slog.Level, msg string, attrs ...slog.Attr)
+}
+
+// SlogLogger returns a Logger logging to l.
+func SlogLogger(l *slog.Logger) Logger {
+	return slogLogger{l: l}
+}
+
+type slogLogger struct {
+	l *slog.Logger
+}
+
+func (s slogLogger) Enabled(level slog.Level) bool {
+	return s.l.Enabled(context.Background(), level)
+}
+
+func (s slogLogger) Log(level slog.Level, msg string, attrs ...slog.Attr) {
+	s.l.LogAttrs(context.Background(), level, msg, attrs...)
+}
+
+// StdLogger returns a Logger printing events of minLevel or higher to l, with
+// the attributes as key=value pairs.
+func StdLogger(l *log.Logger, minLevel slog.Level) Logger {
+	return stdLogger{l: l, minLevel: minLevel}
+}
+
+type stdLogger struct {
+	l        *log.Logger
+	minLevel slog.Level
+}
+
+func (s stdLogger) Enabled(level slog.Level) bool {
+	return level >= s.minLevel
+}
+
+func (s stdLogger) Log(level slog.Level, msg string, attrs ...slog.Attr) {
+	if level < s.minLevel {
+		return
+	}
+	var sb strings.Builder
+	sb.WriteString(msg)
+	for _, a := range attrs {
+		fmt.Fprintf(&sb, " %s=%v", a.Key, a.Value)
+	}
+	s.l.Print(sb.String())
+}
+
+// logger returns the logger of the listener or the server. If Logger is nil,
+// events of warning level or higher are printed to ErrorLog.
+func (srv *TCPServer) logger(lc *ListenerConfig) Logger {
+	if lc != nil && lc.Logger != nil {
+		return lc.Logger
+	}
+	if srv.Logger != nil {
+		return srv.Logger
+	}
+	return stdLogger{l: srv.errorLog(lc), minLevel: slog.LevelWarn}
+}
+
+// log logs the event by the logger of the listener or the server.
+func (srv *TCPServer) log(lc *ListenerConfig, level slog.Level, msg string, attrs ...slog.Attr) {
+	srv.logger(lc).Log(level, msg, attrs...)
+}
+
+// logEnabled reports whether the event of the level is logged.
+func (srv *TCPServer) logEnabled(lc *ListenerConfig, level slog.Level) bool {
+	return srv.logger(lc).Enabled(level)
+}
+
+// connAttrs returns attributes of the connection for logging.
+func (cc *connContext) connAttrs() []slog.Attr {
+	return []slog.Attr{
+		slog.Uint64("conn_id", cc.id),
+		slog.String("remote_addr", cc.conn.RemoteAddr().String()),
+	}
+}
@@ -0,0 +1,90 @@
+package tcpserver
+
+import (
+	"log/slog"
+	"sync/atomic"
+	"time"
+)
+
+// AutoMaxConns can be used as TCPServer.MaxConns to derive the limit from
+// file descriptor budget of the process.
+const AutoMaxConns = -1
+
+// OverflowPolicy specifies the behavior when TCPServer.MaxConns is reached.
+type OverflowPolicy int
+
+// Overflow policies.
+const (
+	// OverflowBackpressure stops accepting until a connection closes, so new
+	// connections wait in backlog of the listener.
+	OverflowBackpressure OverflowPolicy = iota
+
+	// OverflowClose accepts and closes new connections immediately.
+	OverflowClose
+
+	// OverflowReject accepts new connections, writes OverflowResponse and
+	// closes them.
+	OverflowReject
+)
+
+// DefOverflowResponseTimeout specifies write timeout of
+// TCPServer.OverflowResponse.
+var DefOverflowResponseTimeout = 1 * time.Second
+
+// maxConns returns the effective limit of MaxConns, or 0 if there is no limit.
+func (srv *TCPServer) maxConns() int {
+	if max := srv.maxConnsField(); max != AutoMaxConns {
+		if max < 0 {
+			return 0
+		}
+		return max
+	}
+	b, err := CurrentFDBudget()
+	if err != nil {
+		return 0
+	}
+	return b.SafeMaxConns()
+}
+
+// checkMaxConns warns if the limit exceeds file descriptor budget.
+func (srv *TCPServer) checkMaxConns(max int) {
+	if max <= 0 || srv.MaxConns == AutoMaxConns {
+		return
+	}
+	b, err := CurrentFDBudget()
+	if err != nil {
+		return
+	}
+	if safe := b.SafeMaxConns(); max > safe {
+		srv.log(nil, slog.LevelWarn, "MaxConns exceeds file descriptor budget", slog.Int("max_conns", max),
+			slog.Int("fds_open", b.Open), slog.Uint64("fds_limit", b.Limit), slog.Int("safe_max_conns", safe))
+	}
+}
+
+// waitConnSlot waits until a connection slot is free for OverflowBackpressure,
+// and reports whether the server isn't closed.
+func (srv *TCPServer) waitConnSlot(max int, closedCh <-chan struct{}) bool {
+	if int(atomic.LoadInt32(&srv.active)) < max {
+		return true
+	}
+	atomic.AddInt32(&srv.slotWaiters, 1)
+	defer atomic.AddInt32(&srv.slotWaiters, -1)
+	for {
+		srv.connsMu.Lock()
+		if srv.slotFreedCh == nil {
+			srv.slotFreedCh = make(chan struct{})
+		}
+		slotFreedCh := srv.slotFreedCh
+		srv.connsMu.Unlock()
+		// releaseActive signals slotFreedCh after this check if a slot
+		// is freed, because the waiter is counted before.
+		if int(atomic.LoadInt32(&srv.active)) < max {
+			return true
+		}
+		select {
+		case <-slotFreedCh:
+		case <-closedCh:
+			return false
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package tcpserver
+
+import (
+	"errors"
+	"math"
+	"runtime/debug"
+	"sync/atomic"
+)
+
+// DefMemoryLimitRatio specifies ratio of GOMEMLIMIT to use as aggregate limit
+// if MemoryBudget.Limit is 0.
+var DefMemoryLimitRatio = 0.5
+
+var (
+	// ErrMemoryBudgetExceeded is returned when a memory reservation exceeds
+	// limits of MemoryBudget.
+	ErrMemoryBudgetExceeded = errors.New("memory budget exceeded")
+)
+
+// MemoryPolicy specifies the action when a memory budget is exceeded.
+type MemoryPolicy int
+
+// Memory policies.
+const (
+	// MemoryReject rejects the message that exceeds the budget, and keeps
+	// the connection.
+	MemoryReject MemoryPolicy = iota
+
+	// MemoryEvict closes the connection that exceeds the budget.
+	MemoryEvict
+)
+
+// MemoryBudget defines limits of buffer memory of connections. Protocols
+// reserve memory of their buffers from accounts of connections, so a peer
+// can't balloon memory of the server. It is safe for concurrent use.
+type MemoryBudget struct {
+	// ConnLimit specifies maximum buffer memory of a connection in bytes. If
+	// it is 0, there is no limit.
+	ConnLimit int64
+
+	// Limit specifies maximum buffer memory of all connections in bytes. If
+	// it is 0, DefMemoryLimitRatio of GOMEMLIMIT is used if GOMEMLIMIT is set.
+	// If it is negative, there is no limit.
+	Limit int64
+
+	// Policy specifies the action when the budget is exceeded.
+	Policy MemoryPolicy
+
+	used int64
+}
+
+// NewAccount returns a new account of a connection.
+func (mb *MemoryBudget) NewAccount() *MemoryAccount {
+	return &MemoryAccount{
+		budget: mb,
+	}
+}
+
+// Used returns reserved memory of all connections in bytes.
+func (mb *MemoryBudget) Used() int64 {
+	return atomic.LoadInt64(&mb.used)
+}
+
+func (mb *MemoryBudget) limit() int64 {
+	if mb.Limit != 0 {
+		return mb.Limit
+	}
+	memLimit := debug.SetMemoryLimit(-1)
+	if memLimit == math.MaxInt64 {
+		return -1
+	}
+	return int64(float64(memLimit) * DefMemoryLimitRatio)
+}
+
+// MemoryAccount tracks buffer memory of a connection against MemoryBudget.
+// It is safe for concurrent use.
+type MemoryAccount struct {
+	budget *MemoryBudget
+	used   int64
+}
+
+// Reserve reserves n bytes. It returns ErrMemoryBudgetExceeded without
+// reserving if the budget is exceeded.
+func (a *MemoryAccount) Reserve(n int) error {
+	mb := a.budget
+	used := atomic.AddInt64(&a.used, int64(n))
+	if mb.ConnLimit > 0 && used > mb.ConnLimit {
+		atomic.AddInt64(&a.used, -int64(n))
+		return ErrMemoryBudgetExceeded
+	}
+	total := atomic.AddInt64(&mb.used, int64(n))
+	if limit := mb.limit(); limit >= 0 && total > limit {
+		atomic.AddInt64(&mb.used, -int64(n))
+		atomic.AddInt64(&a.used, -int64(n))
+		return ErrMemoryBudgetExceeded
+	}
+	return nil
+}
+
+// Release releases n bytes reserved before. Releasing after Close is
+// ignored.
+func (a *MemoryAccount) Release(n int) {
+	for {
+		used := atomic.LoadInt64(&a.used)
+		m := int64(n)
+		if m > used {
+			m = used
+		}
+		if atomic.CompareAndSwapInt64(&a.used, used, used-m) {
+			atomic.AddInt64(&a.budget.used, -m)
+			return
+		}
+	}
+}
+
+// Used returns reserved memory of the connection in bytes.
+func (a *MemoryAccount) Used() int64 {
+	return atomic.LoadInt64(&a.used)
+}
+
+// Close releases all reserved memory of the account.
+func (a *MemoryAccount) Close() {
+	if used := atomic.SwapInt64(&a.used, 0); used != 0 {
+		atomic.AddInt64(&a.budget.used, -used)
+	}
+}
@@ -0,0 +1,308 @@
+// Package memcache provides memcached text protocol Handler for tcpserver.
+package memcache
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/orkunkaraduman/go-tcpserver"
+)
+
+// DefMaxValueSize specifies maximum value size if Server.MaxValueSize is 0.
+var DefMaxValueSize = 1 * 1024 * 1024
+
+// MaxKeySize is maximum key size of memcached text protocol.
+const MaxKeySize = 250
+
+var (
+	// ErrCacheMiss should be returned by OnGet and OnDelete callbacks when
+	// item doesn't exist.
+	ErrCacheMiss = errors.New("cache miss")
+
+	// ErrNotStored should be returned by OnStore callback when item isn't
+	// stored because of condition of command.
+	ErrNotStored = errors.New("not stored")
+
+	// ErrCASConflict should be returned by OnStore callback on cas command
+	// when item has been modified since it was fetched.
+	ErrCASConflict = errors.New("compare-and-swap conflict")
+
+	// ErrUnknownCommand is returned by ParseCommand when command is unknown.
+	ErrUnknownCommand = errors.New("unknown command")
+
+	// ErrInvalidCommand is returned by ParseCommand when command line is
+	// malformed.
+	ErrInvalidCommand = errors.New("invalid command")
+)
+
+// An Item is an item of cache.
+type Item struct {
+	Key     string
+	Flags   uint32
+	Exptime int64
+	Value   []byte
+	CAS     uint64
+}
+
+// A Command is a parsed command line.
+type Command struct {
+	// Name of command in lower case.
+	Name string
+
+	// Keys of retrieval commands, or key of other commands.
+	Keys []string
+
+	// Parameters of storage commands.
+	Flags   uint32
+	Exptime int64
+	Bytes   int
+	CAS     uint64
+
+	// NoReply is true if command line has noreply option.
+	NoReply bool
+}
+
+// ParseCommand parses command line without delimiter.
+func ParseCommand(line string) (cmd *Command, err error) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return nil, ErrUnknownCommand
+	}
+	cmd = &Command{Name: strings.ToLower(fields[0])}
+	args := fields[1:]
+	if n := len(args); n > 0 && args[n-1] == "noreply" {
+		cmd.NoReply = true
+		args = args[:n-1]
+	}
+	for _, key := range args {
+		if len(key) > MaxKeySize {
+			return nil, ErrInvalidCommand
+		}
+	}
+	switch cmd.Name {
+	case "get", "gets":
+		if len(args) == 0 || cmd.NoReply {
+			return nil, ErrInvalidCommand
+		}
+		cmd.Keys = args
+	case "set", "add", "replace", "append", "prepend", "cas":
+		n := 4
+		if cmd.Name == "cas" {
+			n = 5
+		}
+		if len(args) != n {
+			return nil, ErrInvalidCommand
+		}
+		cmd.Keys = args[:1]
+		flags, e1 := strconv.ParseUint(args[1], 10, 32)
+		exptime, e2 := strconv.ParseInt(args[2], 10, 64)
+		size, e3 := strconv.Atoi(args[3])
+		if e1 != nil || e2 != nil || e3 != nil || size < 0 {
+			return nil, ErrInvalidCommand
+		}
+		cmd.Flags, cmd.Exptime, cmd.Bytes = uint32(flags), exptime, size
+		if cmd.Name == "cas" {
+			if cmd.CAS, err = strconv.ParseUint(args[4], 10, 64); err != nil {
+				return nil, ErrInvalidCommand
+			}
+		}
+	case "delete":
+		if len(args) != 1 {
+			return nil, ErrInvalidCommand
+		}
+		cmd.Keys = args
+	case "version", "quit":
+		if len(args) != 0 {
+			return nil, ErrInvalidCommand
+		}
+	default:
+		return nil, ErrUnknownCommand
+	}
+	return cmd, nil
+}
+
+// Server defines parameters for Handler of memcached text protocol.
+type Server struct {
+	// Get callback. It will be called for each key of get and gets commands.
+	OnGet func(ctx *Context, key string) (*Item, error)
+
+	// Store callback. It will be called with name of command on set, add,
+	// replace, append, prepend and cas commands.
+	OnStore func(ctx *Context, command string, item *Item) error
+
+	// Delete callback. It will be called on delete command.
+	OnDelete func(ctx *Context, key string) error
+
+	// Version to reply version command.
+	Version string
+
+	// MaxValueSize specifies maximum value size.
+	MaxValueSize int
+
+	// User data to use free.
+	UserData interface{}
+}
+
+// Context defines parameters for memcached text protocol context.
+type Context struct {
+	// Pointer of Server struct handled by this context.
+	Srv *Server
+
+	// Underlying text protocol context.
+	Prt *tcpserver.TextProtocolContext
+
+	// Connection handled by this context.
+	Conn net.Conn
+
+	// User data to use free.
+	UserData interface{}
+
+	cmd *Command
+}
+
+// Close closes context.
+func (ctx *Context) Close() {
+	ctx.Prt.Close()
+}
+
+// Serve implements tcpserver.Handler.Serve.
+func (srv *Server) Serve(conn net.Conn, closeCh <-chan struct{}) {
+	prt := &tcpserver.TextProtocol{
+		OnAccept: func(pctx *tcpserver.TextProtocolContext) {
+			pctx.UserData = &Context{
+				Srv:  srv,
+				Prt:  pctx,
+				Conn: pctx.Conn,
+			}
+		},
+		OnReadLine: func(pctx *tcpserver.TextProtocolContext, line string) int {
+			return pctx.UserData.(*Context).readLine(line)
+		},
+		OnReadData: func(pctx *tcpserver.TextProtocolContext, buf []byte) {
+			pctx.UserData.(*Context).readData(buf)
+		},
+		MaxLineSize: 8 * 1024,
+	}
+	prt.Serve(conn, closeCh)
+}
+
+func (ctx *Context) reply(cmd *Command, line string) {
+	if cmd != nil && cmd.NoReply {
+		return
+	}
+	ctx.Prt.WriteLine(line)
+}
+
+func (ctx *Context) serverError(cmd *Command, err error) {
+	ctx.reply(cmd, "SERVER_ERROR "+err.Error())
+}
+
+func (ctx *Context) readLine(line string) int {
+	cmd, err := ParseCommand(line)
+	if err != nil {
+		if err == ErrUnknownCommand {
+			ctx.reply(nil, "ERROR")
+			return 0
+		}
+		ctx.reply(nil, "CLIENT_ERROR bad command line format")
+		return 0
+	}
+	srv := ctx.Srv
+	switch cmd.Name {
+	case "get", "gets":
+		var buf bytes.Buffer
+		for _, key := range cmd.Keys {
+			if srv.OnGet == nil {
+				break
+			}
+			item, err := srv.OnGet(ctx, key)
+			if err == ErrCacheMiss || (err == nil && item == nil) {
+				continue
+			}
+			if err != nil {
+				ctx.serverError(nil, err)
+				return 0
+			}
+			buf.WriteString("VALUE " + key + " " + strconv.FormatUint(uint64(item.Flags), 10) + " " + strconv.Itoa(len(item.Value)))
+			if cmd.Name == "gets" {
+				buf.WriteString(" " + strconv.FormatUint(item.CAS, 10))
+			}
+			buf.WriteString("\r\n")
+			buf.Write(item.Value)
+			buf.WriteString("\r\n")
+		}
+		buf.WriteString("END\r\n")
+		ctx.Prt.WriteData(buf.Bytes())
+	case "set", "add", "replace", "append", "prepend", "cas":
+		maxValueSize := srv.MaxValueSize
+		if maxValueSize <= 0 {
+			maxValueSize = DefMaxValueSize
+		}
+		if cmd.Bytes > maxValueSize {
+			ctx.reply(nil, "SERVER_ERROR object too large for cache")
+			ctx.Close()
+			return 0
+		}
+		ctx.cmd = cmd
+		return cmd.Bytes + 2
+	case "delete":
+		if srv.OnDelete == nil {
+			ctx.reply(cmd, "NOT_FOUND")
+			break
+		}
+		err := srv.OnDelete(ctx, cmd.Keys[0])
+		switch err {
+		case nil:
+			ctx.reply(cmd, "DELETED")
+		case ErrCacheMiss:
+			ctx.reply(cmd, "NOT_FOUND")
+		default:
+			ctx.serverError(cmd, err)
+		}
+	case "version":
+		ctx.reply(nil, "VERSION "+srv.Version)
+	case "quit":
+		ctx.Close()
+	}
+	return 0
+}
+
+func (ctx *Context) readData(buf []byte) {
+	cmd := ctx.cmd
+	ctx.cmd = nil
+	if cmd == nil {
+		return
+	}
+	if !bytes.HasSuffix(buf, []byte("\r\n")) {
+		ctx.reply(nil, "CLIENT_ERROR bad data chunk")
+		ctx.Close()
+		return
+	}
+	item := &Item{
+		Key:     cmd.Keys[0],
+		Flags:   cmd.Flags,
+		Exptime: cmd.Exptime,
+		Value:   buf[:len(buf)-2],
+		CAS:     cmd.CAS,
+	}
+	if ctx.Srv.OnStore == nil {
+		ctx.reply(cmd, "NOT_STORED")
+		return
+	}
+	err := ctx.Srv.OnStore(ctx, cmd.Name, item)
+	switch err {
+	case nil:
+		ctx.reply(cmd, "STORED")
+	case ErrNotStored:
+		ctx.reply(cmd, "NOT_STORED")
+	case ErrCASConflict:
+		ctx.reply(cmd, "EXISTS")
+	case ErrCacheMiss:
+		ctx.reply(cmd, "NOT_FOUND")
+	default:
+		ctx.serverError(cmd, err)
+	}
+}
@@ -0,0 +1,35 @@
+package tcpserver
+
+// A Middleware wraps a Handler to add behavior around serving connections,
+// like logging, recovery, metrics, authentication or rate limiting. It should
+// return a HandlerContext too if next is a HandlerContext, to keep the context
+// of the connection.
+type Middleware func(next Handler) Handler
+
+// Chain returns h wrapped by the middlewares. The first middleware is the
+// outermost.
+func Chain(h Handler, mw ...Middleware) Handler {
+	for i := len(mw) - 1; i >= 0; i-- {
+		h = mw[i](h)
+	}
+	return h
+}
+
+// Use appends the middlewares to the chain wrapping handlers of connections,
+// including handlers of listeners, SNI and NextProtoHandlers. The first
+// middleware is the outermost. It affects connections served afterwards.
+func (srv *TCPServer) Use(mw ...Middleware) {
+	srv.middlewaresMu.Lock()
+	defer srv.middlewaresMu.Unlock()
+	middlewares := make([]Middleware, 0, len(srv.middlewares)+len(mw))
+	middlewares = append(middlewares, srv.middlewares...)
+	srv.middlewares = append(middlewares, mw...)
+}
+
+// chain returns h wrapped by the middlewares of the server.
+func (srv *TCPServer) chain(h Handler) Handler {
+	srv.middlewaresMu.RLock()
+	middlewares := srv.middlewares
+	srv.middlewaresMu.RUnlock()
+	return Chain(h, middlewares...)
+}
@@ -0,0 +1,239 @@
+// Package modbus provides Modbus TCP protocol Handler for tcpserver.
+package modbus
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+)
+
+// Maximum sizes of Modbus TCP frames.
+const (
+	MBAPHeaderSize = 7
+	MaxPDUSize     = 253
+	MaxADUSize     = MBAPHeaderSize + MaxPDUSize
+)
+
+var (
+	// ErrInvalidProtocol is returned when protocol identifier of MBAP header
+	// isn't 0.
+	ErrInvalidProtocol = errors.New("invalid protocol identifier")
+
+	// ErrInvalidLength is returned when length field of MBAP header is out of
+	// range.
+	ErrInvalidLength = errors.New("invalid length")
+)
+
+// An Exception is a Modbus exception code. HandlerFuncs can return it as
+// error to send an exception response.
+type Exception byte
+
+// Modbus exception codes.
+const (
+	IllegalFunction                    Exception = 0x01
+	IllegalDataAddress                 Exception = 0x02
+	IllegalDataValue                   Exception = 0x03
+	ServerDeviceFailure                Exception = 0x04
+	Acknowledge                        Exception = 0x05
+	ServerDeviceBusy                   Exception = 0x06
+	MemoryParityError                  Exception = 0x08
+	GatewayPathUnavailable             Exception = 0x0A
+	GatewayTargetDeviceFailedToRespond Exception = 0x0B
+)
+
+func (e Exception) Error() string {
+	return "modbus exception " + strconv.Itoa(int(e))
+}
+
+// An ADU is a Modbus TCP application data unit.
+type ADU struct {
+	TransactionID uint16
+	ProtocolID    uint16
+	UnitID        byte
+	FunctionCode  byte
+	Data          []byte
+}
+
+// ReadADU reads an ADU from r.
+func ReadADU(r io.Reader) (adu *ADU, err error) {
+	var hdr [MBAPHeaderSize + 1]byte
+	if _, err = io.ReadFull(r, hdr[:]); err != nil {
+		return
+	}
+	length := int(binary.BigEndian.Uint16(hdr[4:6]))
+	if length < 2 || length > MaxPDUSize+1 {
+		err = ErrInvalidLength
+		return
+	}
+	adu = &ADU{
+		TransactionID: binary.BigEndian.Uint16(hdr[0:2]),
+		ProtocolID:    binary.BigEndian.Uint16(hdr[2:4]),
+		UnitID:        hdr[6],
+		FunctionCode:  hdr[7],
+		Data:          make([]byte, length-2),
+	}
+	if _, err = io.ReadFull(r, adu.Data); err != nil {
+		adu = nil
+		return
+	}
+	if adu.ProtocolID != 0 {
+		err = ErrInvalidProtocol
+	}
+	return
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler.
+func (adu *ADU) MarshalBinary() ([]byte, error) {
+	if len(adu.Data) > MaxPDUSize-1 {
+		return nil, ErrInvalidLength
+	}
+	buf := make([]byte, MBAPHeaderSize+1+len(adu.Data))
+	binary.BigEndian.PutUint16(buf[0:2], adu.TransactionID)
+	binary.BigEndian.PutUint16(buf[2:4], adu.ProtocolID)
+	binary.BigEndian.PutUint16(buf[4:6], uint16(len(adu.Data)+2))
+	buf[6] = adu.UnitID
+	buf[7] = adu.FunctionCode
+	copy(buf[8:], adu.Data)
+	return buf, nil
+}
+
+// WriteADU writes an ADU to w.
+func WriteADU(w io.Writer, adu *ADU) error {
+	buf, err := adu.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	nn, err := w.Write(buf)
+	if err != nil {
+		return err
+	}
+	if nn < len(buf) {
+		return io.ErrShortWrite
+	}
+	return nil
+}
+
+// A HandlerFunc handles a request of a function code. It returns data of
+// response PDU. If it returns an Exception, exception response is sent with
+// it; other errors are sent as ServerDeviceFailure.
+type HandlerFunc func(ctx *Context, req *ADU) (data []byte, err error)
+
+// Server defines parameters for Handler of Modbus TCP protocol.
+type Server struct {
+	// Accept callback. It will be called before reading requests.
+	OnAccept func(ctx *Context)
+
+	// Quit callback. It will be called before closing.
+	OnQuit func(ctx *Context)
+
+	// User data to use free.
+	UserData interface{}
+
+	handlersMu sync.RWMutex
+	handlers   map[byte]HandlerFunc
+}
+
+// Handle registers the handler for the function code.
+func (srv *Server) Handle(functionCode byte, handler HandlerFunc) {
+	srv.handlersMu.Lock()
+	if srv.handlers == nil {
+		srv.handlers = make(map[byte]HandlerFunc)
+	}
+	srv.handlers[functionCode] = handler
+	srv.handlersMu.Unlock()
+}
+
+func (srv *Server) handler(functionCode byte) HandlerFunc {
+	srv.handlersMu.RLock()
+	defer srv.handlersMu.RUnlock()
+	return srv.handlers[functionCode]
+}
+
+// Serve implements tcpserver.Handler.Serve.
+func (srv *Server) Serve(conn net.Conn, closeCh <-chan struct{}) {
+	ctx := &Context{
+		Srv:      srv,
+		Conn:     conn,
+		closeCh:  closeCh,
+		closeCh2: make(chan struct{}, 1),
+		rd:       bufio.NewReader(conn),
+	}
+	ctx.serve()
+}
+
+// Context defines parameters for Modbus TCP protocol context.
+type Context struct {
+	// Pointer of Server struct handled by this context.
+	Srv *Server
+
+	// Connection handled by this context.
+	Conn net.Conn
+
+	// User data to use free.
+	UserData interface{}
+
+	closeCh  <-chan struct{}
+	closeCh2 chan struct{}
+	rd       *bufio.Reader
+}
+
+// Close closes context.
+func (ctx *Context) Close() {
+	select {
+	case ctx.closeCh2 <- struct{}{}:
+	default:
+	}
+}
+
+func (ctx *Context) serve() {
+	if ctx.Srv.OnAccept != nil {
+		ctx.Srv.OnAccept(ctx)
+	}
+mainloop:
+	for {
+		select {
+		case <-ctx.closeCh:
+			break mainloop
+		case <-ctx.closeCh2:
+			break mainloop
+		default:
+		}
+		req, err := ReadADU(ctx.rd)
+		if err != nil {
+			ctx.Close()
+			continue
+		}
+		resp := &ADU{
+			TransactionID: req.TransactionID,
+			ProtocolID:    req.ProtocolID,
+			UnitID:        req.UnitID,
+			FunctionCode:  req.FunctionCode,
+		}
+		var data []byte
+		if h := ctx.Srv.handler(req.FunctionCode); h != nil {
+			data, err = h(ctx, req)
+		} else {
+			err = IllegalFunction
+		}
+		if err != nil {
+			exc, ok := err.(Exception)
+			if !ok {
+				exc = ServerDeviceFailure
+			}
+			resp.FunctionCode |= 0x80
+			data = []byte{byte(exc)}
+		}
+		resp.Data = data
+		if err := WriteADU(ctx.Conn, resp); err != nil {
+			ctx.Close()
+			continue
+		}
+	}
+	if ctx.Srv.OnQuit != nil {
+		ctx.Srv.OnQuit(ctx)
+	}
+}
@@ -0,0 +1,68 @@
+package tcpserver
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+)
+
+// ClientAuthPolicy defines parameters of TLS client authentication.
+type ClientAuthPolicy struct {
+	// Type of client authentication.
+	Type tls.ClientAuthType
+
+	// CAs specifies root certificate authorities to verify client
+	// certificates. If it is nil, system roots are used.
+	CAs *x509.CertPool
+
+	// Authorize optionally authorizes the verified client certificate chains.
+	// If it returns an error, the handshake fails.
+	Authorize func(verifiedChains [][]*x509.Certificate) error
+}
+
+// apply applies the policy to the TLS configuration.
+func (p *ClientAuthPolicy) apply(config *tls.Config) {
+	config.ClientAuth = p.Type
+	if p.CAs != nil {
+		config.ClientCAs = p.CAs
+	}
+	if p.Authorize == nil {
+		return
+	}
+	verifyConnection := config.VerifyConnection
+	config.VerifyConnection = func(cs tls.ConnectionState) error {
+		if verifyConnection != nil {
+			if err := verifyConnection(cs); err != nil {
+				return err
+			}
+		}
+		return p.Authorize(cs.VerifiedChains)
+	}
+}
+
+// PeerCertificate returns the leaf certificate of the peer of the TLS
+// connection, or nil if there isn't any. The connection can be wrapped. The
+// certificate is verified only if client authentication requires
+// verification.
+func PeerCertificate(conn net.Conn) *x509.Certificate {
+	tc := findTLSConn(conn)
+	if tc == nil {
+		return nil
+	}
+	certs := tc.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return nil
+	}
+	return certs[0]
+}
+
+// VerifiedChains returns the verified certificate chains of the peer of the
+// TLS connection, or nil if the peer isn't verified. The connection can be
+// wrapped.
+func VerifiedChains(conn net.Conn) [][]*x509.Certificate {
+	tc := findTLSConn(conn)
+	if tc == nil {
+		return nil
+	}
+	return tc.ConnectionState().VerifiedChains
+}
@@ -0,0 +1,115 @@
+// Package mux provides stream multiplexing layer for tcpserver based on
+// yamux. Many logical streams are carried over a single connection, and each
+// stream is served by a tcpserver.Handler.
+package mux
+
+import (
+	"io/ioutil"
+	"log"
+	"net"
+	"sync"
+
+	"github.com/hashicorp/yamux"
+	"github.com/orkunkaraduman/go-tcpserver"
+)
+
+// Server defines parameters for stream multiplexing layer as a
+// tcpserver.Handler. It runs a yamux server session on the connection, and
+// invokes Handler for each stream opened by client with *yamux.Stream.
+//
+// When the connection is closing, Server sends go away to client, fills
+// closeCh of stream handlers, and closes the session after all stream
+// handlers return. Serve returns after all stream handlers return, even if
+// the session is closed by client.
+type Server struct {
+	// Handler to invoke for each stream.
+	Handler tcpserver.Handler
+
+	// Config of yamux session. If it is nil, yamux.DefaultConfig() is used.
+	Config *yamux.Config
+
+	// ErrorLog specifies an optional logger for errors in Handler.
+	ErrorLog *log.Logger
+
+	// Session callback. It will be called after session created, to open
+	// streams from server side for example.
+	OnSession func(session *yamux.Session)
+}
+
+// Serve implements tcpserver.Handler.Serve.
+func (srv *Server) Serve(conn net.Conn, closeCh <-chan struct{}) {
+	session, err := yamux.Server(conn, srv.Config)
+	if err != nil {
+		return
+	}
+	defer session.Close()
+	errorLog := srv.ErrorLog
+	if errorLog == nil {
+		errorLog = log.New(ioutil.Discard, "", log.LstdFlags)
+	}
+	if srv.OnSession != nil {
+		srv.OnSession(session)
+	}
+
+	streams := make(map[*yamux.Stream]chan struct{})
+	var streamsMu sync.Mutex
+	closing := false
+	// handlers are added under streamsMu unless closing.
+	var handlersWg sync.WaitGroup
+	defer handlersWg.Wait()
+
+	go func() {
+		select {
+		case <-closeCh:
+		case <-session.CloseChan():
+			return
+		}
+		session.GoAway()
+		streamsMu.Lock()
+		closing = true
+		for _, ch := range streams {
+			select {
+			case ch <- struct{}{}:
+			default:
+			}
+		}
+		streamsMu.Unlock()
+		handlersWg.Wait()
+		session.Close()
+	}()
+
+	for {
+		stream, err := session.AcceptStream()
+		if err != nil {
+			break
+		}
+		streamCloseCh := make(chan struct{}, 1)
+		streamsMu.Lock()
+		if closing {
+			streamsMu.Unlock()
+			stream.Close()
+			continue
+		}
+		streams[stream] = streamCloseCh
+		handlersWg.Add(1)
+		streamsMu.Unlock()
+		go func() {
+			defer handlersWg.Done()
+			defer func() {
+				stream.Close()
+				streamsMu.Lock()
+				delete(streams, stream)
+				streamsMu.Unlock()
+			}()
+			defer func() {
+				e := recover()
+				if e != nil {
+					errorLog.Print(e)
+				}
+			}()
+			if srv.Handler != nil {
+				srv.Handler.Serve(stream, streamCloseCh)
+			}
+		}()
+	}
+}
@@ -0,0 +1,221 @@
+// Package noise provides Noise protocol transport layer for tcpserver.
+package noise
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	flynnnoise "github.com/flynn/noise"
+	"github.com/orkunkaraduman/go-tcpserver"
+)
+
+// DefHandshakeTimeout specifies Noise handshake timeout if
+// Server.HandshakeTimeout is 0.
+var DefHandshakeTimeout = 10 * time.Second
+
+// DefCipherSuite is the cipher suite if Server.CipherSuite is nil.
+var DefCipherSuite = flynnnoise.NewCipherSuite(flynnnoise.DH25519, flynnnoise.CipherChaChaPoly, flynnnoise.HashBLAKE2s)
+
+// maxPayloadSize is maximum plaintext size of a transport message.
+const maxPayloadSize = flynnnoise.MaxMsgLen - 16
+
+var (
+	// ErrMessageTooLarge is returned when a message exceeds Noise message size
+	// limit.
+	ErrMessageTooLarge = errors.New("noise message too large")
+)
+
+// Server defines parameters for Noise transport layer as a
+// tcpserver.Handler. It runs Noise handshake as the responder, wraps the
+// connection with *Conn and invokes Handler with it.
+//
+// Messages are framed with 2-byte big-endian length prefix.
+type Server struct {
+	// Handler to invoke.
+	Handler tcpserver.Handler
+
+	// Pattern of handshake. Patterns XX and IK are supported. If it is
+	// zero, HandshakeXX is used.
+	Pattern flynnnoise.HandshakePattern
+
+	// CipherSuite of handshake. If it is nil, DefCipherSuite is used.
+	CipherSuite flynnnoise.CipherSuite
+
+	// StaticKey is static keypair of the server.
+	StaticKey flynnnoise.DHKey
+
+	// Prologue optionally specifies data that must be identical on both
+	// sides.
+	Prologue []byte
+
+	// HandshakeTimeout specifies maximum duration of handshake.
+	HandshakeTimeout time.Duration
+
+	// VerifyPeer optionally verifies static public key of the client after
+	// handshake. The connection is closed if it returns an error.
+	VerifyPeer func(peerStatic []byte) error
+}
+
+// Serve implements tcpserver.Handler.Serve.
+func (srv *Server) Serve(conn net.Conn, closeCh <-chan struct{}) {
+	timeout := srv.HandshakeTimeout
+	if timeout <= 0 {
+		timeout = DefHandshakeTimeout
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+	nc, err := srv.handshake(conn)
+	if err != nil {
+		return
+	}
+	conn.SetDeadline(time.Time{})
+	if srv.VerifyPeer != nil {
+		if err := srv.VerifyPeer(nc.peerStatic); err != nil {
+			return
+		}
+	}
+	if srv.Handler != nil {
+		srv.Handler.Serve(nc, closeCh)
+	}
+}
+
+func (srv *Server) handshake(conn net.Conn) (nc *Conn, err error) {
+	pattern := srv.Pattern
+	if pattern.Name == "" {
+		pattern = flynnnoise.HandshakeXX
+	}
+	cipherSuite := srv.CipherSuite
+	if cipherSuite == nil {
+		cipherSuite = DefCipherSuite
+	}
+	hs, err := flynnnoise.NewHandshakeState(flynnnoise.Config{
+		CipherSuite:   cipherSuite,
+		Pattern:       pattern,
+		Initiator:     false,
+		Prologue:      srv.Prologue,
+		StaticKeypair: srv.StaticKey,
+	})
+	if err != nil {
+		return
+	}
+	var recv, send *flynnnoise.CipherState
+	for read := true; recv == nil; read = !read {
+		if read {
+			var msg []byte
+			if msg, err = readFrame(conn, nil); err != nil {
+				return
+			}
+			if _, recv, send, err = hs.ReadMessage(nil, msg); err != nil {
+				return
+			}
+		} else {
+			var msg []byte
+			if msg, recv, send, err = hs.WriteMessage(nil, nil); err != nil {
+				return
+			}
+			if err = writeFrame(conn, msg); err != nil {
+				return
+			}
+		}
+	}
+	nc = &Conn{
+		Conn:       conn,
+		recv:       recv,
+		send:       send,
+		peerStatic: hs.PeerStatic(),
+	}
+	return
+}
+
+func readFrame(r io.Reader, buf []byte) ([]byte, error) {
+	var hdr [2]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, err
+	}
+	size := int(binary.BigEndian.Uint16(hdr[:]))
+	if cap(buf) < size {
+		buf = make([]byte, size)
+	}
+	buf = buf[:size]
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+func writeFrame(w io.Writer, msg []byte) error {
+	if len(msg) > flynnnoise.MaxMsgLen {
+		return ErrMessageTooLarge
+	}
+	buf := make([]byte, 2+len(msg))
+	binary.BigEndian.PutUint16(buf, uint16(len(msg)))
+	copy(buf[2:], msg)
+	_, err := w.Write(buf)
+	return err
+}
+
+// A Conn is a net.Conn encrypted by Noise transport messages.
+type Conn struct {
+	net.Conn
+
+	recv       *flynnnoise.CipherState
+	send       *flynnnoise.CipherState
+	peerStatic []byte
+
+	rdMu  sync.Mutex
+	rdBuf []byte
+	rdMsg []byte
+	plain []byte
+	wrMu  sync.Mutex
+	wrBuf []byte
+}
+
+// PeerStatic returns static public key of the client.
+func (c *Conn) PeerStatic() []byte {
+	return c.peerStatic
+}
+
+// Read reads decrypted data from the connection.
+func (c *Conn) Read(b []byte) (n int, err error) {
+	c.rdMu.Lock()
+	defer c.rdMu.Unlock()
+	for len(c.plain) == 0 {
+		c.rdMsg, err = readFrame(c.Conn, c.rdMsg)
+		if err != nil {
+			return
+		}
+		c.plain, err = c.recv.Decrypt(c.rdBuf[:0], nil, c.rdMsg)
+		if err != nil {
+			return
+		}
+		c.rdBuf = c.plain
+	}
+	n = copy(b, c.plain)
+	c.plain = c.plain[n:]
+	return
+}
+
+// Write writes encrypted data to the connection.
+func (c *Conn) Write(b []byte) (n int, err error) {
+	c.wrMu.Lock()
+	defer c.wrMu.Unlock()
+	for len(b) > 0 {
+		chunk := b
+		if len(chunk) > maxPayloadSize {
+			chunk = chunk[:maxPayloadSize]
+		}
+		c.wrBuf, err = c.send.Encrypt(c.wrBuf[:0], nil, chunk)
+		if err != nil {
+			return
+		}
+		if err = writeFrame(c.Conn, c.wrBuf); err != nil {
+			return
+		}
+		n += len(chunk)
+		b = b[len(chunk):]
+	}
+	return
+}
@@ -0,0 +1,184 @@
+package tcpserver
+
+import (
+	"crypto/tls"
+	"time"
+)
+
+// A ConfigError describes an invalid configuration of TCPServer.
+type ConfigError struct {
+	Field  string
+	Reason string
+}
+
+func (e *ConfigError) Error() string {
+	return "invalid config: " + e.Field + ": " + e.Reason
+}
+
+// An Option configures a TCPServer created by New.
+type Option func(srv *TCPServer) error
+
+// New returns a new TCPServer listening on addr and serving connections by h,
+// configured by the options. It returns an error if any option or the
+// resulting configuration is invalid. Servers with TLS should be served by
+// ListenAndServeTLS with empty file names.
+func New(addr string, h Handler, opts ...Option) (*TCPServer, error) {
+	srv := &TCPServer{
+		Addr:    addr,
+		Handler: h,
+	}
+	for _, opt := range opts {
+		if err := opt(srv); err != nil {
+			return nil, err
+		}
+	}
+	if err := srv.Validate(); err != nil {
+		return nil, err
+	}
+	return srv, nil
+}
+
+// Validate checks the configuration of the server for invalid values and
+// combinations of fields.
+func (srv *TCPServer) Validate() error {
+	if srv.Handler == nil && srv.handlerPtr.Load() == nil {
+		return &ConfigError{Field: "Handler", Reason: "is nil"}
+	}
+	durations := []struct {
+		field string
+		d     time.Duration
+	}{
+		{"TLSHandshakeTimeout", srv.TLSHandshakeTimeout},
+		{"GreetingTimeout", srv.GreetingTimeout},
+		{"DrainTimeout", srv.DrainTimeout},
+		{"IdleTimeout", srv.IdleTimeout},
+		{"ReadTimeout", srv.ReadTimeout},
+		{"WriteTimeout", srv.WriteTimeout},
+		{"FirstByteTimeout", srv.FirstByteTimeout},
+		{"MaxConnsPerIPWait", srv.MaxConnsPerIPWait},
+		{"ProxyHeaderTimeout", srv.ProxyHeaderTimeout},
+	}
+	for _, d := range durations {
+		if d.d < 0 {
+			return &ConfigError{Field: d.field, Reason: "is negative"}
+		}
+	}
+	if srv.MaxConns < 0 && srv.MaxConns != AutoMaxConns {
+		return &ConfigError{Field: "MaxConns", Reason: "is negative"}
+	}
+	if srv.MaxConnsPerIP < 0 {
+		return &ConfigError{Field: "MaxConnsPerIP", Reason: "is negative"}
+	}
+	if srv.MaxConns > 0 && srv.MaxConnsPerIP > srv.MaxConns {
+		return &ConfigError{Field: "MaxConnsPerIP", Reason: "exceeds MaxConns"}
+	}
+	if srv.MaxConnsPerIPWait > 0 && srv.MaxConnsPerIP == 0 {
+		return &ConfigError{Field: "MaxConnsPerIPWait", Reason: "needs MaxConnsPerIP"}
+	}
+	if srv.ProxyProtocolStrict && !srv.ProxyProtocol {
+		return &ConfigError{Field: "ProxyProtocolStrict", Reason: "needs ProxyProtocol"}
+	}
+	if len(srv.OverflowResponse) > 0 && srv.OverflowPolicy != OverflowReject {
+		return &ConfigError{Field: "OverflowResponse", Reason: "needs OverflowReject policy"}
+	}
+	if srv.AcceptBurst > 0 && srv.MaxAcceptsPerSecond <= 0 {
+		return &ConfigError{Field: "AcceptBurst", Reason: "needs MaxAcceptsPerSecond"}
+	}
+	return nil
+}
+
+// WithTLS sets TLSConfig.
+func WithTLS(config *tls.Config) Option {
+	return func(srv *TCPServer) error {
+		if config == nil {
+			return &ConfigError{Field: "TLSConfig", Reason: "is nil"}
+		}
+		if len(config.Certificates) == 0 && config.GetCertificate == nil && config.GetConfigForClient == nil {
+			return &ConfigError{Field: "TLSConfig", Reason: "has no certificate"}
+		}
+		srv.TLSConfig = config
+		return nil
+	}
+}
+
+// WithTLSFiles sets TLSConfig to DefaultTLSConfig with the certificate of the
+// files. The files are loaded by New.
+func WithTLSFiles(certFile, keyFile string) Option {
+	return func(srv *TCPServer) error {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return err
+		}
+		config := DefaultTLSConfig()
+		config.Certificates = []tls.Certificate{cert}
+		srv.TLSConfig = config
+		return nil
+	}
+}
+
+// WithTimeouts sets ReadTimeout, WriteTimeout and IdleTimeout.
+func WithTimeouts(read, write, idle time.Duration) Option {
+	return func(srv *TCPServer) error {
+		srv.ReadTimeout, srv.WriteTimeout, srv.IdleTimeout = read, write, idle
+		return nil
+	}
+}
+
+// WithDrainTimeout sets DrainTimeout.
+func WithDrainTimeout(d time.Duration) Option {
+	return func(srv *TCPServer) error {
+		srv.DrainTimeout = d
+		return nil
+	}
+}
+
+// WithMaxConns sets MaxConns and OverflowPolicy.
+func WithMaxConns(max int, policy OverflowPolicy) Option {
+	return func(srv *TCPServer) error {
+		srv.MaxConns, srv.OverflowPolicy = max, policy
+		return nil
+	}
+}
+
+// WithMaxConnsPerIP sets MaxConnsPerIP and MaxConnsPerIPWait.
+func WithMaxConnsPerIP(max int, wait time.Duration) Option {
+	return func(srv *TCPServer) error {
+		srv.MaxConnsPerIP, srv.MaxConnsPerIPWait = max, wait
+		return nil
+	}
+}
+
+// WithProxyProtocol enables ProxyProtocol, and sets ProxyProtocolStrict.
+func WithProxyProtocol(strict bool) Option {
+	return func(srv *TCPServer) error {
+		srv.ProxyProtocol, srv.ProxyProtocolStrict = true, strict
+		return nil
+	}
+}
+
+// WithLogger sets Logger.
+func WithLogger(l Logger) Option {
+	return func(srv *TCPServer) error {
+		if l == nil {
+			return &ConfigError{Field: "Logger", Reason: "is nil"}
+		}
+		srv.Logger = l
+		return nil
+	}
+}
+
+// WithMiddleware appends the middlewares by Use.
+func WithMiddleware(mw ...Middleware) Option {
+	return func(srv *TCPServer) error {
+		srv.Use(mw...)
+		return nil
+	}
+}
+
+// WithAddrs sets Addrs.
+func WithAddrs(addrs ...string) Option {
+	return func(srv *TCPServer) error {
+		srv.Addrs = addrs
+		return nil
+	}
+}
@@ -0,0 +1,28 @@
+package tcpserver
+
+import (
+	"net"
+	"syscall"
+)
+
+func peerCredentials(uc *net.UnixConn) (pc *PeerCred, err error) {
+	rc, err := uc.SyscallConn()
+	if err != nil {
+		return
+	}
+	var ucred *syscall.Ucred
+	e := rc.Control(func(fd uintptr) {
+		ucred, err = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if e != nil {
+		return nil, e
+	}
+	if err != nil {
+		return
+	}
+	return &PeerCred{
+		PID: ucred.Pid,
+		UID: ucred.Uid,
+		GID: ucred.Gid,
+	}, nil
+}
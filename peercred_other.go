@@ -0,0 +1,9 @@
+//go:build !linux
+
+package tcpserver
+
+import "net"
+
+func peerCredentials(uc *net.UnixConn) (*PeerCred, error) {
+	return nil, ErrPeerCredNotSupported
+}
@@ -0,0 +1,104 @@
+package tcpserver
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+// Request codes of PostgreSQL startup packets.
+const (
+	PostgresProtocolVersion3     = 196608
+	PostgresSSLRequestCode       = 80877103
+	PostgresGSSENCRequestCode    = 80877104
+	PostgresCancelRequestCode    = 80877102
+	postgresMaxStartupPacketSize = 10000
+)
+
+var (
+	// ErrNotPostgresStartup is returned by PeekPostgresStartup when first
+	// bytes aren't a PostgreSQL startup packet.
+	ErrNotPostgresStartup = errors.New("not a postgres startup packet")
+)
+
+// PostgresStartup defines parameters of PostgreSQL StartupMessage,
+// SSLRequest, GSSENCRequest or CancelRequest packets.
+type PostgresStartup struct {
+	// Code is protocol version of StartupMessage or request code of others.
+	Code uint32
+
+	// Parameters of StartupMessage like user, database and application_name.
+	Parameters map[string]string
+}
+
+// SSLRequest reports whether the packet is an SSLRequest.
+func (ps *PostgresStartup) SSLRequest() bool {
+	return ps.Code == PostgresSSLRequestCode
+}
+
+// User returns requested user of StartupMessage.
+func (ps *PostgresStartup) User() string {
+	return ps.Parameters["user"]
+}
+
+// Database returns requested database of StartupMessage. It defaults to user.
+func (ps *PostgresStartup) Database() string {
+	if db, ok := ps.Parameters["database"]; ok {
+		return db
+	}
+	return ps.User()
+}
+
+// PeekPostgresStartup parses a PostgreSQL startup packet from r without
+// consuming it.
+func PeekPostgresStartup(r *bufio.Reader) (ps *PostgresStartup, err error) {
+	hdr, err := r.Peek(8)
+	if err != nil {
+		return
+	}
+	length := binary.BigEndian.Uint32(hdr[0:4])
+	code := binary.BigEndian.Uint32(hdr[4:8])
+	ps = &PostgresStartup{Code: code}
+	switch code {
+	case PostgresSSLRequestCode, PostgresGSSENCRequestCode:
+		if length != 8 {
+			return nil, ErrNotPostgresStartup
+		}
+		return
+	case PostgresCancelRequestCode:
+		if length != 16 {
+			return nil, ErrNotPostgresStartup
+		}
+		return
+	}
+	if code>>16 != 3 || length < 9 || length > postgresMaxStartupPacketSize {
+		return nil, ErrNotPostgresStartup
+	}
+	buf, err := r.Peek(int(length))
+	if err != nil {
+		return nil, err
+	}
+	buf = buf[8:]
+	if buf[len(buf)-1] != 0 {
+		return nil, ErrNotPostgresStartup
+	}
+	fields := bytes.Split(buf[:len(buf)-1], []byte{0})
+	if len(fields)%2 != 1 || len(fields[len(fields)-1]) != 0 {
+		return nil, ErrNotPostgresStartup
+	}
+	ps.Parameters = make(map[string]string, len(fields)/2)
+	for i := 0; i+1 < len(fields); i += 2 {
+		ps.Parameters[string(fields[i])] = string(fields[i+1])
+	}
+	return
+}
+
+// MatchPostgres matches PostgreSQL connections starting with a
+// StartupMessage, SSLRequest, GSSENCRequest or CancelRequest. Handlers can
+// call PeekPostgresStartup with RouterConn.Reader to get requested database
+// and user.
+func MatchPostgres(r *bufio.Reader) bool {
+	_, err := PeekPostgresStartup(r)
+	return err == nil
+}
@@ -0,0 +1,20 @@
+package tcpserver
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"runtime/pprof"
+)
+
+// pprofLabels returns profiler labels of the connection served by handler.
+func pprofLabels(conn net.Conn, handler Handler) pprof.LabelSet {
+	labels := []string{
+		"remote_addr", conn.RemoteAddr().String(),
+		"handler", fmt.Sprintf("%T", handler),
+	}
+	if tc, ok := conn.(*tls.Conn); ok {
+		labels = append(labels, "sni", tc.ConnectionState().ServerName)
+	}
+	return pprof.Labels(labels...)
+}
@@ -0,0 +1,309 @@
+package tcpserver
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"runtime"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// preforkEnv is set in environment of worker processes.
+const preforkEnv = "TCPSERVER_PREFORK_WORKER"
+
+// preforkListenerFd is the file descriptor of inherited listener in workers.
+const preforkListenerFd = 3
+
+// DefPreforkRestartDelay specifies delay before restarting an exited worker if
+// Prefork.RestartDelay is 0.
+var DefPreforkRestartDelay = 1 * time.Second
+
+var (
+	// ErrPreforkClosed is returned by Prefork.ListenAndServe when Close or
+	// Shutdown method called before serving started.
+	ErrPreforkClosed = errors.New("prefork closed")
+)
+
+// A Prefork defines parameters for running a TCPServer in multi-process mode.
+//
+// The master process binds Server.Addr with SO_REUSEPORT, forks Workers
+// copies of the running executable with the same arguments, and supervises
+// them by restarting the workers that exit. Each worker serves connections
+// of the inherited listener with Server in its own Go runtime.
+//
+// The same program runs as master and workers, so ListenAndServe must be
+// called by both. Use IsPreforkWorker to distinguish them if necessary.
+type Prefork struct {
+	// Server to run in worker processes.
+	Server *TCPServer
+
+	// Workers specifies number of worker processes. If it is 0,
+	// runtime.NumCPU() is used.
+	Workers int
+
+	// RestartDelay specifies delay before restarting an exited worker.
+	RestartDelay time.Duration
+
+	// ErrorLog specifies an optional logger for errors of master process.
+	ErrorLog *log.Logger
+
+	mu          sync.Mutex
+	procs       map[*os.Process]struct{}
+	procsDoneCh chan struct{}
+	closeCh     chan struct{}
+	closed      bool
+}
+
+// IsPreforkWorker reports whether the running process is a prefork worker.
+func IsPreforkWorker() bool {
+	return os.Getenv(preforkEnv) != ""
+}
+
+// ListenAndServe runs master process or a worker process depending on the
+// running process. In master process, it returns a nil error after Close or
+// Shutdown method called. In a worker process, it returns after the worker
+// shuts down.
+func (pf *Prefork) ListenAndServe() error {
+	if IsPreforkWorker() {
+		return pf.serveWorker()
+	}
+	return pf.serveMaster()
+}
+
+// Shutdown gracefully shuts down the workers by sending them SIGTERM, and
+// then waiting indefinitely for the workers to exit. Workers shut down their
+// Server gracefully. If the provided context expires before the shutdown is
+// complete, Shutdown kills the remaining workers and returns the context's
+// error.
+func (pf *Prefork) Shutdown(ctx context.Context) (err error) {
+	pf.close()
+
+	pf.mu.Lock()
+	for p := range pf.procs {
+		p.Signal(syscall.SIGTERM)
+	}
+	pf.mu.Unlock()
+
+	select {
+	case <-pf.procsDone():
+	case <-ctx.Done():
+		pf.mu.Lock()
+		for p := range pf.procs {
+			p.Kill()
+		}
+		pf.mu.Unlock()
+		err = ctx.Err()
+	}
+	return
+}
+
+// procsDone returns a channel closed when there is no running worker.
+func (pf *Prefork) procsDone() <-chan struct{} {
+	pf.mu.Lock()
+	defer pf.mu.Unlock()
+	if len(pf.procs) == 0 {
+		ch := make(chan struct{})
+		close(ch)
+		return ch
+	}
+	if pf.procsDoneCh == nil {
+		pf.procsDoneCh = make(chan struct{})
+	}
+	return pf.procsDoneCh
+}
+
+// Close immediately kills all workers. For a graceful shutdown, use Shutdown.
+func (pf *Prefork) Close() (err error) {
+	pf.close()
+
+	pf.mu.Lock()
+	for p := range pf.procs {
+		p.Kill()
+	}
+	pf.mu.Unlock()
+
+	return
+}
+
+func (pf *Prefork) init() {
+	if pf.closeCh == nil {
+		pf.procs = make(map[*os.Process]struct{})
+		pf.closeCh = make(chan struct{})
+	}
+}
+
+func (pf *Prefork) close() {
+	pf.mu.Lock()
+	pf.init()
+	if !pf.closed {
+		pf.closed = true
+		close(pf.closeCh)
+	}
+	pf.mu.Unlock()
+}
+
+func (pf *Prefork) serveMaster() (err error) {
+	pf.mu.Lock()
+	pf.init()
+	closed := pf.closed
+	pf.mu.Unlock()
+	if closed {
+		return ErrPreforkClosed
+	}
+
+	errorLog := pf.ErrorLog
+	if errorLog == nil {
+		errorLog = log.New(ioutil.Discard, "", log.LstdFlags)
+	}
+	restartDelay := pf.RestartDelay
+	if restartDelay <= 0 {
+		restartDelay = DefPreforkRestartDelay
+	}
+	workers := pf.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	lc := &net.ListenConfig{Control: reusePortControl}
+	l, err := lc.Listen(context.Background(), "tcp", pf.Server.Addr)
+	if err != nil {
+		return
+	}
+	defer l.Close()
+	f, err := l.(*net.TCPListener).File()
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	exe, err := os.Executable()
+	if err != nil {
+		return
+	}
+
+	exitCh := make(chan *exec.Cmd, workers)
+	start := func() error {
+		cmd := exec.Command(exe, os.Args[1:]...)
+		cmd.Stdin = os.Stdin
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		cmd.Env = append(os.Environ(), preforkEnv+"=1")
+		cmd.ExtraFiles = []*os.File{f}
+		pf.mu.Lock()
+		defer pf.mu.Unlock()
+		if pf.closed {
+			return nil
+		}
+		if e := cmd.Start(); e != nil {
+			return e
+		}
+		pf.procs[cmd.Process] = struct{}{}
+		go func() {
+			cmd.Wait()
+			exitCh <- cmd
+		}()
+		return nil
+	}
+
+	running := 0
+	for i := 0; i < workers; i++ {
+		if err = start(); err != nil {
+			pf.Close()
+			break
+		}
+		running++
+	}
+
+	restartCh := make(chan struct{}, workers)
+	for running > 0 {
+		select {
+		case cmd := <-exitCh:
+			running--
+			pf.mu.Lock()
+			delete(pf.procs, cmd.Process)
+			if len(pf.procs) == 0 && pf.procsDoneCh != nil {
+				close(pf.procsDoneCh)
+				pf.procsDoneCh = nil
+			}
+			closed := pf.closed
+			pf.mu.Unlock()
+			if closed {
+				continue
+			}
+			errorLog.Printf("prefork: worker %d exited: %v", cmd.Process.Pid, cmd.ProcessState)
+			running++
+			time.AfterFunc(restartDelay, func() {
+				restartCh <- struct{}{}
+			})
+		case <-restartCh:
+			if e := start(); e != nil {
+				errorLog.Printf("prefork: unable to restart worker: %v", e)
+				time.AfterFunc(restartDelay, func() {
+					restartCh <- struct{}{}
+				})
+				continue
+			}
+			pf.mu.Lock()
+			closed := pf.closed
+			pf.mu.Unlock()
+			if closed {
+				running--
+			}
+		}
+	}
+	return
+}
+
+func (pf *Prefork) serveWorker() error {
+	f := os.NewFile(preforkListenerFd, "prefork-listener")
+	l, err := net.FileListener(f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+
+	doneCh := make(chan struct{})
+	defer close(doneCh)
+	// shuttingDownCh is closed before shutting down Server, and shutdownCh
+	// is closed after Shutdown returns.
+	shuttingDownCh := make(chan struct{})
+	shutdownCh := make(chan struct{})
+	go func() {
+		sigCh := make(chan os.Signal, 1)
+		signal.Notify(sigCh, syscall.SIGTERM)
+		defer signal.Stop(sigCh)
+		ppid := os.Getppid()
+		for {
+			select {
+			case <-doneCh:
+				return
+			case <-sigCh:
+			case <-time.After(1 * time.Second):
+				// master process has gone.
+				if os.Getppid() == ppid {
+					continue
+				}
+			}
+			close(shuttingDownCh)
+			pf.Server.Shutdown(context.Background())
+			close(shutdownCh)
+			return
+		}
+	}()
+
+	err = pf.Server.Serve(l)
+	select {
+	case <-shuttingDownCh:
+		// connections are drained before returning.
+		<-shutdownCh
+	default:
+	}
+	return err
+}
@@ -0,0 +1,193 @@
+package proxy
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// Default values of Breaker parameters.
+var (
+	DefBreakerWindow      = 10 * time.Second
+	DefBreakerMinRequests = 5
+	DefBreakerOpenTimeout = 5 * time.Second
+)
+
+var (
+	// ErrCircuitOpen is returned when a backend is ejected by its circuit
+	// breaker.
+	ErrCircuitOpen = errors.New("circuit open")
+)
+
+// BreakerState is state of a circuit breaker.
+type BreakerState int
+
+// Circuit breaker states.
+const (
+	BreakerClosed BreakerState = iota
+	BreakerOpen
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// Breaker is a circuit breaker of a backend. Breaker counts failures in
+// Window, and opens when failure rate exceeds ErrorRate. Dials slower than
+// LatencyThreshold are counted as failures. After OpenTimeout, Breaker goes
+// half-open and lets a single probe through; it closes if the probe succeeds,
+// otherwise opens again. It is safe for concurrent use.
+type Breaker struct {
+	// ErrorRate specifies failure rate between 0 and 1 to open the circuit.
+	// If it is 0, the circuit never opens.
+	ErrorRate float64
+
+	// LatencyThreshold specifies dial duration to count as failure. If it is
+	// 0, latency isn't checked.
+	LatencyThreshold time.Duration
+
+	// Window specifies duration of counting. If it is 0, DefBreakerWindow is
+	// used.
+	Window time.Duration
+
+	// MinRequests specifies minimum count of requests in Window to open the
+	// circuit. If it is 0, DefBreakerMinRequests is used.
+	MinRequests int
+
+	// OpenTimeout specifies duration of open state before probing. If it is
+	// 0, DefBreakerOpenTimeout is used.
+	OpenTimeout time.Duration
+
+	// State change callback. It is called with lock of the breaker held, so it
+	// must not call methods of the breaker.
+	OnStateChange func(from, to BreakerState)
+
+	mu          sync.Mutex
+	state       BreakerState
+	windowStart time.Time
+	requests    int
+	failures    int
+	openedAt    time.Time
+	probing     bool
+}
+
+// State returns current state of the breaker.
+func (br *Breaker) State() BreakerState {
+	br.mu.Lock()
+	defer br.mu.Unlock()
+	br.update(time.Now())
+	return br.state
+}
+
+// Allow reports whether a request is allowed without acquiring it.
+func (br *Breaker) Allow() bool {
+	br.mu.Lock()
+	defer br.mu.Unlock()
+	br.update(time.Now())
+	switch br.state {
+	case BreakerOpen:
+		return false
+	case BreakerHalfOpen:
+		return !br.probing
+	}
+	return true
+}
+
+// Acquire reports whether a request is allowed. If the breaker is half-open,
+// the request becomes the probe. Report must be called after an acquired
+// request completes.
+func (br *Breaker) Acquire() bool {
+	br.mu.Lock()
+	defer br.mu.Unlock()
+	br.update(time.Now())
+	switch br.state {
+	case BreakerOpen:
+		return false
+	case BreakerHalfOpen:
+		if br.probing {
+			return false
+		}
+		br.probing = true
+	}
+	return true
+}
+
+// Report reports result of an acquired request.
+func (br *Breaker) Report(err error, latency time.Duration) {
+	br.mu.Lock()
+	defer br.mu.Unlock()
+	now := time.Now()
+	br.update(now)
+	failed := err != nil || (br.LatencyThreshold > 0 && latency > br.LatencyThreshold)
+	if br.state == BreakerHalfOpen {
+		br.probing = false
+		if failed {
+			br.setState(BreakerOpen, now)
+		} else {
+			br.setState(BreakerClosed, now)
+		}
+		return
+	}
+	if br.state != BreakerClosed {
+		return
+	}
+	br.requests++
+	if failed {
+		br.failures++
+	}
+	minRequests := br.MinRequests
+	if minRequests <= 0 {
+		minRequests = DefBreakerMinRequests
+	}
+	if br.ErrorRate > 0 && br.requests >= minRequests &&
+		float64(br.failures)/float64(br.requests) >= br.ErrorRate {
+		br.setState(BreakerOpen, now)
+	}
+}
+
+func (br *Breaker) update(now time.Time) {
+	window := br.Window
+	if window <= 0 {
+		window = DefBreakerWindow
+	}
+	if now.Sub(br.windowStart) >= window {
+		br.windowStart = now
+		br.requests = 0
+		br.failures = 0
+	}
+	openTimeout := br.OpenTimeout
+	if openTimeout <= 0 {
+		openTimeout = DefBreakerOpenTimeout
+	}
+	if br.state == BreakerOpen && now.Sub(br.openedAt) >= openTimeout {
+		br.setState(BreakerHalfOpen, now)
+	}
+}
+
+func (br *Breaker) setState(state BreakerState, now time.Time) {
+	if br.state == state {
+		return
+	}
+	from := br.state
+	br.state = state
+	switch state {
+	case BreakerOpen:
+		br.openedAt = now
+	case BreakerClosed:
+		br.windowStart = now
+		br.requests = 0
+		br.failures = 0
+	}
+	if br.OnStateChange != nil {
+		br.OnStateChange(from, state)
+	}
+}
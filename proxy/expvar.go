@@ -0,0 +1,44 @@
+package proxy
+
+import (
+	"expvar"
+	"time"
+)
+
+// DefExpvarName specifies name of expvar variable of Pool if name is empty.
+var DefExpvarName = "proxy.backends"
+
+// expvarHealth is the JSON form of BackendHealth published via expvar.
+type expvarHealth struct {
+	Healthy   bool      `json:"healthy"`
+	Checks    uint64    `json:"checks"`
+	Failures  uint64    `json:"failures"`
+	LastCheck time.Time `json:"last_check"`
+	LastError string    `json:"last_error,omitempty"`
+}
+
+// PublishExpvar publishes health states of backends of the pool by address
+// via expvar as name. If name is empty, DefExpvarName is used. Like
+// expvar.Publish, it panics if the name is already registered.
+func (p *Pool) PublishExpvar(name string) {
+	if name == "" {
+		name = DefExpvarName
+	}
+	expvar.Publish(name, expvar.Func(func() interface{} {
+		health := p.Health()
+		result := make(map[string]expvarHealth, len(health))
+		for addr, h := range health {
+			eh := expvarHealth{
+				Healthy:   h.Healthy,
+				Checks:    h.Checks,
+				Failures:  h.Failures,
+				LastCheck: h.LastCheck,
+			}
+			if h.LastError != nil {
+				eh.LastError = h.LastError.Error()
+			}
+			result[addr] = eh
+		}
+		return result
+	}))
+}
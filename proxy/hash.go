@@ -0,0 +1,138 @@
+package proxy
+
+import (
+	"hash/fnv"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// DefHashReplicas specifies count of virtual nodes per backend if
+// ConsistentHash.Replicas is 0.
+var DefHashReplicas = 100
+
+// ConsistentHash is a Policy that selects backends by consistent hashing of a
+// key of connections, so the same key is assigned to the same backend while
+// the pool changes. If the backend of a key isn't available, the next backend
+// on the ring is selected.
+type ConsistentHash struct {
+	// Replicas specifies count of virtual nodes per backend. If it is 0,
+	// DefHashReplicas is used.
+	Replicas int
+
+	// KeyFunc returns hash key of the connection. If it is nil, SourceIP is
+	// used. To use an application key, peek it from the connection, for
+	// example by using tcpserver.RouterConn.
+	KeyFunc func(conn net.Conn) string
+
+	mu       sync.Mutex
+	backends []*Backend
+	ring     []hashNode
+}
+
+type hashNode struct {
+	hash    uint64
+	backend *Backend
+}
+
+// Pick implements Policy.Pick.
+func (ch *ConsistentHash) Pick(backends []*Backend, conn net.Conn) *Backend {
+	return ch.PickExcept(backends, conn, nil)
+}
+
+// PickExcept implements ExceptPolicy.PickExcept. The ring is built from all
+// backends, so keys of other backends aren't moved while failing over.
+func (ch *ConsistentHash) PickExcept(backends []*Backend, conn net.Conn, exclude []*Backend) *Backend {
+	keyFunc := ch.KeyFunc
+	if keyFunc == nil {
+		keyFunc = SourceIP
+	}
+	ring := ch.getRing(backends)
+	if len(ring) == 0 {
+		return nil
+	}
+	h := hashKey(keyFunc(conn))
+	start := sort.Search(len(ring), func(i int) bool {
+		return ring[i].hash >= h
+	})
+nodes:
+	for i := 0; i < len(ring); i++ {
+		b := ring[(start+i)%len(ring)].backend
+		for _, e := range exclude {
+			if b == e {
+				continue nodes
+			}
+		}
+		if b.Available() {
+			return b
+		}
+	}
+	return nil
+}
+
+// getRing returns the hash ring of backends, building it if backends changed.
+func (ch *ConsistentHash) getRing(backends []*Backend) []hashNode {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	if sameBackends(ch.backends, backends) {
+		return ch.ring
+	}
+	replicas := ch.Replicas
+	if replicas <= 0 {
+		replicas = DefHashReplicas
+	}
+	ring := make([]hashNode, 0, len(backends)*replicas)
+	for _, b := range backends {
+		for i := 0; i < replicas; i++ {
+			ring = append(ring, hashNode{
+				hash:    hashKey(b.Addr + "#" + strconv.Itoa(i)),
+				backend: b,
+			})
+		}
+	}
+	sort.Slice(ring, func(i, j int) bool {
+		return ring[i].hash < ring[j].hash
+	})
+	ch.backends = append([]*Backend(nil), backends...)
+	ch.ring = ring
+	return ring
+}
+
+func sameBackends(a, b []*Backend) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func hashKey(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	// fnv distributes similar keys poorly, so mix it by splitmix64 finalizer.
+	x := h.Sum64()
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// SourceIP returns IP address of remote address of the connection.
+func SourceIP(conn net.Conn) string {
+	if conn == nil {
+		return ""
+	}
+	addr := conn.RemoteAddr().String()
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
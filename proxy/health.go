@@ -0,0 +1,192 @@
+package proxy
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Default values of HealthChecker parameters.
+var (
+	DefHealthInterval = 5 * time.Second
+	DefHealthTimeout  = 2 * time.Second
+	DefHealthRise     = 2
+	DefHealthFall     = 3
+)
+
+var (
+	// ErrUnexpectedResponse is returned by health checks when response of a
+	// backend doesn't match HealthChecker.Expect.
+	ErrUnexpectedResponse = errors.New("unexpected response")
+)
+
+// BackendHealth defines health state of a backend.
+type BackendHealth struct {
+	Healthy   bool
+	Checks    uint64
+	Failures  uint64
+	LastCheck time.Time
+	LastError error
+}
+
+// HealthChecker checks backends of Pool actively. A backend becomes unhealthy
+// after Fall consecutive failed checks, and becomes healthy again after Rise
+// consecutive successful checks. Unhealthy backends aren't selected by Pool.
+type HealthChecker struct {
+	// Pool of backends to check.
+	Pool *Pool
+
+	// Interval specifies duration between checks. If it is 0,
+	// DefHealthInterval is used.
+	Interval time.Duration
+
+	// Timeout specifies timeout of a check including dial. If it is 0,
+	// DefHealthTimeout is used.
+	Timeout time.Duration
+
+	// Send optionally specifies data to write after connect.
+	Send []byte
+
+	// Expect optionally specifies data that the response must start with.
+	Expect []byte
+
+	// Rise specifies count of consecutive successful checks to become
+	// healthy. If it is 0, DefHealthRise is used.
+	Rise int
+
+	// Fall specifies count of consecutive failed checks to become unhealthy.
+	// If it is 0, DefHealthFall is used.
+	Fall int
+
+	// Health change callback.
+	OnChange func(b *Backend, healthy bool)
+
+	mu     sync.Mutex
+	stopCh chan struct{}
+	doneCh chan struct{}
+}
+
+// Start starts checking.
+func (hc *HealthChecker) Start() {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	if hc.stopCh != nil {
+		return
+	}
+	hc.stopCh = make(chan struct{})
+	hc.doneCh = make(chan struct{})
+	go hc.run(hc.stopCh, hc.doneCh)
+}
+
+// Stop stops checking. Health states of backends are kept.
+func (hc *HealthChecker) Stop() {
+	hc.mu.Lock()
+	stopCh, doneCh := hc.stopCh, hc.doneCh
+	hc.stopCh, hc.doneCh = nil, nil
+	hc.mu.Unlock()
+	if stopCh == nil {
+		return
+	}
+	close(stopCh)
+	<-doneCh
+}
+
+func (hc *HealthChecker) run(stopCh, doneCh chan struct{}) {
+	defer close(doneCh)
+	interval := hc.Interval
+	if interval <= 0 {
+		interval = DefHealthInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		hc.checkAll()
+		select {
+		case <-ticker.C:
+		case <-stopCh:
+			return
+		}
+	}
+}
+
+func (hc *HealthChecker) checkAll() {
+	var wg sync.WaitGroup
+	for _, b := range hc.Pool.Backends() {
+		wg.Add(1)
+		go func(b *Backend) {
+			defer wg.Done()
+			hc.update(b, hc.check(b))
+		}(b)
+	}
+	wg.Wait()
+}
+
+// check runs a single check of the backend.
+func (hc *HealthChecker) check(b *Backend) error {
+	timeout := hc.Timeout
+	if timeout <= 0 {
+		timeout = DefHealthTimeout
+	}
+	deadline := time.Now().Add(timeout)
+	conn, err := net.DialTimeout("tcp", b.Addr, timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(deadline)
+	if len(hc.Send) > 0 {
+		if _, err = conn.Write(hc.Send); err != nil {
+			return err
+		}
+	}
+	if len(hc.Expect) > 0 {
+		buf := make([]byte, len(hc.Expect))
+		if _, err = io.ReadFull(conn, buf); err != nil {
+			return err
+		}
+		if !bytes.Equal(buf, hc.Expect) {
+			return ErrUnexpectedResponse
+		}
+	}
+	return nil
+}
+
+func (hc *HealthChecker) update(b *Backend, err error) {
+	rise, fall := hc.Rise, hc.Fall
+	if rise <= 0 {
+		rise = DefHealthRise
+	}
+	if fall <= 0 {
+		fall = DefHealthFall
+	}
+	b.healthMu.Lock()
+	h := &b.health
+	h.Checks++
+	h.LastCheck = time.Now()
+	h.LastError = err
+	changed := false
+	if err != nil {
+		h.Failures++
+		b.rise = 0
+		b.fall++
+		if !b.unhealthy && b.fall >= fall {
+			b.unhealthy = true
+			changed = true
+		}
+	} else {
+		b.fall = 0
+		b.rise++
+		if b.unhealthy && b.rise >= rise {
+			b.unhealthy = false
+			changed = true
+		}
+	}
+	healthy := !b.unhealthy
+	b.healthMu.Unlock()
+	if changed && hc.OnChange != nil {
+		hc.OnChange(b, healthy)
+	}
+}
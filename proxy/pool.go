@@ -0,0 +1,193 @@
+package proxy
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Backend defines parameters of a backend.
+type Backend struct {
+	// TCP address of the backend.
+	Addr string
+
+	// Breaker optionally provides circuit breaker of the backend.
+	Breaker *Breaker
+
+	healthMu  sync.Mutex
+	health    BackendHealth
+	unhealthy bool
+	rise      int
+	fall      int
+}
+
+// Available reports whether the backend can be selected.
+func (b *Backend) Available() bool {
+	return b.Healthy() && (b.Breaker == nil || b.Breaker.Allow())
+}
+
+// Healthy reports whether the backend passes health checks. Backends are
+// healthy until checked by a HealthChecker.
+func (b *Backend) Healthy() bool {
+	b.healthMu.Lock()
+	defer b.healthMu.Unlock()
+	return !b.unhealthy
+}
+
+// Health returns health state of the backend.
+func (b *Backend) Health() BackendHealth {
+	b.healthMu.Lock()
+	defer b.healthMu.Unlock()
+	h := b.health
+	h.Healthy = !b.unhealthy
+	return h
+}
+
+// Dial dials the backend, and reports the result to its circuit breaker.
+func (b *Backend) Dial(timeout time.Duration) (net.Conn, error) {
+	if b.Breaker != nil && !b.Breaker.Acquire() {
+		return nil, ErrCircuitOpen
+	}
+	start := time.Now()
+	conn, err := net.DialTimeout("tcp", b.Addr, timeout)
+	if b.Breaker != nil {
+		b.Breaker.Report(err, time.Since(start))
+	}
+	return conn, err
+}
+
+// A Policy selects a backend for a connection.
+type Policy interface {
+	// Pick returns an available backend of backends for the connection, or
+	// nil if there is no available backend.
+	Pick(backends []*Backend, conn net.Conn) *Backend
+}
+
+// An ExceptPolicy is a Policy that can select a backend except some of all
+// backends by itself. Pool uses it for PickExcept instead of passing the
+// remaining backends to Pick, so the policy sees the same backends in both.
+type ExceptPolicy interface {
+	Policy
+
+	// PickExcept returns an available backend of backends which isn't in
+	// exclude for the connection, or nil if there is no such backend.
+	PickExcept(backends []*Backend, conn net.Conn, exclude []*Backend) *Backend
+}
+
+// RoundRobin is a Policy that selects available backends in turn.
+type RoundRobin struct {
+	next uint32
+}
+
+// Pick implements Policy.Pick.
+func (rr *RoundRobin) Pick(backends []*Backend, conn net.Conn) *Backend {
+	n := len(backends)
+	if n == 0 {
+		return nil
+	}
+	start := int(atomic.AddUint32(&rr.next, 1) % uint32(n))
+	for i := 0; i < n; i++ {
+		b := backends[(start+i)%n]
+		if b.Available() {
+			return b
+		}
+	}
+	return nil
+}
+
+// Pool is a set of backends. It is safe for concurrent use.
+type Pool struct {
+	// Policy of backend selection. If it is nil, round robin is used. It must
+	// be set before using the pool.
+	Policy Policy
+
+	mu       sync.RWMutex
+	backends []*Backend
+	rr       RoundRobin
+}
+
+// NewPool returns a new Pool with backends.
+func NewPool(backends ...*Backend) *Pool {
+	return &Pool{
+		backends: backends,
+	}
+}
+
+// Add adds the backend to the pool.
+func (p *Pool) Add(b *Backend) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.backends = append(p.backends, b)
+}
+
+// Remove removes the backend from the pool.
+func (p *Pool) Remove(b *Backend) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i := range p.backends {
+		if p.backends[i] == b {
+			p.backends = append(p.backends[:i:i], p.backends[i+1:]...)
+			return
+		}
+	}
+}
+
+// Backends returns all backends of the pool.
+func (p *Pool) Backends() []*Backend {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return append([]*Backend(nil), p.backends...)
+}
+
+// Health returns health states of backends by address.
+func (p *Pool) Health() map[string]BackendHealth {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	result := make(map[string]BackendHealth, len(p.backends))
+	for _, b := range p.backends {
+		result[b.Addr] = b.Health()
+	}
+	return result
+}
+
+// Pick selects an available backend for the connection by Policy. It returns
+// nil if there is no available backend.
+func (p *Pool) Pick(conn net.Conn) *Backend {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.Policy != nil {
+		return p.Policy.Pick(p.backends, conn)
+	}
+	return p.rr.Pick(p.backends, conn)
+}
+
+// PickExcept is like Pick, but it doesn't select backends in exclude. It is
+// used for selecting alternate backends.
+func (p *Pool) PickExcept(conn net.Conn, exclude []*Backend) *Backend {
+	if len(exclude) == 0 {
+		return p.Pick(conn)
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if ep, ok := p.Policy.(ExceptPolicy); ok {
+		return ep.PickExcept(p.backends, conn, exclude)
+	}
+	backends := make([]*Backend, 0, len(p.backends))
+	for _, b := range p.backends {
+		excluded := false
+		for _, e := range exclude {
+			if b == e {
+				excluded = true
+				break
+			}
+		}
+		if !excluded {
+			backends = append(backends, b)
+		}
+	}
+	if p.Policy != nil {
+		return p.Policy.Pick(backends, conn)
+	}
+	return p.rr.Pick(backends, conn)
+}
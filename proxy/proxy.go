@@ -0,0 +1,197 @@
+// Package proxy provides TCP reverse proxy handler for tcpserver. Incoming
+// connections are forwarded to backends selected from a Pool.
+package proxy
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefDialTimeout specifies dial timeout of backends if Proxy.DialTimeout is 0.
+var DefDialTimeout = 10 * time.Second
+
+// DefRetryBackoff specifies duration between dial attempts if
+// Proxy.RetryBackoff is 0.
+var DefRetryBackoff = 50 * time.Millisecond
+
+var (
+	// ErrNoBackend is returned when there is no available backend to forward
+	// a connection to.
+	ErrNoBackend = errors.New("no available backend")
+)
+
+// Proxy defines parameters for forwarding connections to backends as a
+// tcpserver.Handler.
+type Proxy struct {
+	// Pool of backends.
+	Pool *Pool
+
+	// DialTimeout specifies dial timeout of backends. If it is 0,
+	// DefDialTimeout is used.
+	DialTimeout time.Duration
+
+	// Retries specifies maximum count of dial retries per connection. Each
+	// retry selects an alternate backend if there is any.
+	Retries int
+
+	// RetryBackoff specifies duration between dial attempts. If it is 0,
+	// DefRetryBackoff is used.
+	RetryBackoff time.Duration
+
+	// DialDeadline specifies overall duration of dial attempts including
+	// retries. If it is 0, there is no overall limit.
+	DialDeadline time.Duration
+
+	// ErrorLog specifies an optional logger for errors of backends.
+	ErrorLog *log.Logger
+
+	// Error callback. It will be called before closing a connection that
+	// couldn't be forwarded, to send an error response for example.
+	OnError func(conn net.Conn, err error)
+
+	conns    uint64
+	retries  uint64
+	failures uint64
+}
+
+// Stats defines statistics of Proxy.
+type Stats struct {
+	// Conns is count of forwarded connections.
+	Conns uint64
+
+	// Retries is count of dial retries.
+	Retries uint64
+
+	// Failures is count of connections that couldn't be forwarded.
+	Failures uint64
+}
+
+// Stats returns statistics of the proxy.
+func (p *Proxy) Stats() Stats {
+	return Stats{
+		Conns:    atomic.LoadUint64(&p.conns),
+		Retries:  atomic.LoadUint64(&p.retries),
+		Failures: atomic.LoadUint64(&p.failures),
+	}
+}
+
+// Serve implements tcpserver.Handler.Serve.
+func (p *Proxy) Serve(conn net.Conn, closeCh <-chan struct{}) {
+	errorLog := p.ErrorLog
+	if errorLog == nil {
+		errorLog = log.New(ioutil.Discard, "", log.LstdFlags)
+	}
+	backendConn, err := p.dial(conn)
+	if err != nil {
+		atomic.AddUint64(&p.failures, 1)
+		errorLog.Printf("proxy: %v", err)
+		if p.OnError != nil {
+			p.OnError(conn, err)
+		}
+		return
+	}
+	defer backendConn.Close()
+	atomic.AddUint64(&p.conns, 1)
+
+	doneCh := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		io.Copy(backendConn, conn)
+		closeWrite(backendConn)
+	}()
+	go func() {
+		defer wg.Done()
+		io.Copy(conn, backendConn)
+		closeWrite(conn)
+	}()
+	go func() {
+		wg.Wait()
+		close(doneCh)
+	}()
+	select {
+	case <-doneCh:
+	case <-closeCh:
+		conn.Close()
+		backendConn.Close()
+		<-doneCh
+	}
+}
+
+// dial selects a backend for the connection and dials it. If dial fails, it
+// retries with alternate backends.
+func (p *Proxy) dial(conn net.Conn) (backendConn net.Conn, err error) {
+	if p.Pool == nil {
+		return nil, ErrNoBackend
+	}
+	timeout := p.DialTimeout
+	if timeout <= 0 {
+		timeout = DefDialTimeout
+	}
+	backoff := p.RetryBackoff
+	if backoff <= 0 {
+		backoff = DefRetryBackoff
+	}
+	var deadline time.Time
+	if p.DialDeadline > 0 {
+		deadline = time.Now().Add(p.DialDeadline)
+	}
+	var tried []*Backend
+	for attempt := 0; attempt <= p.Retries; attempt++ {
+		if attempt > 0 {
+			if !deadline.IsZero() && time.Until(deadline) <= backoff {
+				return
+			}
+			atomic.AddUint64(&p.retries, 1)
+			time.Sleep(backoff)
+		}
+		b := p.Pool.PickExcept(conn, tried)
+		if b == nil {
+			// all available backends are tried, so retry them
+			tried = tried[:0]
+			b = p.Pool.Pick(conn)
+		}
+		if b == nil {
+			if err == nil {
+				err = ErrNoBackend
+			}
+			continue
+		}
+		tried = append(tried, b)
+		t := timeout
+		if !deadline.IsZero() {
+			if remaining := time.Until(deadline); remaining < t {
+				t = remaining
+			}
+		}
+		backendConn, err = b.Dial(t)
+		if err == nil {
+			return
+		}
+	}
+	return
+}
+
+// closeWrite shuts down writing side of the connection if it or a wrapped
+// connection supports it, otherwise closes the connection.
+func closeWrite(conn net.Conn) {
+	for c := conn; ; {
+		if cw, ok := c.(interface{ CloseWrite() error }); ok {
+			cw.CloseWrite()
+			return
+		}
+		w, ok := c.(interface{ NetConn() net.Conn })
+		if !ok {
+			break
+		}
+		c = w.NetConn()
+	}
+	conn.Close()
+}
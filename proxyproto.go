@@ -0,0 +1,224 @@
+package tcpserver
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefProxyHeaderTimeout specifies read timeout of PROXY protocol headers if
+// TCPServer.ProxyHeaderTimeout is 0.
+var DefProxyHeaderTimeout = 10 * time.Second
+
+var (
+	// ErrNoProxyHeader is returned by reads of connections without a PROXY
+	// protocol header in strict mode.
+	ErrNoProxyHeader = errors.New("no PROXY protocol header")
+
+	// ErrInvalidProxyHeader is returned by reads of connections with an
+	// invalid PROXY protocol header.
+	ErrInvalidProxyHeader = errors.New("invalid PROXY protocol header")
+)
+
+// proxyV2Signature is signature of PROXY protocol v2 headers.
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// maxProxyV1HeaderSize is maximum size of PROXY protocol v1 headers.
+const maxProxyV1HeaderSize = 107
+
+// proxyListener is a net.Listener accepting connections with PROXY protocol
+// headers.
+type proxyListener struct {
+	net.Listener
+
+	strict  bool
+	timeout time.Duration
+}
+
+func (l *proxyListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &ProxyConn{
+		Conn:    conn,
+		strict:  l.strict,
+		timeout: l.timeout,
+	}, nil
+}
+
+// A ProxyConn is a net.Conn that reads PROXY protocol v1 or v2 header at
+// start. RemoteAddr and LocalAddr return the original source and destination
+// addresses in the header. The header is read on first call of Read,
+// RemoteAddr, LocalAddr or Header.
+type ProxyConn struct {
+	net.Conn
+
+	strict  bool
+	timeout time.Duration
+
+	once sync.Once
+	rd   *bufio.Reader
+	src  net.Addr
+	dst  net.Addr
+	err  error
+}
+
+// Header reads the header if it isn't read, and returns the original source
+// and destination addresses. Addresses are nil if the header is missing in
+// non-strict mode or the header is LOCAL or UNKNOWN.
+func (c *ProxyConn) Header() (src, dst net.Addr, err error) {
+	c.once.Do(c.readHeader)
+	return c.src, c.dst, c.err
+}
+
+func (c *ProxyConn) Read(b []byte) (int, error) {
+	if _, _, err := c.Header(); err != nil {
+		return 0, err
+	}
+	return c.rd.Read(b)
+}
+
+// RemoteAddr returns the original source address if any.
+func (c *ProxyConn) RemoteAddr() net.Addr {
+	if src, _, _ := c.Header(); src != nil {
+		return src
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// LocalAddr returns the original destination address if any.
+func (c *ProxyConn) LocalAddr() net.Addr {
+	if _, dst, _ := c.Header(); dst != nil {
+		return dst
+	}
+	return c.Conn.LocalAddr()
+}
+
+// NetConn returns the underlying connection.
+func (c *ProxyConn) NetConn() net.Conn {
+	return c.Conn
+}
+
+func (c *ProxyConn) readHeader() {
+	c.rd = bufio.NewReader(c.Conn)
+	timeout := c.timeout
+	if timeout <= 0 {
+		timeout = DefProxyHeaderTimeout
+	}
+	c.Conn.SetReadDeadline(time.Now().Add(timeout))
+	defer c.Conn.SetReadDeadline(time.Time{})
+	first, err := c.rd.Peek(1)
+	if err != nil {
+		c.err = err
+		return
+	}
+	switch first[0] {
+	case 'P':
+		if buf, _ := c.rd.Peek(6); string(buf) == "PROXY " {
+			c.err = c.readV1()
+			return
+		}
+	case '\r':
+		if buf, _ := c.rd.Peek(len(proxyV2Signature)); bytes.Equal(buf, proxyV2Signature) {
+			c.err = c.readV2()
+			return
+		}
+	}
+	if c.strict {
+		c.err = ErrNoProxyHeader
+	}
+}
+
+func (c *ProxyConn) readV1() error {
+	line, err := ReadBytesLimit(c.rd, '\n', maxProxyV1HeaderSize)
+	if err != nil {
+		if err == ErrBufferLimitExceeded {
+			return ErrInvalidProxyHeader
+		}
+		return err
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return ErrInvalidProxyHeader
+	}
+	fields := strings.Fields(string(line[:len(line)-2]))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return ErrInvalidProxyHeader
+	}
+	srcIP, dstIP := net.ParseIP(fields[2]), net.ParseIP(fields[3])
+	srcPort, err1 := strconv.ParseUint(fields[4], 10, 16)
+	dstPort, err2 := strconv.ParseUint(fields[5], 10, 16)
+	if srcIP == nil || dstIP == nil || err1 != nil || err2 != nil {
+		return ErrInvalidProxyHeader
+	}
+	c.src = &net.TCPAddr{IP: srcIP, Port: int(srcPort)}
+	c.dst = &net.TCPAddr{IP: dstIP, Port: int(dstPort)}
+	return nil
+}
+
+func (c *ProxyConn) readV2() error {
+	hdr := make([]byte, 16)
+	if _, err := io.ReadFull(c.rd, hdr); err != nil {
+		return err
+	}
+	verCmd, fam := hdr[12], hdr[13]
+	if verCmd>>4 != 2 {
+		return ErrInvalidProxyHeader
+	}
+	payload := make([]byte, binary.BigEndian.Uint16(hdr[14:16]))
+	if _, err := io.ReadFull(c.rd, payload); err != nil {
+		return err
+	}
+	switch verCmd & 0xf {
+	case 0x0:
+		// LOCAL command, connection is established by the proxy itself.
+		return nil
+	case 0x1:
+	default:
+		return ErrInvalidProxyHeader
+	}
+	var ipLen int
+	switch fam >> 4 {
+	case 0x1:
+		ipLen = net.IPv4len
+	case 0x2:
+		ipLen = net.IPv6len
+	default:
+		// unsupported address family is ignored as UNKNOWN.
+		return nil
+	}
+	if len(payload) < 2*ipLen+4 {
+		return ErrInvalidProxyHeader
+	}
+	srcIP := net.IP(payload[:ipLen])
+	dstIP := net.IP(payload[ipLen : 2*ipLen])
+	srcPort := binary.BigEndian.Uint16(payload[2*ipLen:])
+	dstPort := binary.BigEndian.Uint16(payload[2*ipLen+2:])
+	c.src = &net.TCPAddr{IP: srcIP, Port: int(srcPort)}
+	c.dst = &net.TCPAddr{IP: dstIP, Port: int(dstPort)}
+	return nil
+}
+
+// findProxyConn returns the *ProxyConn of wrapped connection.
+func findProxyConn(conn net.Conn) *ProxyConn {
+	for {
+		if pc, ok := conn.(*ProxyConn); ok {
+			return pc
+		}
+		w, ok := conn.(interface{ NetConn() net.Conn })
+		if !ok {
+			return nil
+		}
+		conn = w.NetConn()
+	}
+}
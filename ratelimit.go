@@ -0,0 +1,164 @@
+package tcpserver
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// tokenBucket is a token bucket rate limiter.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	if burst <= 0 {
+		burst = int(rate)
+	}
+	if burst <= 0 {
+		burst = 1
+	}
+	return &tokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+func (b *tokenBucket) advance(now time.Time) {
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+}
+
+// allow takes a token if it is available, and reports whether it is taken.
+func (b *tokenBucket) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.advance(time.Now())
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// reserve takes n tokens, and returns duration to wait before using them.
+func (b *tokenBucket) reserve(n int) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.advance(time.Now())
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// wait takes n tokens, and sleeps until they are available.
+func (b *tokenBucket) wait(n int) {
+	if d := b.reserve(n); d > 0 {
+		time.Sleep(d)
+	}
+}
+
+// acceptBucket returns the shared token bucket of MaxAcceptsPerSecond, or nil
+// if there is no limit.
+func (srv *TCPServer) acceptBucket() *tokenBucket {
+	if srv.MaxAcceptsPerSecond <= 0 {
+		return nil
+	}
+	srv.connsMu.Lock()
+	defer srv.connsMu.Unlock()
+	if srv.acceptBkt == nil {
+		burst := srv.AcceptBurst
+		if burst <= 0 {
+			burst = int(srv.MaxAcceptsPerSecond)
+		}
+		srv.acceptBkt = newTokenBucket(srv.MaxAcceptsPerSecond, burst)
+	}
+	return srv.acceptBkt
+}
+
+// DefBandwidthQuantum specifies maximum bytes of each read and write charged
+// at once by TCPServer.MaxReadBytesPerSecond and
+// TCPServer.MaxWriteBytesPerSecond. Smaller quantum shares the cap among
+// connections more fairly.
+var DefBandwidthQuantum = 16 * 1024
+
+// bandwidthBuckets returns the shared token buckets of MaxReadBytesPerSecond
+// and MaxWriteBytesPerSecond. A bucket is nil if there is no limit.
+func (srv *TCPServer) bandwidthBuckets() (rd, wr *tokenBucket) {
+	if srv.MaxReadBytesPerSecond <= 0 && srv.MaxWriteBytesPerSecond <= 0 {
+		return nil, nil
+	}
+	srv.connsMu.Lock()
+	defer srv.connsMu.Unlock()
+	if srv.rdBkt == nil && srv.MaxReadBytesPerSecond > 0 {
+		srv.rdBkt = newTokenBucket(float64(srv.MaxReadBytesPerSecond), srv.MaxReadBytesPerSecond)
+	}
+	if srv.wrBkt == nil && srv.MaxWriteBytesPerSecond > 0 {
+		srv.wrBkt = newTokenBucket(float64(srv.MaxWriteBytesPerSecond), srv.MaxWriteBytesPerSecond)
+	}
+	return srv.rdBkt, srv.wrBkt
+}
+
+// throttledConn is a net.Conn throttled by token buckets of bytes. Reads are
+// charged after reading, and writes are charged before writing.
+type throttledConn struct {
+	net.Conn
+
+	rdBuckets []*tokenBucket
+	wrBuckets []*tokenBucket
+
+	// quantum limits bytes of each read and write charged at once, if it
+	// is greater than 0.
+	quantum int
+}
+
+func (c *throttledConn) Read(b []byte) (n int, err error) {
+	if c.quantum > 0 && len(b) > c.quantum {
+		b = b[:c.quantum]
+	}
+	n, err = c.Conn.Read(b)
+	for _, bucket := range c.rdBuckets {
+		bucket.wait(n)
+	}
+	return
+}
+
+func (c *throttledConn) Write(b []byte) (n int, err error) {
+	for len(b) > 0 {
+		chunk := b
+		if c.quantum > 0 && len(chunk) > c.quantum {
+			chunk = chunk[:c.quantum]
+		}
+		for _, bucket := range c.wrBuckets {
+			if max := int(bucket.burst); len(chunk) > max {
+				chunk = chunk[:max]
+			}
+		}
+		for _, bucket := range c.wrBuckets {
+			bucket.wait(len(chunk))
+		}
+		var nn int
+		nn, err = c.Conn.Write(chunk)
+		n += nn
+		if err != nil {
+			return
+		}
+		b = b[len(chunk):]
+	}
+	return
+}
+
+func (c *throttledConn) NetConn() net.Conn {
+	return c.Conn
+}
@@ -0,0 +1,258 @@
+package tcpserver
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"time"
+)
+
+// restartEnv is set in environment of new processes to count of inherited
+// listeners.
+const restartEnv = "TCPSERVER_RESTART_LISTENERS"
+
+// restartListenerFd is the first file descriptor of inherited listeners. The
+// ready pipe follows the listeners.
+const restartListenerFd = 3
+
+// DefRestartReadyTimeout specifies timeout of waiting the new process to be
+// ready if GracefulRestart.ReadyTimeout is 0.
+var DefRestartReadyTimeout = 30 * time.Second
+
+var (
+	// ErrRestartNotReady is returned when the new process exits or times out
+	// before being ready.
+	ErrRestartNotReady = errors.New("new process not ready")
+)
+
+// A GracefulRestart defines parameters for running a TCPServer with zero
+// downtime binary restarts.
+//
+// When Signal is received, the running executable is started with the same
+// arguments and the listeners passed as file descriptors. After the new
+// process starts serving, the old process stops accepting and shuts down
+// Server gracefully, so existing connections drain and no connection is
+// dropped. The executable can be replaced before the signal to upgrade it.
+type GracefulRestart struct {
+	// Server to run.
+	Server *TCPServer
+
+	// Signal to restart. If it is nil, SIGUSR2 is used on platforms having
+	// it.
+	Signal os.Signal
+
+	// ReadyTimeout specifies maximum duration to wait the new process to
+	// start serving. If it is 0, DefRestartReadyTimeout is used.
+	ReadyTimeout time.Duration
+
+	// ShutdownTimeout specifies maximum duration of draining connections of
+	// the old process. If it is 0, connections are waited indefinitely.
+	ShutdownTimeout time.Duration
+
+	// CertFile and KeyFile optionally specify the certificate to serve TLS
+	// like ServeTLS. The listeners are served with TLS if they are set or
+	// Server.TLSConfig is set. The files are loaded by each process, so a
+	// restart reloads the certificate.
+	CertFile string
+	KeyFile  string
+
+	// ErrorLog specifies an optional logger for errors of restarting.
+	ErrorLog *log.Logger
+}
+
+// ListenAndServe listens on Server.Addr and Server.Addrs, or takes the
+// listeners inherited from the old process, and serves them with Server, with
+// TLS if it is configured. It returns ErrServerClosed after a successful
+// restart when connections are drained, or after Server is closed.
+func (gr *GracefulRestart) ListenAndServe() error {
+	ls, ready, err := gr.inherit()
+	if err != nil {
+		return err
+	}
+	if ls == nil {
+		ls, err = gr.listen()
+		if err != nil {
+			return err
+		}
+	}
+
+	sig := gr.Signal
+	if sig == nil {
+		sig = defRestartSignal
+	}
+	doneCh := make(chan struct{})
+	defer close(doneCh)
+	// restartingCh is closed before shutting down Server for a restart, and
+	// shutdownCh is closed after Shutdown returns.
+	restartingCh := make(chan struct{})
+	shutdownCh := make(chan struct{})
+	if sig != nil {
+		sigCh := make(chan os.Signal, 1)
+		signal.Notify(sigCh, sig)
+		go func() {
+			defer signal.Stop(sigCh)
+			for {
+				select {
+				case <-doneCh:
+					return
+				case <-sigCh:
+				}
+				if err := gr.restart(ls); err != nil {
+					gr.errorLog().Printf("restart: %v", err)
+					continue
+				}
+				close(restartingCh)
+				gr.shutdown()
+				close(shutdownCh)
+				return
+			}
+		}()
+	}
+
+	if ready != nil {
+		// the server starts accepting right after, connections wait in
+		// backlog meanwhile.
+		ready.Write([]byte{1})
+		ready.Close()
+	}
+	if gr.Server.TLSConfig != nil || gr.CertFile != "" || gr.KeyFile != "" {
+		err = serveAll(ls, func(l net.Listener) error {
+			return gr.Server.ServeTLS(l, gr.CertFile, gr.KeyFile)
+		})
+	} else {
+		err = gr.Server.ServeListeners(ls)
+	}
+	select {
+	case <-restartingCh:
+		// connections are drained before returning.
+		<-shutdownCh
+	default:
+	}
+	return err
+}
+
+func (gr *GracefulRestart) errorLog() *log.Logger {
+	if gr.ErrorLog == nil {
+		return log.New(ioutil.Discard, "", log.LstdFlags)
+	}
+	return gr.ErrorLog
+}
+
+func (gr *GracefulRestart) listen() ([]net.Listener, error) {
+	srv := gr.Server
+	var ls []net.Listener
+	for _, addr := range append([]string{srv.Addr}, srv.Addrs...) {
+		l, err := srv.listenAddr("tcp", addr)
+		if err != nil {
+			closeListeners(ls)
+			return nil, err
+		}
+		ls = append(ls, l...)
+	}
+	return ls, nil
+}
+
+// inherit returns listeners and the ready pipe inherited from the old process.
+// It returns nil listeners if the process isn't started by a restart.
+func (gr *GracefulRestart) inherit() (ls []net.Listener, ready *os.File, err error) {
+	s := os.Getenv(restartEnv)
+	if s == "" {
+		return nil, nil, nil
+	}
+	os.Unsetenv(restartEnv)
+	n, err := strconv.Atoi(s)
+	if err != nil || n <= 0 {
+		return nil, nil, err
+	}
+	for i := 0; i < n; i++ {
+		l, e := FileListener(uintptr(restartListenerFd + i))
+		if e != nil {
+			closeListeners(ls)
+			return nil, nil, e
+		}
+		ls = append(ls, l)
+	}
+	ready = os.NewFile(uintptr(restartListenerFd+n), "restart-ready")
+	return
+}
+
+// restart starts the new process with the listeners, and waits it to be
+// ready.
+func (gr *GracefulRestart) restart(ls []net.Listener) error {
+	files := make([]*os.File, 0, len(ls)+1)
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	for _, l := range ls {
+		fl, ok := l.(interface{ File() (*os.File, error) })
+		if !ok {
+			return ErrNoFD
+		}
+		f, err := fl.File()
+		if err != nil {
+			return err
+		}
+		files = append(files, f)
+	}
+	rd, wr, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer rd.Close()
+	files = append(files, wr)
+
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(), restartEnv+"="+strconv.Itoa(len(ls)))
+	cmd.ExtraFiles = files
+	if err = cmd.Start(); err != nil {
+		return err
+	}
+	wr.Close()
+	go cmd.Wait()
+
+	// the new process writes a byte to the pipe when it is ready. If it
+	// exits before, the pipe is closed without data.
+	timeout := gr.ReadyTimeout
+	if timeout <= 0 {
+		timeout = DefRestartReadyTimeout
+	}
+	rd.SetReadDeadline(time.Now().Add(timeout))
+	if n, _ := rd.Read(make([]byte, 1)); n != 1 {
+		cmd.Process.Kill()
+		return ErrRestartNotReady
+	}
+
+	for _, l := range ls {
+		if ul, ok := l.(*net.UnixListener); ok {
+			// the socket file is used by the new process.
+			ul.SetUnlinkOnClose(false)
+		}
+	}
+	return nil
+}
+
+// shutdown shuts down Server gracefully with ShutdownTimeout.
+func (gr *GracefulRestart) shutdown() {
+	ctx := context.Background()
+	if gr.ShutdownTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, gr.ShutdownTimeout)
+		defer cancel()
+	}
+	gr.Server.Shutdown(ctx)
+}
@@ -0,0 +1,7 @@
+//go:build !windows
+
+package tcpserver
+
+import "syscall"
+
+var defRestartSignal = syscall.SIGUSR2
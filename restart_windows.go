@@ -0,0 +1,5 @@
+package tcpserver
+
+import "os"
+
+var defRestartSignal os.Signal
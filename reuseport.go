@@ -0,0 +1,36 @@
+package tcpserver
+
+import (
+	"errors"
+	"runtime"
+	"syscall"
+)
+
+var (
+	// ErrReusePortNotSupported is returned when SO_REUSEPORT is not supported
+	// on the running platform.
+	ErrReusePortNotSupported = errors.New("SO_REUSEPORT not supported")
+)
+
+// reusePortControl is a net.ListenConfig.Control function that sets
+// SO_REUSEPORT on the socket before bind.
+func reusePortControl(network, address string, c syscall.RawConn) (err error) {
+	e := c.Control(func(fd uintptr) {
+		err = setReusePort(fd)
+	})
+	if e != nil {
+		err = e
+	}
+	return
+}
+
+// reusePortCount returns count of listeners for ReusePort.
+func (srv *TCPServer) reusePortCount() int {
+	if srv.ReusePort < 0 {
+		return runtime.NumCPU()
+	}
+	if srv.ReusePort == 0 {
+		return 1
+	}
+	return srv.ReusePort
+}
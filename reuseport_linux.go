@@ -0,0 +1,10 @@
+package tcpserver
+
+import "syscall"
+
+// soReusePort is SO_REUSEPORT on Linux, the syscall package doesn't define it.
+const soReusePort = 0xf
+
+func setReusePort(fd uintptr) error {
+	return syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, soReusePort, 1)
+}
@@ -0,0 +1,120 @@
+package tcpserver
+
+import (
+	"bufio"
+	"net"
+	"sync"
+	"time"
+)
+
+// DefRouterBufferSize specifies buffer size of RouterConn. Matchers can't
+// peek more bytes than it.
+var DefRouterBufferSize = 16 * 1024
+
+// A Matcher reports whether a connection matches by peeking its first bytes
+// from r. Matchers must not consume bytes of r.
+type Matcher func(r *bufio.Reader) bool
+
+// A Router is a Handler that dispatches connections to Handlers by Matchers.
+// Matchers are tried in order of registration.
+type Router struct {
+	// NotFound is an optional Handler to invoke when no Matcher matches.
+	NotFound Handler
+
+	// PeekTimeout specifies maximum duration of matching. If it is 0, there is
+	// no timeout.
+	PeekTimeout time.Duration
+
+	routes   []route
+	routesMu sync.RWMutex
+}
+
+type route struct {
+	matcher Matcher
+	handler Handler
+}
+
+// Handle registers the handler for connections matched by the matcher.
+func (rt *Router) Handle(matcher Matcher, handler Handler) {
+	rt.routesMu.Lock()
+	rt.routes = append(rt.routes, route{
+		matcher: matcher,
+		handler: handler,
+	})
+	rt.routesMu.Unlock()
+}
+
+// Serve implements Handler.Serve. It invokes the handler with *RouterConn to
+// preserve peeked bytes.
+func (rt *Router) Serve(conn net.Conn, closeCh <-chan struct{}) {
+	rc := &RouterConn{
+		Conn: conn,
+		rd:   bufio.NewReaderSize(conn, DefRouterBufferSize),
+	}
+	if rt.PeekTimeout > 0 {
+		conn.SetReadDeadline(time.Now().Add(rt.PeekTimeout))
+	}
+	handler := rt.NotFound
+	rt.routesMu.RLock()
+	for _, r := range rt.routes {
+		if r.matcher(rc.rd) {
+			handler = r.handler
+			break
+		}
+	}
+	rt.routesMu.RUnlock()
+	if rt.PeekTimeout > 0 {
+		conn.SetReadDeadline(time.Time{})
+	}
+	if handler == nil {
+		return
+	}
+	handler.Serve(rc, closeCh)
+}
+
+// A RouterConn is a net.Conn routed by Router. Reads from it return peeked
+// bytes first.
+type RouterConn struct {
+	net.Conn
+
+	rd *bufio.Reader
+}
+
+// Read reads data from buffered reader of connection.
+func (rc *RouterConn) Read(b []byte) (n int, err error) {
+	return rc.rd.Read(b)
+}
+
+// Reader returns buffered reader of connection.
+func (rc *RouterConn) Reader() *bufio.Reader {
+	return rc.rd
+}
+
+// Peek returns the next n bytes without advancing the reader.
+func (rc *RouterConn) Peek(n int) ([]byte, error) {
+	return rc.rd.Peek(n)
+}
+
+// NetConn returns the routed connection.
+func (rc *RouterConn) NetConn() net.Conn {
+	return rc.Conn
+}
+
+// MatchAny matches any connection.
+func MatchAny(r *bufio.Reader) bool {
+	return true
+}
+
+// MatchPrefix returns a Matcher that matches connections starting with one of
+// the prefixes.
+func MatchPrefix(prefixes ...string) Matcher {
+	return func(r *bufio.Reader) bool {
+		for _, prefix := range prefixes {
+			buf, _ := r.Peek(len(prefix))
+			if string(buf) == prefix {
+				return true
+			}
+		}
+		return false
+	}
+}
@@ -0,0 +1,357 @@
+package tcpserver
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DefRPCMaxConcurrent specifies maximum count of concurrent requests of a
+// connection if RPCHandler.MaxConcurrent is 0.
+var DefRPCMaxConcurrent = 64
+
+var (
+	// ErrRPCClientClosed is returned by calls of a closed RPCClient.
+	ErrRPCClientClosed = errors.New("rpc client closed")
+)
+
+// RPC error codes.
+const (
+	RPCCodeInvalidRequest   = 1
+	RPCCodeMethodNotFound   = 2
+	RPCCodeInvalidParams    = 3
+	RPCCodeInternal         = 4
+	RPCCodeDeadlineExceeded = 5
+	RPCCodeCanceled         = 6
+)
+
+// An RPCError is an error response of an RPC request. Handlers can return an
+// *RPCError to respond with a specific code, other errors are responded with
+// RPCCodeInternal.
+type RPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *RPCError) Error() string {
+	return "rpc error " + strconv.Itoa(e.Code) + ": " + e.Message
+}
+
+// rpcMessage is a request or response frame. Payloads are JSON.
+type rpcMessage struct {
+	ID      uint64          `json:"id"`
+	Method  string          `json:"method,omitempty"`
+	Timeout int64           `json:"timeout_ms,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *RPCError       `json:"error,omitempty"`
+}
+
+type rpcMethodFunc func(ctx context.Context, params json.RawMessage) (interface{}, error)
+
+// RPCHandler is a Handler of a request/response RPC protocol over frames of
+// Codec. Requests of a connection are handled concurrently, and responses are
+// correlated with requests by their IDs. Requests and responses are JSON
+// messages, use RPCClient to call.
+type RPCHandler struct {
+	// Codec of frames. If it is nil, a LengthPrefixCodec with defaults is
+	// used.
+	Codec Codec
+
+	// Timeout specifies maximum duration of requests. Requests can specify a
+	// shorter deadline. If it is 0, only deadlines of requests are used.
+	Timeout time.Duration
+
+	// MaxConcurrent specifies maximum count of concurrent requests of a
+	// connection. Reading requests waits for a free slot. If it is 0,
+	// DefRPCMaxConcurrent is used.
+	MaxConcurrent int
+
+	methodsMu sync.RWMutex
+	methods   map[string]rpcMethodFunc
+}
+
+// HandleRPC registers the typed handler of the method. Params of requests
+// are unmarshaled into Req, and the result is marshaled as response.
+func HandleRPC[Req, Resp any](h *RPCHandler, method string, f func(ctx context.Context, req *Req) (*Resp, error)) {
+	h.methodsMu.Lock()
+	defer h.methodsMu.Unlock()
+	if h.methods == nil {
+		h.methods = make(map[string]rpcMethodFunc)
+	}
+	h.methods[method] = func(ctx context.Context, params json.RawMessage) (interface{}, error) {
+		req := new(Req)
+		if len(params) > 0 {
+			if err := json.Unmarshal(params, req); err != nil {
+				return nil, &RPCError{Code: RPCCodeInvalidParams, Message: err.Error()}
+			}
+		}
+		return f(ctx, req)
+	}
+}
+
+func (h *RPCHandler) method(name string) rpcMethodFunc {
+	h.methodsMu.RLock()
+	defer h.methodsMu.RUnlock()
+	return h.methods[name]
+}
+
+func (h *RPCHandler) codec() Codec {
+	if h.Codec == nil {
+		return &LengthPrefixCodec{}
+	}
+	return h.Codec
+}
+
+// Serve implements Handler.Serve.
+func (h *RPCHandler) Serve(conn net.Conn, closeCh <-chan struct{}) {
+	ctx, cancel := closeContext(context.Background(), closeCh)
+	defer cancel()
+	h.ServeContext(ctx, conn)
+}
+
+// ServeContext implements HandlerContext.ServeContext. Contexts of requests
+// are derived from ctx.
+func (h *RPCHandler) ServeContext(ctx context.Context, conn net.Conn) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		// unblocks reading when the connection should be closed.
+		<-ctx.Done()
+		conn.SetReadDeadline(time.Now())
+	}()
+	codec := h.codec()
+	maxConcurrent := h.MaxConcurrent
+	if maxConcurrent <= 0 {
+		maxConcurrent = DefRPCMaxConcurrent
+	}
+	sem := make(chan struct{}, maxConcurrent)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	rd := bufio.NewReader(conn)
+	wr := &rpcWriter{codec: codec, wr: bufio.NewWriter(conn)}
+	for {
+		frame, err := codec.ReadFrame(rd)
+		if err != nil {
+			return
+		}
+		var msg rpcMessage
+		if err := json.Unmarshal(frame, &msg); err != nil {
+			wr.write(&rpcMessage{Error: &RPCError{Code: RPCCodeInvalidRequest, Message: err.Error()}})
+			continue
+		}
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			return
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if err := wr.write(h.handle(ctx, &msg)); err != nil {
+				cancel()
+			}
+		}()
+	}
+}
+
+// handle calls the handler of the request, and returns the response.
+func (h *RPCHandler) handle(ctx context.Context, req *rpcMessage) (resp *rpcMessage) {
+	resp = &rpcMessage{ID: req.ID}
+	f := h.method(req.Method)
+	if f == nil {
+		resp.Error = &RPCError{Code: RPCCodeMethodNotFound, Message: "method not found: " + req.Method}
+		return
+	}
+	timeout := h.Timeout
+	if t := time.Duration(req.Timeout) * time.Millisecond; t > 0 && (timeout <= 0 || t < timeout) {
+		timeout = t
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	defer func() {
+		if e := recover(); e != nil {
+			resp.Result = nil
+			resp.Error = &RPCError{Code: RPCCodeInternal, Message: fmt.Sprint("panic: ", e)}
+		}
+	}()
+	result, err := f(ctx, req.Params)
+	if err != nil {
+		resp.Error = toRPCError(err)
+		return
+	}
+	if resp.Result, err = json.Marshal(result); err != nil {
+		resp.Error = &RPCError{Code: RPCCodeInternal, Message: err.Error()}
+	}
+	return
+}
+
+// toRPCError converts the error of handler to *RPCError.
+func toRPCError(err error) *RPCError {
+	var re *RPCError
+	switch {
+	case errors.As(err, &re):
+		return re
+	case errors.Is(err, context.DeadlineExceeded):
+		return &RPCError{Code: RPCCodeDeadlineExceeded, Message: err.Error()}
+	case errors.Is(err, context.Canceled):
+		return &RPCError{Code: RPCCodeCanceled, Message: err.Error()}
+	}
+	return &RPCError{Code: RPCCodeInternal, Message: err.Error()}
+}
+
+// rpcWriter writes messages as frames, safe to use concurrently.
+type rpcWriter struct {
+	mu    sync.Mutex
+	codec Codec
+	wr    *bufio.Writer
+}
+
+func (w *rpcWriter) write(msg *rpcMessage) error {
+	frame, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.codec.WriteFrame(w.wr, frame); err != nil {
+		return err
+	}
+	return w.wr.Flush()
+}
+
+// An RPCClient calls methods of an RPCHandler over a connection. Calls can
+// be made concurrently, and responses are correlated with calls by IDs.
+type RPCClient struct {
+	conn  net.Conn
+	codec Codec
+	wr    *rpcWriter
+
+	mu      sync.Mutex
+	nextID  uint64
+	pending map[uint64]chan *rpcMessage
+	err     error
+	doneCh  chan struct{}
+}
+
+// NewRPCClient returns a new RPCClient calling over conn with frames of
+// codec. If codec is nil, a LengthPrefixCodec with defaults is used.
+func NewRPCClient(conn net.Conn, codec Codec) *RPCClient {
+	if codec == nil {
+		codec = &LengthPrefixCodec{}
+	}
+	c := &RPCClient{
+		conn:    conn,
+		codec:   codec,
+		wr:      &rpcWriter{codec: codec, wr: bufio.NewWriter(conn)},
+		pending: make(map[uint64]chan *rpcMessage),
+		doneCh:  make(chan struct{}),
+	}
+	go c.read()
+	return c
+}
+
+// Call calls the method with params, and unmarshals the result into result
+// if it isn't nil. The deadline of ctx is sent as the deadline of the
+// request. It returns an *RPCError if the handler responds with an error.
+func (c *RPCClient) Call(ctx context.Context, method string, params, result interface{}) error {
+	req := &rpcMessage{Method: method}
+	if params != nil {
+		var err error
+		if req.Params, err = json.Marshal(params); err != nil {
+			return err
+		}
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		req.Timeout = time.Until(deadline).Milliseconds()
+		if req.Timeout <= 0 {
+			return context.DeadlineExceeded
+		}
+	}
+	ch := make(chan *rpcMessage, 1)
+	c.mu.Lock()
+	if c.err != nil {
+		err := c.err
+		c.mu.Unlock()
+		return err
+	}
+	c.nextID++
+	req.ID = c.nextID
+	c.pending[req.ID] = ch
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, req.ID)
+		c.mu.Unlock()
+	}()
+	if err := c.wr.write(req); err != nil {
+		return err
+	}
+	select {
+	case resp := <-ch:
+		if resp.Error != nil {
+			return resp.Error
+		}
+		if result != nil {
+			return json.Unmarshal(resp.Result, result)
+		}
+		return nil
+	case <-c.doneCh:
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return c.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close closes the connection. Pending calls return ErrRPCClientClosed.
+func (c *RPCClient) Close() error {
+	c.mu.Lock()
+	if c.err == nil {
+		c.err = ErrRPCClientClosed
+	}
+	c.mu.Unlock()
+	err := c.conn.Close()
+	<-c.doneCh
+	return err
+}
+
+func (c *RPCClient) read() {
+	defer close(c.doneCh)
+	rd := bufio.NewReader(c.conn)
+	for {
+		frame, err := c.codec.ReadFrame(rd)
+		if err != nil {
+			c.mu.Lock()
+			if c.err == nil {
+				c.err = err
+			}
+			c.mu.Unlock()
+			return
+		}
+		var resp rpcMessage
+		if json.Unmarshal(frame, &resp) != nil {
+			continue
+		}
+		c.mu.Lock()
+		ch := c.pending[resp.ID]
+		c.mu.Unlock()
+		if ch != nil {
+			select {
+			case ch <- &resp:
+			default:
+			}
+		}
+	}
+}
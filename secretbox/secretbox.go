@@ -0,0 +1,224 @@
+// Package secretbox provides pre-shared key encrypted transport layer for
+// tcpserver based on NaCl secretbox.
+//
+// After connecting, client and server send 32 random bytes to each other in
+// order of client then server. Directional session keys are derived with
+// HKDF-SHA256 from the pre-shared key, salted with the random bytes of client
+// and server. Then both sides send an empty message to confirm the key, first
+// the client. Messages are framed as 2-byte big-endian length prefixed
+// secretbox boxes. Nonces are implicit 64-bit big-endian message counters of
+// each direction. Session keys are replaced by keys derived from them every
+// RekeyInterval messages.
+package secretbox
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/orkunkaraduman/go-tcpserver"
+	"golang.org/x/crypto/hkdf"
+	naclsecretbox "golang.org/x/crypto/nacl/secretbox"
+)
+
+// DefHandshakeTimeout specifies handshake timeout if Server.HandshakeTimeout
+// is 0.
+var DefHandshakeTimeout = 10 * time.Second
+
+// DefRekeyInterval specifies message count between rekeys if
+// Server.RekeyInterval is 0.
+var DefRekeyInterval uint64 = 1 << 20
+
+// MaxPayloadSize is maximum plaintext size of a message.
+const MaxPayloadSize = 16 * 1024
+
+const randomSize = 32
+
+var (
+	// ErrMessageTooLarge is returned when a message exceeds MaxPayloadSize.
+	ErrMessageTooLarge = errors.New("message too large")
+
+	// ErrDecryption is returned when a message can't be decrypted.
+	ErrDecryption = errors.New("message decryption failed")
+
+	// ErrKeyConfirmation is returned when key confirmation message of client
+	// is invalid.
+	ErrKeyConfirmation = errors.New("key confirmation failed")
+)
+
+// Server defines parameters for pre-shared key transport layer as a
+// tcpserver.Handler. It runs the handshake, wraps the connection with *Conn
+// and invokes Handler with it.
+type Server struct {
+	// Handler to invoke.
+	Handler tcpserver.Handler
+
+	// Key is the pre-shared key.
+	Key *[32]byte
+
+	// HandshakeTimeout specifies maximum duration of handshake.
+	HandshakeTimeout time.Duration
+
+	// RekeyInterval specifies message count between rekeys of each direction.
+	// Client must use same value.
+	RekeyInterval uint64
+}
+
+// Serve implements tcpserver.Handler.Serve.
+func (srv *Server) Serve(conn net.Conn, closeCh <-chan struct{}) {
+	timeout := srv.HandshakeTimeout
+	if timeout <= 0 {
+		timeout = DefHandshakeTimeout
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+	sc, err := srv.handshake(conn)
+	if err != nil {
+		return
+	}
+	conn.SetDeadline(time.Time{})
+	if srv.Handler != nil {
+		srv.Handler.Serve(sc, closeCh)
+	}
+}
+
+func (srv *Server) handshake(conn net.Conn) (sc *Conn, err error) {
+	var clientRandom, serverRandom [randomSize]byte
+	if _, err = io.ReadFull(conn, clientRandom[:]); err != nil {
+		return
+	}
+	if _, err = io.ReadFull(rand.Reader, serverRandom[:]); err != nil {
+		return
+	}
+	if _, err = conn.Write(serverRandom[:]); err != nil {
+		return
+	}
+	rekeyInterval := srv.RekeyInterval
+	if rekeyInterval <= 0 {
+		rekeyInterval = DefRekeyInterval
+	}
+	salt := append(clientRandom[:], serverRandom[:]...)
+	sc = &Conn{
+		Conn: conn,
+		recv: newState(srv.Key, salt, "client to server", rekeyInterval),
+		send: newState(srv.Key, salt, "server to client", rekeyInterval),
+	}
+	if _, err = sc.readMessage(); err != nil {
+		if err == ErrDecryption {
+			err = ErrKeyConfirmation
+		}
+		return nil, err
+	}
+	if err = sc.writeMessage(nil); err != nil {
+		return nil, err
+	}
+	return
+}
+
+// state is key state of a direction.
+type state struct {
+	key           [32]byte
+	counter       uint64
+	rekeyInterval uint64
+}
+
+func newState(psk *[32]byte, salt []byte, info string, rekeyInterval uint64) *state {
+	s := &state{
+		rekeyInterval: rekeyInterval,
+	}
+	io.ReadFull(hkdf.New(sha256.New, psk[:], salt, []byte(info)), s.key[:])
+	return s
+}
+
+// nonce returns nonce of next message, and rekeys if necessary.
+func (s *state) nonce() (nonce [24]byte) {
+	if s.counter > 0 && s.counter%s.rekeyInterval == 0 {
+		io.ReadFull(hkdf.New(sha256.New, s.key[:], nil, []byte("rekey")), s.key[:])
+	}
+	binary.BigEndian.PutUint64(nonce[16:], s.counter)
+	s.counter++
+	return
+}
+
+// A Conn is a net.Conn encrypted by pre-shared key.
+type Conn struct {
+	net.Conn
+
+	recv *state
+	send *state
+
+	rdMu  sync.Mutex
+	rdBuf []byte
+	plain []byte
+	wrMu  sync.Mutex
+}
+
+func (c *Conn) readMessage() ([]byte, error) {
+	var hdr [2]byte
+	if _, err := io.ReadFull(c.Conn, hdr[:]); err != nil {
+		return nil, err
+	}
+	size := int(binary.BigEndian.Uint16(hdr[:]))
+	if size < naclsecretbox.Overhead || size > MaxPayloadSize+naclsecretbox.Overhead {
+		return nil, ErrMessageTooLarge
+	}
+	buf := make([]byte, size)
+	if _, err := io.ReadFull(c.Conn, buf); err != nil {
+		return nil, err
+	}
+	nonce := c.recv.nonce()
+	plain, ok := naclsecretbox.Open(c.rdBuf[:0], buf, &nonce, &c.recv.key)
+	if !ok {
+		return nil, ErrDecryption
+	}
+	c.rdBuf = plain
+	return plain, nil
+}
+
+func (c *Conn) writeMessage(b []byte) error {
+	if len(b) > MaxPayloadSize {
+		return ErrMessageTooLarge
+	}
+	nonce := c.send.nonce()
+	buf := make([]byte, 2, 2+len(b)+naclsecretbox.Overhead)
+	buf = naclsecretbox.Seal(buf, b, &nonce, &c.send.key)
+	binary.BigEndian.PutUint16(buf, uint16(len(buf)-2))
+	_, err := c.Conn.Write(buf)
+	return err
+}
+
+// Read reads decrypted data from the connection.
+func (c *Conn) Read(b []byte) (n int, err error) {
+	c.rdMu.Lock()
+	defer c.rdMu.Unlock()
+	for len(c.plain) == 0 {
+		if c.plain, err = c.readMessage(); err != nil {
+			return
+		}
+	}
+	n = copy(b, c.plain)
+	c.plain = c.plain[n:]
+	return
+}
+
+// Write writes encrypted data to the connection.
+func (c *Conn) Write(b []byte) (n int, err error) {
+	c.wrMu.Lock()
+	defer c.wrMu.Unlock()
+	for len(b) > 0 {
+		chunk := b
+		if len(chunk) > MaxPayloadSize {
+			chunk = chunk[:MaxPayloadSize]
+		}
+		if err = c.writeMessage(chunk); err != nil {
+			return
+		}
+		n += len(chunk)
+		b = b[len(chunk):]
+	}
+	return
+}
@@ -0,0 +1,54 @@
+package tcpserver
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// ListenAndServeContext listens and serves like ListenAndServe until ctx is
+// done, then shuts down the server gracefully by Shutdown with
+// ShutdownTimeout. It returns nil if the shutdown completes, or the error of
+// Shutdown or ListenAndServe.
+func (srv *TCPServer) ListenAndServeContext(ctx context.Context) error {
+	return srv.serveContext(ctx, srv.ListenAndServe)
+}
+
+// ListenAndServeTLSContext listens and serves like ListenAndServeTLS until
+// ctx is done, then shuts down the server like ListenAndServeContext.
+func (srv *TCPServer) ListenAndServeTLSContext(ctx context.Context, certFile, keyFile string) error {
+	return srv.serveContext(ctx, func() error {
+		return srv.ListenAndServeTLS(certFile, keyFile)
+	})
+}
+
+func (srv *TCPServer) serveContext(ctx context.Context, serve func() error) error {
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- serve()
+	}()
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+	shutdownCtx := context.Background()
+	if srv.ShutdownTimeout > 0 {
+		var cancel context.CancelFunc
+		shutdownCtx, cancel = context.WithTimeout(shutdownCtx, srv.ShutdownTimeout)
+		defer cancel()
+	}
+	err := srv.Shutdown(shutdownCtx)
+	if e := <-errCh; e != ErrServerClosed && err == nil {
+		err = e
+	}
+	return err
+}
+
+// SignalContext returns a context derived from parent, which is cancelled
+// when the process receives SIGINT or SIGTERM, for ListenAndServeContext.
+// Calling stop unregisters the signals.
+func SignalContext(parent context.Context) (ctx context.Context, stop context.CancelFunc) {
+	return signal.NotifyContext(parent, os.Interrupt, syscall.SIGTERM)
+}
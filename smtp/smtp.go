@@ -0,0 +1,547 @@
+// Package smtp provides SMTP protocol Handler for tcpserver.
+package smtp
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+
+	"github.com/orkunkaraduman/go-tcpserver"
+)
+
+// DefMaxLineSize specifies maximum command line size with delimiter if
+// Server.MaxLineSize is 0.
+var DefMaxLineSize = 4 * 1024
+
+var (
+	// ErrMessageTooLarge is returned by Reader of OnData when message exceeds
+	// Server.MaxMessageSize.
+	ErrMessageTooLarge = errors.New("message too large")
+)
+
+// Error is an SMTP reply. Callbacks can return *Error to send specified
+// reply instead of default error reply.
+type Error struct {
+	Code    int
+	Message string
+}
+
+func (e *Error) Error() string {
+	return strconv.Itoa(e.Code) + " " + e.Message
+}
+
+// Server defines parameters for Handler of SMTP protocol.
+type Server struct {
+	// Hostname to use in greeting and EHLO reply.
+	Hostname string
+
+	// TLSConfig optionally provides a TLS configuration to enable STARTTLS.
+	TLSConfig *tls.Config
+
+	// MaxLineSize specifies maximum command line size with delimiter.
+	MaxLineSize int
+
+	// MaxMessageSize specifies maximum message size. If it is 0, message size
+	// isn't limited.
+	MaxMessageSize int64
+
+	// MaxRecipients specifies maximum recipient count of a message. If it is
+	// 0, recipient count isn't limited.
+	MaxRecipients int
+
+	// AllowInsecureAuth allows AUTH on plaintext connections when TLSConfig is
+	// provided.
+	AllowInsecureAuth bool
+
+	// Auth callback. It enables AUTH PLAIN and AUTH LOGIN if it isn't nil.
+	OnAuth func(ctx *Context, username, password string) error
+
+	// Hello callback. It will be called on HELO and EHLO.
+	OnHello func(ctx *Context, domain string) error
+
+	// MailFrom callback. It will be called on MAIL FROM.
+	OnMailFrom func(ctx *Context, from string) error
+
+	// RcptTo callback. It will be called on RCPT TO.
+	OnRcptTo func(ctx *Context, to string) error
+
+	// Data callback. It will be called on DATA with a Reader of message
+	// content. Unread content is discarded after it returns.
+	OnData func(ctx *Context, r io.Reader) error
+
+	// Quit callback. It will be called before closing.
+	OnQuit func(ctx *Context)
+
+	// User data to use free.
+	UserData interface{}
+}
+
+// Serve implements tcpserver.Handler.Serve.
+func (srv *Server) Serve(conn net.Conn, closeCh <-chan struct{}) {
+	ctx := &Context{
+		Srv:      srv,
+		Conn:     conn,
+		closeCh:  closeCh,
+		closeCh2: make(chan struct{}, 1),
+	}
+	ctx.setConn(conn)
+	ctx.serve()
+}
+
+// Context defines parameters for SMTP protocol context.
+type Context struct {
+	// Pointer of Server struct handled by this context.
+	Srv *Server
+
+	// Connection handled by this context. It is replaced by *tls.Conn after
+	// STARTTLS.
+	Conn net.Conn
+
+	// Domain given by HELO or EHLO.
+	Domain string
+
+	// Username of authenticated client.
+	Username string
+
+	// Sender of current transaction.
+	From string
+
+	// Recipients of current transaction.
+	To []string
+
+	// User data to use free.
+	UserData interface{}
+
+	closeCh  <-chan struct{}
+	closeCh2 chan struct{}
+	rd       *bufio.Reader
+	wr       *bufio.Writer
+	tls      bool
+}
+
+// Close closes context.
+func (ctx *Context) Close() {
+	select {
+	case ctx.closeCh2 <- struct{}{}:
+	default:
+	}
+}
+
+// TLS reports whether connection is upgraded to TLS.
+func (ctx *Context) TLS() bool {
+	return ctx.tls
+}
+
+// Reset resets current transaction.
+func (ctx *Context) Reset() {
+	ctx.From = ""
+	ctx.To = nil
+}
+
+// WriteReply writes a reply to connection. Multiple lines are written as a
+// multiline reply.
+func (ctx *Context) WriteReply(code int, lines ...string) error {
+	if len(lines) == 0 {
+		lines = []string{""}
+	}
+	for i, line := range lines {
+		sep := "-"
+		if i == len(lines)-1 {
+			sep = " "
+		}
+		if _, err := ctx.wr.WriteString(strconv.Itoa(code) + sep + line + "\r\n"); err != nil {
+			ctx.Close()
+			return err
+		}
+	}
+	if err := ctx.wr.Flush(); err != nil {
+		ctx.Close()
+		return err
+	}
+	return nil
+}
+
+func (ctx *Context) setConn(conn net.Conn) {
+	ctx.Conn = conn
+	ctx.rd = bufio.NewReader(conn)
+	ctx.wr = bufio.NewWriter(conn)
+}
+
+func (ctx *Context) writeError(err error, code int, message string) {
+	if e, ok := err.(*Error); ok {
+		ctx.WriteReply(e.Code, e.Message)
+		return
+	}
+	ctx.WriteReply(code, message)
+}
+
+func (ctx *Context) readLine() (string, error) {
+	maxLineSize := ctx.Srv.MaxLineSize
+	if maxLineSize <= 0 {
+		maxLineSize = DefMaxLineSize
+	}
+	line, err := tcpserver.ReadBytesLimit(ctx.rd, '\n', maxLineSize)
+	if err != nil {
+		return "", err
+	}
+	line = bytes.TrimSuffix(line, []byte("\n"))
+	line = bytes.TrimSuffix(line, []byte("\r"))
+	return string(line), nil
+}
+
+func (ctx *Context) hostname() string {
+	if ctx.Srv.Hostname != "" {
+		return ctx.Srv.Hostname
+	}
+	return "localhost"
+}
+
+func (ctx *Context) serve() {
+	ctx.WriteReply(220, ctx.hostname()+" ESMTP Service ready")
+mainloop:
+	for {
+		select {
+		case <-ctx.closeCh:
+			ctx.WriteReply(421, "4.3.2 Service shutting down")
+			break mainloop
+		case <-ctx.closeCh2:
+			break mainloop
+		default:
+		}
+		line, err := ctx.readLine()
+		if err != nil {
+			if err == tcpserver.ErrBufferLimitExceeded {
+				ctx.WriteReply(500, "5.5.6 Line too long")
+			}
+			ctx.Close()
+			continue
+		}
+		verb, arg := line, ""
+		if i := strings.IndexByte(line, ' '); i >= 0 {
+			verb, arg = line[:i], strings.TrimSpace(line[i+1:])
+		}
+		switch strings.ToUpper(verb) {
+		case "HELO":
+			ctx.handleHello(arg, false)
+		case "EHLO":
+			ctx.handleHello(arg, true)
+		case "STARTTLS":
+			ctx.handleStartTLS()
+		case "AUTH":
+			ctx.handleAuth(arg)
+		case "MAIL":
+			ctx.handleMail(arg)
+		case "RCPT":
+			ctx.handleRcpt(arg)
+		case "DATA":
+			ctx.handleData()
+		case "RSET":
+			ctx.Reset()
+			ctx.WriteReply(250, "2.0.0 OK")
+		case "NOOP":
+			ctx.WriteReply(250, "2.0.0 OK")
+		case "VRFY":
+			ctx.WriteReply(252, "2.5.0 Cannot VRFY user")
+		case "QUIT":
+			ctx.WriteReply(221, "2.0.0 Bye")
+			ctx.Close()
+		default:
+			ctx.WriteReply(502, "5.5.2 Command not implemented")
+		}
+	}
+	if ctx.Srv.OnQuit != nil {
+		ctx.Srv.OnQuit(ctx)
+	}
+}
+
+func (ctx *Context) authAllowed() bool {
+	return ctx.Srv.OnAuth != nil &&
+		(ctx.tls || ctx.Srv.TLSConfig == nil || ctx.Srv.AllowInsecureAuth)
+}
+
+func (ctx *Context) handleHello(domain string, extended bool) {
+	if domain == "" {
+		ctx.WriteReply(501, "5.5.4 Domain required")
+		return
+	}
+	if ctx.Srv.OnHello != nil {
+		if err := ctx.Srv.OnHello(ctx, domain); err != nil {
+			ctx.writeError(err, 550, "5.7.1 Access denied")
+			return
+		}
+	}
+	ctx.Domain = domain
+	ctx.Reset()
+	if !extended {
+		ctx.WriteReply(250, ctx.hostname())
+		return
+	}
+	lines := []string{ctx.hostname(), "8BITMIME", "PIPELINING"}
+	if ctx.Srv.MaxMessageSize > 0 {
+		lines = append(lines, "SIZE "+strconv.FormatInt(ctx.Srv.MaxMessageSize, 10))
+	}
+	if ctx.Srv.TLSConfig != nil && !ctx.tls {
+		lines = append(lines, "STARTTLS")
+	}
+	if ctx.authAllowed() {
+		lines = append(lines, "AUTH PLAIN LOGIN")
+	}
+	ctx.WriteReply(250, lines...)
+}
+
+func (ctx *Context) handleStartTLS() {
+	if ctx.Srv.TLSConfig == nil || ctx.tls {
+		ctx.WriteReply(502, "5.5.1 STARTTLS not available")
+		return
+	}
+	if ctx.rd.Buffered() > 0 {
+		ctx.WriteReply(501, "5.5.4 Pipelining not allowed before TLS")
+		return
+	}
+	if err := ctx.WriteReply(220, "2.0.0 Ready to start TLS"); err != nil {
+		return
+	}
+	tlsConn, err := tcpserver.StartTLS(ctx.Conn, ctx.Srv.TLSConfig, 0)
+	if err != nil {
+		ctx.Close()
+		return
+	}
+	ctx.setConn(tlsConn)
+	ctx.tls = true
+	ctx.Domain = ""
+	ctx.Username = ""
+	ctx.Reset()
+}
+
+func (ctx *Context) handleAuth(arg string) {
+	if !ctx.authAllowed() {
+		ctx.WriteReply(502, "5.5.1 AUTH not available")
+		return
+	}
+	if ctx.Domain == "" {
+		ctx.WriteReply(503, "5.5.1 Send EHLO first")
+		return
+	}
+	if ctx.Username != "" {
+		ctx.WriteReply(503, "5.5.1 Already authenticated")
+		return
+	}
+	mechanism, initial := arg, ""
+	if i := strings.IndexByte(arg, ' '); i >= 0 {
+		mechanism, initial = arg[:i], strings.TrimSpace(arg[i+1:])
+	}
+	var username, password string
+	switch strings.ToUpper(mechanism) {
+	case "PLAIN":
+		resp, ok := ctx.authResponse(initial, "")
+		if !ok {
+			return
+		}
+		fields := strings.Split(resp, "\x00")
+		if len(fields) != 3 {
+			ctx.WriteReply(501, "5.5.2 Invalid response")
+			return
+		}
+		username, password = fields[1], fields[2]
+	case "LOGIN":
+		var ok bool
+		if username, ok = ctx.authResponse(initial, "Username:"); !ok {
+			return
+		}
+		if password, ok = ctx.authResponse("", "Password:"); !ok {
+			return
+		}
+	default:
+		ctx.WriteReply(504, "5.5.4 Unrecognized authentication type")
+		return
+	}
+	if err := ctx.Srv.OnAuth(ctx, username, password); err != nil {
+		ctx.writeError(err, 535, "5.7.8 Authentication credentials invalid")
+		return
+	}
+	ctx.Username = username
+	ctx.WriteReply(235, "2.7.0 Authentication successful")
+}
+
+// authResponse returns decoded initial response, or decoded response of the
+// challenge if initial response is empty.
+func (ctx *Context) authResponse(initial string, challenge string) (string, bool) {
+	resp := initial
+	if resp == "" {
+		if err := ctx.WriteReply(334, base64.StdEncoding.EncodeToString([]byte(challenge))); err != nil {
+			return "", false
+		}
+		line, err := ctx.readLine()
+		if err != nil {
+			ctx.Close()
+			return "", false
+		}
+		resp = line
+	}
+	if resp == "*" {
+		ctx.WriteReply(501, "5.0.0 Authentication cancelled")
+		return "", false
+	}
+	if resp == "=" {
+		return "", true
+	}
+	buf, err := base64.StdEncoding.DecodeString(resp)
+	if err != nil {
+		ctx.WriteReply(501, "5.5.2 Invalid base64 data")
+		return "", false
+	}
+	return string(buf), true
+}
+
+// parsePath parses "PREFIX:<path> params" argument of MAIL and RCPT.
+func parsePath(arg string, prefix string) (path string, params []string, ok bool) {
+	if len(arg) < len(prefix) || !strings.EqualFold(arg[:len(prefix)], prefix) {
+		return
+	}
+	arg = strings.TrimSpace(arg[len(prefix):])
+	if !strings.HasPrefix(arg, "<") {
+		return
+	}
+	i := strings.IndexByte(arg, '>')
+	if i < 0 {
+		return
+	}
+	return arg[1:i], strings.Fields(arg[i+1:]), true
+}
+
+func (ctx *Context) handleMail(arg string) {
+	if ctx.Domain == "" {
+		ctx.WriteReply(503, "5.5.1 Send HELO first")
+		return
+	}
+	if ctx.From != "" {
+		ctx.WriteReply(503, "5.5.1 Sender already specified")
+		return
+	}
+	if ctx.Srv.OnAuth != nil && ctx.Username == "" {
+		ctx.WriteReply(530, "5.7.0 Authentication required")
+		return
+	}
+	from, params, ok := parsePath(arg, "FROM:")
+	if !ok {
+		ctx.WriteReply(501, "5.5.4 Syntax: MAIL FROM:<address>")
+		return
+	}
+	for _, param := range params {
+		if len(param) > 5 && strings.EqualFold(param[:5], "SIZE=") {
+			size, err := strconv.ParseInt(param[5:], 10, 64)
+			if err != nil {
+				ctx.WriteReply(501, "5.5.4 Invalid SIZE parameter")
+				return
+			}
+			if ctx.Srv.MaxMessageSize > 0 && size > ctx.Srv.MaxMessageSize {
+				ctx.WriteReply(552, "5.3.4 Message size exceeds fixed limit")
+				return
+			}
+		}
+	}
+	if ctx.Srv.OnMailFrom != nil {
+		if err := ctx.Srv.OnMailFrom(ctx, from); err != nil {
+			ctx.writeError(err, 550, "5.7.1 Sender rejected")
+			return
+		}
+	}
+	if from == "" {
+		// null reverse-path
+		from = "<>"
+	}
+	ctx.From = from
+	ctx.WriteReply(250, "2.1.0 OK")
+}
+
+func (ctx *Context) handleRcpt(arg string) {
+	if ctx.From == "" {
+		ctx.WriteReply(503, "5.5.1 Send MAIL first")
+		return
+	}
+	if ctx.Srv.MaxRecipients > 0 && len(ctx.To) >= ctx.Srv.MaxRecipients {
+		ctx.WriteReply(452, "4.5.3 Too many recipients")
+		return
+	}
+	to, _, ok := parsePath(arg, "TO:")
+	if !ok || to == "" {
+		ctx.WriteReply(501, "5.5.4 Syntax: RCPT TO:<address>")
+		return
+	}
+	if ctx.Srv.OnRcptTo != nil {
+		if err := ctx.Srv.OnRcptTo(ctx, to); err != nil {
+			ctx.writeError(err, 550, "5.1.1 Recipient rejected")
+			return
+		}
+	}
+	ctx.To = append(ctx.To, to)
+	ctx.WriteReply(250, "2.1.5 OK")
+}
+
+func (ctx *Context) handleData() {
+	if len(ctx.To) == 0 {
+		ctx.WriteReply(503, "5.5.1 Send RCPT first")
+		return
+	}
+	if err := ctx.WriteReply(354, "Start mail input; end with <CRLF>.<CRLF>"); err != nil {
+		return
+	}
+	r := &dataReader{
+		r:   textproto.NewReader(ctx.rd).DotReader(),
+		max: ctx.Srv.MaxMessageSize,
+	}
+	var err error
+	if ctx.Srv.OnData != nil {
+		err = ctx.Srv.OnData(ctx, r)
+	}
+	if e := r.discard(); e != nil {
+		ctx.Close()
+		return
+	}
+	ctx.Reset()
+	if r.exceeded {
+		ctx.WriteReply(552, "5.3.4 Message size exceeds fixed limit")
+		return
+	}
+	if err != nil {
+		ctx.writeError(err, 554, "5.3.0 Transaction failed")
+		return
+	}
+	ctx.WriteReply(250, "2.0.0 OK: queued")
+}
+
+// dataReader reads message content and enforces maximum message size.
+type dataReader struct {
+	r        io.Reader
+	max      int64
+	n        int64
+	exceeded bool
+}
+
+func (r *dataReader) Read(p []byte) (n int, err error) {
+	n, err = r.r.Read(p)
+	r.n += int64(n)
+	if r.max > 0 && r.n > r.max {
+		r.exceeded = true
+		err = ErrMessageTooLarge
+	}
+	return
+}
+
+// discard reads and discards unread content.
+func (r *dataReader) discard() error {
+	n, err := io.Copy(ioutil.Discard, r.r)
+	r.n += n
+	if r.max > 0 && r.n > r.max {
+		r.exceeded = true
+	}
+	return err
+}
@@ -0,0 +1,284 @@
+// Package ssh provides SSH transport Handler for tcpserver.
+package ssh
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	cryptossh "golang.org/x/crypto/ssh"
+)
+
+// DefHandshakeTimeout specifies SSH handshake timeout if
+// Server.HandshakeTimeout is 0.
+var DefHandshakeTimeout = 30 * time.Second
+
+// A ChannelHandler handles a new channel of type registered for.
+type ChannelHandler func(ctx *Context, newCh cryptossh.NewChannel)
+
+// A SessionHandler handles a session channel after shell, exec or subsystem
+// request. It returns exit status of the session.
+type SessionHandler func(s *Session) (exitStatus uint32)
+
+// Server defines parameters for Handler of SSH transport. It runs SSH server
+// handshake on connections, and dispatches channels to registered handlers.
+// Session channels are handled by built-in session handler unless a
+// ChannelHandler registered for "session" type.
+type Server struct {
+	// Config of SSH server.
+	Config *cryptossh.ServerConfig
+
+	// HandshakeTimeout specifies maximum duration of SSH handshake.
+	HandshakeTimeout time.Duration
+
+	// Session callback. It will be called on shell and exec requests of
+	// session channels.
+	OnSession SessionHandler
+
+	// GlobalRequest callback. It will be called for global requests. If it is
+	// nil, global requests are discarded.
+	OnGlobalRequest func(ctx *Context, req *cryptossh.Request)
+
+	// User data to use free.
+	UserData interface{}
+
+	handlersMu      sync.RWMutex
+	channelHandlers map[string]ChannelHandler
+	subsystems      map[string]SessionHandler
+}
+
+// HandleChannel registers the handler for the channel type.
+func (srv *Server) HandleChannel(channelType string, handler ChannelHandler) {
+	srv.handlersMu.Lock()
+	if srv.channelHandlers == nil {
+		srv.channelHandlers = make(map[string]ChannelHandler)
+	}
+	srv.channelHandlers[channelType] = handler
+	srv.handlersMu.Unlock()
+}
+
+// HandleSubsystem registers the handler for the subsystem of session
+// channels like "sftp".
+func (srv *Server) HandleSubsystem(name string, handler SessionHandler) {
+	srv.handlersMu.Lock()
+	if srv.subsystems == nil {
+		srv.subsystems = make(map[string]SessionHandler)
+	}
+	srv.subsystems[name] = handler
+	srv.handlersMu.Unlock()
+}
+
+func (srv *Server) channelHandler(channelType string) ChannelHandler {
+	srv.handlersMu.RLock()
+	defer srv.handlersMu.RUnlock()
+	if h, ok := srv.channelHandlers[channelType]; ok {
+		return h
+	}
+	if channelType == "session" {
+		return srv.handleSession
+	}
+	return nil
+}
+
+func (srv *Server) subsystem(name string) SessionHandler {
+	srv.handlersMu.RLock()
+	defer srv.handlersMu.RUnlock()
+	return srv.subsystems[name]
+}
+
+// Context defines parameters for SSH connection context.
+type Context struct {
+	// Pointer of Server struct handled by this context.
+	Srv *Server
+
+	// Connection handled by this context.
+	Conn net.Conn
+
+	// SSH connection handled by this context.
+	SSHConn *cryptossh.ServerConn
+
+	// User data to use free.
+	UserData interface{}
+}
+
+// Close closes context.
+func (ctx *Context) Close() {
+	ctx.SSHConn.Close()
+}
+
+// Serve implements tcpserver.Handler.Serve.
+func (srv *Server) Serve(conn net.Conn, closeCh <-chan struct{}) {
+	timeout := srv.HandshakeTimeout
+	if timeout <= 0 {
+		timeout = DefHandshakeTimeout
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+	sconn, chans, reqs, err := cryptossh.NewServerConn(conn, srv.Config)
+	if err != nil {
+		return
+	}
+	conn.SetDeadline(time.Time{})
+	ctx := &Context{
+		Srv:     srv,
+		Conn:    conn,
+		SSHConn: sconn,
+	}
+
+	doneCh := make(chan struct{})
+	defer close(doneCh)
+	go func() {
+		select {
+		case <-closeCh:
+			sconn.Close()
+		case <-doneCh:
+		}
+	}()
+
+	go func() {
+		for req := range reqs {
+			if srv.OnGlobalRequest != nil {
+				srv.OnGlobalRequest(ctx, req)
+				continue
+			}
+			if req.WantReply {
+				req.Reply(false, nil)
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for newCh := range chans {
+		h := srv.channelHandler(newCh.ChannelType())
+		if h == nil {
+			newCh.Reject(cryptossh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+		wg.Add(1)
+		go func(newCh cryptossh.NewChannel) {
+			defer wg.Done()
+			h(ctx, newCh)
+		}(newCh)
+	}
+	wg.Wait()
+	sconn.Close()
+}
+
+// Session defines parameters for a session channel.
+type Session struct {
+	cryptossh.Channel
+
+	// Context of SSH connection.
+	Ctx *Context
+
+	// Type of request started the session: "shell", "exec" or "subsystem".
+	Type string
+
+	// Command of exec request or name of subsystem request.
+	Command string
+
+	// Environment variables sent by client as "name=value" pairs.
+	Env []string
+
+	// Terminal type of pty request. It is empty if client didn't request pty.
+	Term string
+
+	mu     sync.Mutex
+	width  int
+	height int
+}
+
+// WindowSize returns window size of pty in characters.
+func (s *Session) WindowSize() (width, height int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.width, s.height
+}
+
+func (s *Session) setWindowSize(width, height uint32) {
+	s.mu.Lock()
+	s.width, s.height = int(width), int(height)
+	s.mu.Unlock()
+}
+
+// handleSession is the built-in ChannelHandler of session channels.
+func (srv *Server) handleSession(ctx *Context, newCh cryptossh.NewChannel) {
+	ch, reqs, err := newCh.Accept()
+	if err != nil {
+		return
+	}
+	defer ch.Close()
+	s := &Session{
+		Channel: ch,
+		Ctx:     ctx,
+	}
+	for req := range reqs {
+		var handler SessionHandler
+		switch req.Type {
+		case "env":
+			var msg struct{ Name, Value string }
+			if cryptossh.Unmarshal(req.Payload, &msg) != nil {
+				req.Reply(false, nil)
+				continue
+			}
+			s.Env = append(s.Env, msg.Name+"="+msg.Value)
+			req.Reply(true, nil)
+			continue
+		case "pty-req":
+			var msg struct {
+				Term          string
+				Columns, Rows uint32
+				Width, Height uint32
+				Modes         string
+			}
+			if cryptossh.Unmarshal(req.Payload, &msg) != nil {
+				req.Reply(false, nil)
+				continue
+			}
+			s.Term = msg.Term
+			s.setWindowSize(msg.Columns, msg.Rows)
+			req.Reply(true, nil)
+			continue
+		case "shell":
+			handler = srv.OnSession
+		case "exec":
+			var msg struct{ Command string }
+			if cryptossh.Unmarshal(req.Payload, &msg) == nil {
+				s.Command = msg.Command
+				handler = srv.OnSession
+			}
+		case "subsystem":
+			var msg struct{ Name string }
+			if cryptossh.Unmarshal(req.Payload, &msg) == nil {
+				s.Command = msg.Name
+				handler = srv.subsystem(msg.Name)
+			}
+		}
+		if handler == nil {
+			req.Reply(false, nil)
+			continue
+		}
+		s.Type = req.Type
+		req.Reply(true, nil)
+		go func() {
+			for req := range reqs {
+				ok := false
+				if req.Type == "window-change" {
+					var msg struct {
+						Columns, Rows uint32
+						Width, Height uint32
+					}
+					if cryptossh.Unmarshal(req.Payload, &msg) == nil {
+						s.setWindowSize(msg.Columns, msg.Rows)
+						ok = true
+					}
+				}
+				if req.WantReply {
+					req.Reply(ok, nil)
+				}
+			}
+		}()
+		exitStatus := handler(s)
+		ch.SendRequest("exit-status", false, cryptossh.Marshal(struct{ Status uint32 }{exitStatus}))
+		return
+	}
+}
@@ -0,0 +1,64 @@
+package tcpserver
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"time"
+)
+
+var (
+	// ErrNoTLSConfig is returned by StartTLS when there isn't any TLS
+	// configuration or certificate.
+	ErrNoTLSConfig = errors.New("no TLS configuration")
+)
+
+// StartTLS upgrades the plaintext connection to TLS as server using config,
+// for protocols negotiating TLS mid-stream like STARTTLS. The handshake is
+// done before returning. If timeout is 0, DefTLSHandshakeTimeout is used.
+// Data buffered by the caller from the plaintext connection must be empty.
+func StartTLS(conn net.Conn, config *tls.Config, timeout time.Duration) (*tls.Conn, error) {
+	if config == nil {
+		return nil, ErrNoTLSConfig
+	}
+	if timeout <= 0 {
+		timeout = DefTLSHandshakeTimeout
+	}
+	tlsConn := tls.Server(conn, config)
+	tlsConn.SetDeadline(time.Now().Add(timeout))
+	err := tlsConn.Handshake()
+	tlsConn.SetDeadline(time.Time{})
+	if err != nil {
+		return nil, &TLSHandshakeError{
+			RemoteAddr: conn.RemoteAddr(),
+			Err:        err,
+		}
+	}
+	return tlsConn, nil
+}
+
+// StartTLS upgrades the plaintext connection passed to Handler to TLS by
+// StartTLS with TLSHandshakeTimeout of the server. The TLS configuration is
+// built like ServeTLS does, from TLSConfig or DefaultTLSConfig with
+// ClientAuth and NextProtoHandlers, and it must have a certificate. The
+// upgraded connection stays tracked by the server, OnDrain and ConnState are
+// called with it after upgrade.
+func (srv *TCPServer) StartTLS(conn net.Conn) (*tls.Conn, error) {
+	config := srv.tlsConfig()
+	if !hasCert(config) {
+		return nil, ErrNoTLSConfig
+	}
+	tlsConn, err := StartTLS(conn, config, srv.tlsHandshakeTimeout())
+	if err != nil {
+		return nil, err
+	}
+	srv.connsMu.RLock()
+	for _, cc := range srv.conns {
+		if cc.conn == conn || cc.handlerConn() == conn {
+			cc.setHandlerConn(tlsConn)
+			break
+		}
+	}
+	srv.connsMu.RUnlock()
+	return tlsConn, nil
+}
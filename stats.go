@@ -0,0 +1,55 @@
+package tcpserver
+
+import (
+	"sync/atomic"
+)
+
+// counters holds cumulative counters of the server.
+type counters struct {
+	accepted        atomic.Uint64
+	rejected        atomic.Uint64
+	closed          atomic.Uint64
+	handshakeErrors atomic.Uint64
+	panics          atomic.Uint64
+}
+
+// Stats are counters of the server. Cumulative counters are counted since the
+// server is created.
+type Stats struct {
+	// Accepted is count of connections accepted from listeners.
+	Accepted uint64
+
+	// Rejected is count of accepted connections closed without serving by
+	// drain mode, AcceptFilter, MaxConns or ListenerConfig limits.
+	Rejected uint64
+
+	// Active is count of connections being served.
+	Active int
+
+	// Closed is count of served connections closed by the server.
+	Closed uint64
+
+	// HandshakeErrors is count of failed TLS handshakes.
+	HandshakeErrors uint64
+
+	// Panics is count of recovered panics of handlers.
+	Panics uint64
+}
+
+// Stats returns counters of the server.
+func (srv *TCPServer) Stats() Stats {
+	return Stats{
+		Accepted:        srv.counters.accepted.Load(),
+		Rejected:        srv.counters.rejected.Load(),
+		Active:          srv.ConnCount(),
+		Closed:          srv.counters.closed.Load(),
+		HandshakeErrors: srv.counters.handshakeErrors.Load(),
+		Panics:          srv.counters.panics.Load(),
+	}
+}
+
+// ConnCount returns count of connections being served, including connections
+// accepted and waiting for Handler.
+func (srv *TCPServer) ConnCount() int {
+	return int(atomic.LoadInt32(&srv.active))
+}
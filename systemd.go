@@ -0,0 +1,81 @@
+package tcpserver
+
+import (
+	"errors"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrNotSocketActivated is returned when the process isn't socket
+	// activated by systemd.
+	ErrNotSocketActivated = errors.New("not socket activated")
+)
+
+// systemdListenFdsStart is the first file descriptor passed by systemd.
+const systemdListenFdsStart = 3
+
+// SystemdListeners returns listeners passed by systemd socket activation with
+// sd_listen_fds semantics, and their names from LISTEN_FDNAMES. If unsetEnv is
+// true, LISTEN_PID, LISTEN_FDS and LISTEN_FDNAMES are unset, so child
+// processes don't inherit them. It returns ErrNotSocketActivated if the
+// process isn't socket activated.
+func SystemdListeners(unsetEnv bool) (ls []net.Listener, names []string, err error) {
+	if unsetEnv {
+		defer func() {
+			os.Unsetenv("LISTEN_PID")
+			os.Unsetenv("LISTEN_FDS")
+			os.Unsetenv("LISTEN_FDNAMES")
+		}()
+	}
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil, ErrNotSocketActivated
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n <= 0 {
+		return nil, nil, ErrNotSocketActivated
+	}
+	fdNames := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	for i := 0; i < n; i++ {
+		name := "unknown"
+		if i < len(fdNames) && fdNames[i] != "" {
+			name = fdNames[i]
+		}
+		l, e := FileListener(uintptr(systemdListenFdsStart + i))
+		if e != nil {
+			closeListeners(ls)
+			return nil, nil, e
+		}
+		ls = append(ls, l)
+		names = append(names, name)
+	}
+	return ls, names, nil
+}
+
+// ListenAndServeSystemd serves listeners passed by systemd socket activation
+// like ServeListeners. If name isn't empty, only listeners with the name in
+// LISTEN_FDNAMES are served, and the others are closed.
+func (srv *TCPServer) ListenAndServeSystemd(name string) error {
+	ls, names, err := SystemdListeners(true)
+	if err != nil {
+		return err
+	}
+	if name != "" {
+		var selected []net.Listener
+		for i, l := range ls {
+			if names[i] == name {
+				selected = append(selected, l)
+			} else {
+				l.Close()
+			}
+		}
+		if len(selected) == 0 {
+			return ErrNotSocketActivated
+		}
+		ls = selected
+	}
+	return srv.ServeListeners(ls)
+}
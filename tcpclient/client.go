@@ -0,0 +1,185 @@
+package tcpclient
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrClientClosed is returned by methods of Client after Close.
+	ErrClientClosed = errors.New("client closed")
+)
+
+// A Client maintains a connection to a server, and reconnects automatically
+// when the connection is broken. It is safe for concurrent use.
+type Client struct {
+	// Dialer of connections.
+	Dialer *Dialer
+
+	// Connect callback. It will be called after connected, to run a protocol
+	// handshake for example. If it returns an error, the connection is closed.
+	OnConnect func(conn net.Conn) error
+
+	// Disconnect callback. It will be called after a connection is broken.
+	OnDisconnect func(conn net.Conn, err error)
+
+	// Heartbeat specifies data to write at HeartbeatInterval if it isn't
+	// empty. A failed heartbeat breaks the connection.
+	Heartbeat         []byte
+	HeartbeatInterval time.Duration
+
+	mu     sync.Mutex
+	conn   net.Conn
+	stopCh chan struct{}
+	dial   *clientDial
+	closed bool
+}
+
+// clientDial is a dial in progress. Concurrent calls of Conn wait for it.
+type clientDial struct {
+	doneCh chan struct{}
+	cancel context.CancelFunc
+	err    error
+}
+
+// Conn returns the current connection, or dials a new one if there is no
+// connection. Concurrent calls share a single dial, and it is cancelled by
+// Close.
+func (c *Client) Conn(ctx context.Context) (net.Conn, error) {
+	for {
+		c.mu.Lock()
+		if c.closed {
+			c.mu.Unlock()
+			return nil, ErrClientClosed
+		}
+		if c.conn != nil {
+			conn := c.conn
+			c.mu.Unlock()
+			return conn, nil
+		}
+		if d := c.dial; d != nil {
+			c.mu.Unlock()
+			select {
+			case <-d.doneCh:
+				if d.err != nil {
+					return nil, d.err
+				}
+				continue
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+		dialCtx, cancel := context.WithCancel(ctx)
+		d := &clientDial{
+			doneCh: make(chan struct{}),
+			cancel: cancel,
+		}
+		c.dial = d
+		c.mu.Unlock()
+		conn, err := c.connect(dialCtx)
+		cancel()
+		c.mu.Lock()
+		c.dial = nil
+		if c.closed {
+			if err == nil {
+				conn.Close()
+			}
+			err = ErrClientClosed
+		}
+		if err == nil {
+			c.conn = conn
+			c.stopCh = make(chan struct{})
+			if len(c.Heartbeat) > 0 && c.HeartbeatInterval > 0 {
+				go c.heartbeat(conn, c.stopCh)
+			}
+		}
+		d.err = err
+		close(d.doneCh)
+		c.mu.Unlock()
+		if err != nil {
+			return nil, err
+		}
+		return conn, nil
+	}
+}
+
+// connect dials a new connection, and calls OnConnect.
+func (c *Client) connect(ctx context.Context) (net.Conn, error) {
+	conn, err := c.Dialer.Dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if c.OnConnect != nil {
+		if err = c.OnConnect(conn); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// Do calls f with the current connection. If f returns an error, the
+// connection is broken, and the next call uses a new connection.
+func (c *Client) Do(ctx context.Context, f func(conn net.Conn) error) error {
+	conn, err := c.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	if err = f(conn); err != nil {
+		c.Break(conn, err)
+	}
+	return err
+}
+
+// Break closes the connection if it is the current one, so the next call
+// dials a new connection.
+func (c *Client) Break(conn net.Conn, err error) {
+	c.mu.Lock()
+	if c.conn != conn || conn == nil {
+		c.mu.Unlock()
+		return
+	}
+	c.conn = nil
+	close(c.stopCh)
+	c.mu.Unlock()
+	conn.Close()
+	if c.OnDisconnect != nil {
+		c.OnDisconnect(conn, err)
+	}
+}
+
+// Close closes the client and its connection. A dial in progress is
+// cancelled.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	conn := c.conn
+	c.closed = true
+	if c.dial != nil {
+		c.dial.cancel()
+	}
+	c.mu.Unlock()
+	c.Break(conn, ErrClientClosed)
+	return nil
+}
+
+func (c *Client) heartbeat(conn net.Conn, stopCh chan struct{}) {
+	ticker := time.NewTicker(c.HeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			conn.SetWriteDeadline(time.Now().Add(c.HeartbeatInterval))
+			_, err := conn.Write(c.Heartbeat)
+			conn.SetWriteDeadline(time.Time{})
+			if err != nil {
+				c.Break(conn, err)
+				return
+			}
+		case <-stopCh:
+			return
+		}
+	}
+}
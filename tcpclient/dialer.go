@@ -0,0 +1,133 @@
+// Package tcpclient provides TCP client implementation as companion of
+// tcpserver. It dials with backoff, reconnects automatically, pools
+// connections, and speaks TLS and compression layers of tcpserver.
+package tcpclient
+
+import (
+	"context"
+	"crypto/tls"
+	"math/rand"
+	"net"
+	"time"
+
+	"github.com/orkunkaraduman/go-tcpserver/compress"
+)
+
+// Default values of Dialer parameters.
+var (
+	DefDialTimeout = 10 * time.Second
+	DefMinBackoff  = 100 * time.Millisecond
+	DefMaxBackoff  = 10 * time.Second
+)
+
+// A Dialer defines parameters for dialing a tcpserver.
+type Dialer struct {
+	// Network to dial. If it is empty, "tcp" is used.
+	Network string
+
+	// Address to dial.
+	Addr string
+
+	// TLSConfig optionally provides a TLS configuration.
+	TLSConfig *tls.Config
+
+	// Timeout specifies timeout of an attempt including TLS handshake and
+	// compression negotiation. If it is 0, DefDialTimeout is used.
+	Timeout time.Duration
+
+	// Algorithm of compression to use if Negotiate is false. If it is empty,
+	// connections aren't compressed.
+	Algorithm compress.Algorithm
+
+	// Negotiate enables negotiation of compression algorithm with
+	// compress.Server. Algorithms are offered in order of preference.
+	Negotiate  bool
+	Algorithms []compress.Algorithm
+
+	// MaxAttempts specifies maximum count of attempts. If it is 0, Dial tries
+	// until context is done.
+	MaxAttempts int
+
+	// MinBackoff and MaxBackoff specify bounds of exponential backoff between
+	// attempts. If they are 0, DefMinBackoff and DefMaxBackoff are used.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+}
+
+// Dial dials the server. It retries failed attempts with exponential backoff
+// and jitter until MaxAttempts or ctx is done.
+func (d *Dialer) Dial(ctx context.Context) (conn net.Conn, err error) {
+	minBackoff, maxBackoff := d.MinBackoff, d.MaxBackoff
+	if minBackoff <= 0 {
+		minBackoff = DefMinBackoff
+	}
+	if maxBackoff <= 0 {
+		maxBackoff = DefMaxBackoff
+	}
+	backoff := minBackoff
+	for attempt := 1; ; attempt++ {
+		conn, err = d.dial(ctx)
+		if err == nil {
+			return
+		}
+		if d.MaxAttempts > 0 && attempt >= d.MaxAttempts {
+			return
+		}
+		wait := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		}
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// dial runs a single attempt.
+func (d *Dialer) dial(ctx context.Context) (net.Conn, error) {
+	network := d.Network
+	if network == "" {
+		network = "tcp"
+	}
+	timeout := d.Timeout
+	if timeout <= 0 {
+		timeout = DefDialTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	nd := &net.Dialer{}
+	conn, err := nd.DialContext(ctx, network, d.Addr)
+	if err != nil {
+		return nil, err
+	}
+	if d.TLSConfig != nil {
+		tlsConn := tls.Client(conn, d.TLSConfig)
+		if err = tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tlsConn
+	}
+	if d.Negotiate {
+		var c *compress.Conn
+		deadline, _ := ctx.Deadline()
+		if c, err = compress.Negotiate(conn, d.Algorithms, time.Until(deadline)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return c, nil
+	}
+	if d.Algorithm != "" {
+		c, err := compress.NewConn(conn, d.Algorithm)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return c, nil
+	}
+	return conn, nil
+}
@@ -0,0 +1,153 @@
+package tcpclient
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrPoolClosed is returned by Pool.Get after Close.
+	ErrPoolClosed = errors.New("pool closed")
+)
+
+// A Pool is a pool of connections to a server. It is safe for concurrent use.
+type Pool struct {
+	// Dialer of connections.
+	Dialer *Dialer
+
+	// MaxIdle specifies maximum count of idle connections. If it is 0, idle
+	// connections aren't kept.
+	MaxIdle int
+
+	// MaxOpen specifies maximum count of open connections. Get waits for a
+	// connection to be put back when the limit is reached. If it is 0, there
+	// is no limit.
+	MaxOpen int
+
+	// IdleTimeout specifies duration to close idle connections after. If it
+	// is 0, idle connections aren't closed.
+	IdleTimeout time.Duration
+
+	mu     sync.Mutex
+	idle   []idleConn
+	open   int
+	waitCh chan struct{}
+	closed bool
+}
+
+type idleConn struct {
+	conn net.Conn
+	t    time.Time
+}
+
+// A PoolConn is a connection got from Pool. Close puts it back to the pool.
+type PoolConn struct {
+	net.Conn
+
+	pool   *Pool
+	broken bool
+	once   sync.Once
+}
+
+// MarkBroken marks the connection as broken, so Close closes it instead of
+// putting it back.
+func (pc *PoolConn) MarkBroken() {
+	pc.broken = true
+}
+
+// Close puts the connection back to the pool, or closes it if it is broken.
+func (pc *PoolConn) Close() error {
+	pc.once.Do(func() {
+		pc.pool.put(pc.Conn, pc.broken)
+	})
+	return nil
+}
+
+// NetConn returns the underlying connection.
+func (pc *PoolConn) NetConn() net.Conn {
+	return pc.Conn
+}
+
+// Get returns an idle connection, or dials a new one.
+func (p *Pool) Get(ctx context.Context) (*PoolConn, error) {
+	for {
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			return nil, ErrPoolClosed
+		}
+		for len(p.idle) > 0 {
+			ic := p.idle[len(p.idle)-1]
+			p.idle = p.idle[:len(p.idle)-1]
+			if p.IdleTimeout > 0 && time.Since(ic.t) > p.IdleTimeout {
+				p.open--
+				ic.conn.Close()
+				continue
+			}
+			p.mu.Unlock()
+			return &PoolConn{Conn: ic.conn, pool: p}, nil
+		}
+		if p.MaxOpen <= 0 || p.open < p.MaxOpen {
+			p.open++
+			p.mu.Unlock()
+			break
+		}
+		if p.waitCh == nil {
+			p.waitCh = make(chan struct{})
+		}
+		waitCh := p.waitCh
+		p.mu.Unlock()
+		select {
+		case <-waitCh:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	conn, err := p.Dialer.Dial(ctx)
+	if err != nil {
+		p.mu.Lock()
+		p.open--
+		p.notify()
+		p.mu.Unlock()
+		return nil, err
+	}
+	return &PoolConn{Conn: conn, pool: p}, nil
+}
+
+// Close closes the pool and its idle connections. Connections in use are
+// closed when they are put back.
+func (p *Pool) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	for _, ic := range p.idle {
+		ic.conn.Close()
+		p.open--
+	}
+	p.idle = nil
+	p.notify()
+	return nil
+}
+
+func (p *Pool) put(conn net.Conn, broken bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if broken || p.closed || len(p.idle) >= p.MaxIdle {
+		p.open--
+		conn.Close()
+	} else {
+		p.idle = append(p.idle, idleConn{conn: conn, t: time.Now()})
+	}
+	p.notify()
+}
+
+// notify wakes up waiters of Get. It must be called with lock held.
+func (p *Pool) notify() {
+	if p.waitCh != nil {
+		close(p.waitCh)
+		p.waitCh = nil
+	}
+}
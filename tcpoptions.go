@@ -0,0 +1,75 @@
+package tcpserver
+
+import (
+	"errors"
+	"net"
+	"time"
+)
+
+var (
+	// ErrNotTCPConn is returned when the connection isn't a TCP connection.
+	ErrNotTCPConn = errors.New("not a TCP connection")
+)
+
+// TCPOptions defines socket options of TCP connections.
+type TCPOptions struct {
+	// NoDelay specifies TCP_NODELAY. If it is false, Nagle's algorithm is
+	// enabled. If it is nil, it isn't changed. TCP_NODELAY is set on TCP
+	// connections by default, it suits latency sensitive protocols.
+	NoDelay *bool
+
+	// ReadBuffer specifies SO_RCVBUF in bytes. If it is 0, it isn't changed.
+	ReadBuffer int
+
+	// WriteBuffer specifies SO_SNDBUF in bytes. If it is 0, it isn't changed.
+	WriteBuffer int
+
+	// Linger specifies maximum duration of Close to send unsent data. If it
+	// is 0, it isn't changed.
+	Linger time.Duration
+
+	// ResetOnClose discards unsent data and sends RST on Close. It overrides
+	// Linger.
+	ResetOnClose bool
+}
+
+// UnwrapTCPConn returns the innermost *net.TCPConn of the connection, so
+// socket options can be changed per connection.
+func UnwrapTCPConn(conn net.Conn) (*net.TCPConn, error) {
+	tc, ok := unwrapConn(conn).(*net.TCPConn)
+	if !ok {
+		return nil, ErrNotTCPConn
+	}
+	return tc, nil
+}
+
+// Apply applies the options to the connection.
+func (o *TCPOptions) Apply(conn net.Conn) error {
+	tc, err := UnwrapTCPConn(conn)
+	if err != nil {
+		return err
+	}
+	if o.NoDelay != nil {
+		if err := tc.SetNoDelay(*o.NoDelay); err != nil {
+			return err
+		}
+	}
+	if o.ReadBuffer > 0 {
+		if err := tc.SetReadBuffer(o.ReadBuffer); err != nil {
+			return err
+		}
+	}
+	if o.WriteBuffer > 0 {
+		if err := tc.SetWriteBuffer(o.WriteBuffer); err != nil {
+			return err
+		}
+	}
+	if o.ResetOnClose {
+		return tc.SetLinger(0)
+	}
+	if o.Linger > 0 {
+		sec := int((o.Linger + time.Second - 1) / time.Second)
+		return tc.SetLinger(sec)
+	}
+	return nil
+}
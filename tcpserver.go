@@ -5,36 +5,403 @@ package tcpserver
 import (
 	"context"
 	"crypto/tls"
+	"errors"
 	"io/ioutil"
 	"log"
+	"log/slog"
+	"math/rand"
 	"net"
+	"runtime/debug"
+	"runtime/pprof"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+var (
+	// ErrServerClosed is returned by Serve, ListenAndServe and their variants
+	// after Close or Shutdown method called.
+	ErrServerClosed = errors.New("server closed")
+
+	// ErrConnNotFound is returned when a connection isn't tracked by the
+	// server.
+	ErrConnNotFound = errors.New("connection not found")
+
+	// ErrListenerInUse is returned by Serve and its variants when the
+	// listener is already served by the server.
+	ErrListenerInUse = errors.New("listener already served")
+
+	// ErrServerRunning is returned by Reset when the server has running
+	// listeners or connections.
+	ErrServerRunning = errors.New("server running")
+
+	// ErrHandlerPanic is passed to OnDisconnect when Handler panics.
+	ErrHandlerPanic = errors.New("handler panic")
+)
+
+// DefAcceptStormRate specifies accept rate per second to start accept pacing if
+// TCPServer.AcceptStormRate is 0.
+var DefAcceptStormRate = 100
+
+// DefTLSHandshakeTimeout specifies TLS handshake timeout if
+// TCPServer.TLSHandshakeTimeout is 0.
+var DefTLSHandshakeTimeout = 10 * time.Second
+
+// DefGreetingTimeout specifies write timeout of greeting if
+// TCPServer.GreetingTimeout is 0.
+var DefGreetingTimeout = 10 * time.Second
+
 // A TCPServer defines parameters for running an TCP server.
 type TCPServer struct {
-	// TCP address to listen on.
+	// TCP address to listen on, or unix domain socket address like
+	// unix:///path/to.sock.
 	Addr string
 
-	// Handler to invoke.
+	// Handler to invoke. If it implements HandlerContext, ServeContext is
+	// called instead of Serve. Use ContextHandler to adapt a HandlerContext.
 	Handler Handler
 
 	// TLSConfig optionally provides a TLS configuration.
 	TLSConfig *tls.Config
 
+	// ClientAuth optionally specifies TLS client authentication policy for
+	// ServeTLS and ListenAndServeTLS. Handlers can get identity of clients by
+	// PeerCertificate and VerifiedChains.
+	ClientAuth *ClientAuthPolicy
+
+	// NextProtoHandlers optionally specifies handlers by ALPN protocol. After
+	// TLS handshake, connections with a negotiated protocol in it are served
+	// by its handler instead of Handler and SNI handlers. ServeTLS adds the
+	// protocols to NextProtos of TLS configuration if it is empty.
+	NextProtoHandlers map[string]Handler
+
+	// TLSHandshakeTimeout specifies maximum duration of TLS handshake of
+	// connections. The connection is closed if it is exceeded. If it is 0,
+	// DefTLSHandshakeTimeout is used.
+	TLSHandshakeTimeout time.Duration
+
+	// TLSHandshakeErrorHandler optionally is called with a *TLSHandshakeError
+	// when TLS handshake of a connection fails, before closing it. If it is
+	// nil, the error is logged to Logger or ErrorLog.
+	TLSHandshakeErrorHandler func(conn net.Conn, err error)
+
+	// PanicHandler optionally is called with the recovered value and the
+	// stack trace when Handler panics, before closing the connection. It can
+	// write a protocol-level error to the peer. It is called with the
+	// connection passed to Handler. If it is nil, the panic is logged to
+	// Logger or ErrorLog.
+	PanicHandler func(conn net.Conn, recovered interface{}, stack []byte)
+
 	// ErrorLog specifies an optional logger for errors in Handler.
 	ErrorLog *log.Logger
 
-	l       net.Listener
-	conns   map[net.Conn]connContext
-	connsMu sync.RWMutex
-	closeCh chan struct{}
+	// Logger optionally specifies a structured logger for events of the
+	// server, like accept errors, handshake failures, handler panics and
+	// shutdown progress, with attributes of connections. It takes precedence
+	// over ErrorLog. Use SlogLogger for a *slog.Logger.
+	Logger Logger
+
+	// AccessLog optionally specifies a function called with an entry per
+	// connection when the connection is closed. Use AccessLogger to log entries
+	// by a Logger.
+	AccessLog func(e *AccessLogEntry)
+
+	// Greeting optionally specifies data to write to connections immediately
+	// after accept, before invoking Handler. On TLS connections, it is written
+	// after handshake.
+	Greeting []byte
+
+	// GreetingFunc optionally returns greeting of the connection. It overrides
+	// Greeting if it isn't nil.
+	GreetingFunc func(conn net.Conn) []byte
+
+	// GreetingTimeout specifies write timeout of greeting. TLS handshake is
+	// done before writing greeting, and it is bounded by TLSHandshakeTimeout.
+	// If it is 0, DefGreetingTimeout is used.
+	GreetingTimeout time.Duration
+
+	// CountBytes enables counting bytes and operations of connections. If it
+	// is true, Handler is invoked with *CountingConn. Counters are reported by
+	// Connections and in the log of closed connections.
+	CountBytes bool
+
+	// EstimateBandwidth enables throughput estimation of connections. If it
+	// is true, the connection passed to Handler wraps a *BandwidthConn, so
+	// ConnBandwidth reports its rates. Rates are reported by Connections.
+	EstimateBandwidth bool
+
+	// BandwidthWindow specifies smoothing window of throughput estimation. If
+	// it is 0, DefBandwidthWindow is used.
+	BandwidthWindow time.Duration
+
+	// DrainTimeout specifies maximum duration of drain phase of Shutdown. If
+	// it is 0, there is no drain phase.
+	DrainTimeout time.Duration
+
+	// ShutdownTimeout specifies maximum duration of graceful phase of Shutdown
+	// by ListenAndServeContext, before the drain phase. If it is 0, Shutdown
+	// waits for connections indefinitely.
+	ShutdownTimeout time.Duration
+
+	// AcceptJitter enables accept pacing during connection storms. If accept
+	// rate of a listener exceeds AcceptStormRate, serving of each new
+	// connection including TLS handshake is delayed randomly up to
+	// AcceptJitter. So mass reconnects are spread. If it is 0, there is no
+	// pacing.
+	AcceptJitter time.Duration
+
+	// AcceptStormRate specifies accept rate per second to start accept pacing.
+	// If it is 0, DefAcceptStormRate is used.
+	AcceptStormRate int
+
+	// AcceptFilter optionally is called in the accept loop right after
+	// accepting each connection, before spawning its goroutine. If it returns
+	// an error, the connection is closed. It shouldn't block long. If
+	// ProxyProtocol is enabled, RemoteAddr of the connection reads the PROXY
+	// header, so it should be avoided or checked in Handler instead.
+	AcceptFilter func(conn net.Conn) error
+
+	// IdleTimeout specifies maximum duration of connections without read or
+	// write activity on the connection passed to Handler. Idle connections
+	// are closed by a background reaper. If it is 0, there is no timeout.
+	IdleTimeout time.Duration
+
+	// ReadTimeout specifies read timeout of the connection passed to Handler.
+	// The read deadline is refreshed on each Read, unless an earlier
+	// deadline is set by the Handler. If it is 0, there is no timeout.
+	ReadTimeout time.Duration
+
+	// WriteTimeout specifies write timeout of the connection passed to
+	// Handler. The write deadline is refreshed on each Write, unless an
+	// earlier deadline is set by the Handler. If it is 0, there is no timeout.
+	WriteTimeout time.Duration
+
+	// FirstByteTimeout specifies maximum duration to wait for the first byte
+	// of application data after TCP and TLS setup. Connections not sending
+	// any data within it are closed. Handler is invoked without waiting, so
+	// protocols where the server speaks first keep working. If it is 0, there
+	// is no timeout.
+	FirstByteTimeout time.Duration
+
+	// MaxAcceptsPerSecond limits rate of accepting connections of all
+	// listeners. Excess connections wait in backlog of the listeners. If it
+	// is 0, there is no limit.
+	MaxAcceptsPerSecond float64
+
+	// AcceptBurst specifies burst size of MaxAcceptsPerSecond. If it is 0,
+	// MaxAcceptsPerSecond is used.
+	AcceptBurst int
+
+	// MaxReadBytesPerSecond limits aggregate read rate of all connections in
+	// bytes. Reads are charged in DefBandwidthQuantum chunks, so connections
+	// share the cap fairly. If it is 0, there is no limit.
+	MaxReadBytesPerSecond int
+
+	// MaxWriteBytesPerSecond limits aggregate write rate of all connections
+	// in bytes, like MaxReadBytesPerSecond. If it is 0, there is no limit.
+	MaxWriteBytesPerSecond int
+
+	// WorkerPool optionally serves connections by a bounded pool of
+	// goroutines instead of a goroutine per connection. It is started by
+	// Serve if it isn't started.
+	WorkerPool *WorkerPool
+
+	// MaxConns specifies maximum count of concurrent connections. When it is
+	// reached, new connections are handled by OverflowPolicy. If it is
+	// AutoMaxConns, the limit is derived from file descriptor budget of the
+	// process. If it is 0, there is no limit. Serve warns if it exceeds the
+	// file descriptor budget.
+	MaxConns int
+
+	// OverflowPolicy specifies the behavior when MaxConns is reached.
+	OverflowPolicy OverflowPolicy
+
+	// OverflowResponse specifies data to write to new connections before
+	// closing for OverflowReject policy, like a busy message.
+	OverflowResponse []byte
+
+	// IPFilter optionally filters connections by remote IP address before
+	// TLS handshake. If ProxyProtocol is enabled, the source address of the
+	// PROXY header is used. It can be updated at runtime.
+	IPFilter *IPFilter
+
+	// MaxConnsPerIP specifies maximum count of concurrent connections of a
+	// remote IP address. Connections exceeding the limit are closed before
+	// TLS handshake. If ProxyProtocol is enabled, the source address of the
+	// PROXY header is used. If it is 0, there is no limit.
+	MaxConnsPerIP int
+
+	// MaxConnsPerIPWait specifies maximum duration to wait for a free slot
+	// of the IP address before closing connections exceeding MaxConnsPerIP.
+	// If it is 0, they are closed immediately.
+	MaxConnsPerIPWait time.Duration
+
+	// ProxyProtocol enables reading PROXY protocol v1 and v2 headers of
+	// connections, for running behind a proxy like HAProxy. RemoteAddr of
+	// connections returns the original client address. Connections without a
+	// header are served as is unless ProxyProtocolStrict is true. The header
+	// is read on first call of Read or RemoteAddr of connections.
+	ProxyProtocol bool
+
+	// ProxyProtocolStrict makes connections without a PROXY protocol header
+	// rejected.
+	ProxyProtocolStrict bool
+
+	// ProxyHeaderTimeout specifies read timeout of PROXY protocol headers. If
+	// it is 0, DefProxyHeaderTimeout is used.
+	ProxyHeaderTimeout time.Duration
+
+	// KeepAlive enables TCP keepalive on accepted connections with
+	// KeepAlivePeriod, KeepAliveInterval and KeepAliveCount, so half-open
+	// connections of crashed clients are detected. If it is false, defaults
+	// of the listener are kept.
+	KeepAlive bool
+
+	// KeepAlivePeriod specifies idle time before the first keepalive probe.
+	// If it is 0, the OS default or 15 seconds is used.
+	KeepAlivePeriod time.Duration
+
+	// KeepAliveInterval specifies interval between keepalive probes. If it is
+	// 0, the OS default or 15 seconds is used. It needs Go 1.23 or later.
+	KeepAliveInterval time.Duration
+
+	// KeepAliveCount specifies count of unacknowledged probes before closing.
+	// If it is 0, the OS default or 9 is used. It needs Go 1.23 or later.
+	KeepAliveCount int
+
+	// TCPOptions optionally specifies default socket options of accepted TCP
+	// connections. Handlers can change them per connection by
+	// UnwrapTCPConn or Apply method of another TCPOptions.
+	TCPOptions *TCPOptions
+
+	// Addrs specifies additional addresses to listen on by ListenAndServe
+	// and ListenAndServeTLS, like Addr.
+	Addrs []string
+
+	// UnixSocketOptions specifies options of the socket file if Addr is a
+	// unix domain socket address like unix:///path/to.sock. If it is nil,
+	// only stale socket files are removed on start. The socket file is
+	// removed when the listener is closed.
+	UnixSocketOptions *UnixSocketOptions
+
+	// ListenConfig optionally specifies the net.ListenConfig used by
+	// ListenAndServe and ListenAndServeTLS. Its Control function can set
+	// socket options like SO_REUSEADDR, buffer sizes or IP_FREEBIND before
+	// bind.
+	ListenConfig *net.ListenConfig
+
+	// ReusePort specifies count of listeners opened with SO_REUSEPORT on
+	// the same address by ListenAndServe and ListenAndServeTLS. Each listener
+	// has its own accept loop, and the kernel distributes connections among
+	// them. If it is negative, count of CPUs is used. If it is 0,
+	// SO_REUSEPORT isn't set.
+	ReusePort int
+
+	// WaitForAddr makes ListenAndServe and ListenAndServeTLS wait for the
+	// address to appear on network interfaces instead of failing, if the
+	// address isn't available yet. For example a virtual IP after failover,
+	// or an address assigned by DHCP.
+	WaitForAddr bool
+
+	// BaseContext optionally specifies a function that returns the base
+	// context of connections of the listener. If it is nil, the base context is
+	// context.Background().
+	BaseContext func(l net.Listener) context.Context
+
+	// ConnContext optionally specifies a function that modifies the context of
+	// a new connection, to inject connection scoped values for example. The
+	// provided ctx is derived from the base context. It is called with the
+	// connection passed to Handler. The context is passed to ServeContext of
+	// HandlerContext, and it can be got by Context method.
+	ConnContext func(ctx context.Context, conn net.Conn) context.Context
+
+	// CancelOnShutdown enables cancelling the context of each connection when
+	// Shutdown or Close is called, in addition to filling closeCh. Handlers
+	// can watch the context got by Context method.
+	CancelOnShutdown bool
+
+	// InterruptReadsOnShutdown enables expiring the read deadline of each
+	// connection when Shutdown is called, so handlers blocking on reads wake
+	// up with a timeout error. Writes keep working.
+	InterruptReadsOnShutdown bool
+
+	// ProfilerLabels enables pprof labels of handler goroutines, with remote
+	// address, SNI server name and type of Handler, so profiles can be
+	// attributed to peers and protocols. Contexts passed to HandlerContext
+	// carry the labels.
+	ProfilerLabels bool
+
+	// OnConnect optionally is called for each connection before TLS handshake
+	// and Handler, after checks of PROXY protocol header and IP limits. The
+	// connection is closed if it returns false. It is called with the
+	// connection passed to Handler.
+	OnConnect func(conn net.Conn) (accept bool)
+
+	// OnDisconnect optionally is called when a connection accepted by
+	// OnConnect is closed, with the duration of the connection. The error is
+	// nil if Handler returned, otherwise it is a *CloseError with the reason
+	// ended the connection. It isn't called for hijacked connections.
+	OnDisconnect func(conn net.Conn, err error, d time.Duration)
+
+	// ConnState optionally specifies a hook called when a connection changes
+	// state. It is called with the connection passed to Handler.
+	ConnState func(conn net.Conn, state ConnState)
+
+	// OnDrain optionally is called for each connection when Shutdown is
+	// called or drain mode is entered, before filling closeCh. Handlers can
+	// send a protocol-level going away message in it. It is called with the
+	// connection passed to Handler, and it shouldn't block long.
+	OnDrain func(conn net.Conn)
+
+	sniMu       sync.RWMutex
+	sniHandlers map[string]Handler
+
+	middlewaresMu sync.RWMutex
+	middlewares   []Middleware
+	handlerPtr    atomic.Pointer[Handler]
+	reloaded      atomic.Pointer[ServerConfig]
+	configCert    atomic.Pointer[CertReloader]
+
+	listeners   map[net.Listener]net.Listener
+	conns       map[net.Conn]*connContext
+	connsDoneCh chan struct{}
+	closedCh    chan struct{}
+	slotFreedCh chan struct{}
+	slotWaiters int32
+	closed      int32
+	draining    int32
+	active      int32
+	serving     int32
+	nextConnID  atomic.Uint64
+	counters    counters
+	reaping     int32
+	acceptBkt   *tokenBucket
+	rdBkt       *tokenBucket
+	wrBkt       *tokenBucket
+	ipConns     map[string]int
+	ipFreedCh   chan struct{}
+	ipConnsMu   sync.Mutex
+	connsMu     sync.RWMutex
 }
 
 type connContext struct {
-	conn    net.Conn
-	closeCh chan struct{}
+	lastActive  int64 // first for 64-bit alignment of atomic access.
+	id          uint64
+	start       time.Time
+	conn        net.Conn
+	hconn       atomic.Pointer[net.Conn]
+	closeCh     chan struct{}
+	ctx         context.Context
+	cancel      context.CancelFunc
+	hijacked    int32
+	state       int32
+	counter     *CountingConn
+	bandwidth   *BandwidthConn
+	idle        bool
+	closeReason int32
+	peerErr     atomic.Pointer[error]
 }
 
 // Shutdown gracefully shuts down the server without interrupting any
@@ -45,43 +412,151 @@ type connContext struct {
 // context's error, otherwise it returns any error returned from closing the
 // Server's underlying Listener(s).
 //
+// If DrainTimeout is set, when the provided context expires, Shutdown stops
+// reads of remaining connections by expiring their read deadlines but keeps
+// writes working. So handlers can flush pending writes while returning.
+// Remaining connections are closed after DrainTimeout.
+//
 // When Shutdown is called, Serve, ListenAndServe, and ListenAndServeTLS
-// immediately return nil. Make sure the program doesn't exit and waits
-// instead for Shutdown to return.
-func (srv *TCPServer) Shutdown(ctx context.Context) (err error) {
-	err = srv.l.Close()
+// immediately return ErrServerClosed. Make sure the program doesn't exit and
+// waits instead for Shutdown to return.
+func (srv *TCPServer) Shutdown(ctx context.Context) error {
+	return srv.shutdown(ctx, srv.drainTimeout())
+}
+
+// ShutdownWithTimeout shuts down the server in phases. First it shuts down
+// gracefully like Shutdown during grace. If connections remain, it stops their
+// reads by expiring read deadlines, so blocking handlers wake up and can flush
+// pending writes during force. Finally it closes the remaining connections. It
+// returns context.DeadlineExceeded if grace expires.
+func (srv *TCPServer) ShutdownWithTimeout(grace, force time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), grace)
+	defer cancel()
+	return srv.shutdown(ctx, force)
+}
+
+func (srv *TCPServer) shutdown(ctx context.Context, drainTimeout time.Duration) (err error) {
+	start := time.Now()
+	err = srv.closeListeners()
+	srv.log(nil, slog.LevelInfo, "shutdown started", slog.Int("conns", int(atomic.LoadInt32(&srv.active))))
+	srv.notifyDrain()
+
+	srv.connsMu.RLock()
+	for _, c := range srv.conns {
+		srv.signalClose(c)
+	}
+	srv.connsMu.RUnlock()
+
+	select {
+	case <-srv.connsDone():
+		srv.log(nil, slog.LevelInfo, "shutdown completed", slog.Duration("duration", time.Since(start)))
+	case <-ctx.Done():
+		srv.log(nil, slog.LevelWarn, "shutdown timed out, draining connections",
+			slog.Int("conns", int(atomic.LoadInt32(&srv.active))), slog.Duration("drain_timeout", drainTimeout))
+		srv.drain(drainTimeout)
+		srv.connsMu.RLock()
+		for _, c := range srv.conns {
+			c.forceCloseReason(CloseForced)
+			c.conn.Close()
+		}
+		srv.connsMu.RUnlock()
+		err = ctx.Err()
+	}
+	return
+}
+
+// signalClose fills closeCh of the connection. If CancelOnShutdown is true, it
+// cancels the context of the connection. If InterruptReadsOnShutdown is true,
+// it expires the read deadline of the connection.
+func (srv *TCPServer) signalClose(c *connContext) {
+	c.setCloseReason(CloseShutdown)
 	select {
-	case srv.closeCh <- struct{}{}:
+	case c.closeCh <- struct{}{}:
 	default:
 	}
+	if c.cancel != nil {
+		c.cancel()
+	}
+	if srv.InterruptReadsOnShutdown {
+		c.handlerConn().SetReadDeadline(time.Now())
+	}
+}
 
+// connsDone returns a channel closed when there is no active connection,
+// including accepted connections not served yet.
+func (srv *TCPServer) connsDone() <-chan struct{} {
+	srv.connsMu.Lock()
+	defer srv.connsMu.Unlock()
+	if atomic.LoadInt32(&srv.active) == 0 {
+		ch := make(chan struct{})
+		close(ch)
+		return ch
+	}
+	if srv.connsDoneCh == nil {
+		srv.connsDoneCh = make(chan struct{})
+	}
+	return srv.connsDoneCh
+}
+
+// releaseActive decrements count of active connections, wakes up waiters of
+// waitConnSlot, and closes the channel of connsDone if there is no active
+// connection.
+func (srv *TCPServer) releaseActive() {
+	if atomic.AddInt32(&srv.active, -1) != 0 && atomic.LoadInt32(&srv.slotWaiters) == 0 {
+		return
+	}
+	srv.connsMu.Lock()
+	if srv.slotFreedCh != nil {
+		close(srv.slotFreedCh)
+		srv.slotFreedCh = nil
+	}
+	if atomic.LoadInt32(&srv.active) == 0 && srv.connsDoneCh != nil {
+		close(srv.connsDoneCh)
+		srv.connsDoneCh = nil
+	}
+	srv.connsMu.Unlock()
+}
+
+// notifyDrain calls OnDrain for each connection.
+func (srv *TCPServer) notifyDrain() {
+	if srv.OnDrain == nil {
+		return
+	}
 	srv.connsMu.RLock()
+	conns := make([]net.Conn, 0, len(srv.conns))
 	for _, c := range srv.conns {
-		select {
-		case c.closeCh <- struct{}{}:
-		default:
+		if hconn := c.handlerConn(); hconn != nil {
+			conns = append(conns, hconn)
 		}
 	}
 	srv.connsMu.RUnlock()
+	for _, conn := range conns {
+		srv.OnDrain(conn)
+	}
+}
 
-	for {
-		select {
-		case <-time.After(5 * time.Millisecond):
-			srv.connsMu.RLock()
-			if len(srv.conns) == 0 {
-				srv.connsMu.RUnlock()
-				return
-			}
-			srv.connsMu.RUnlock()
-		case <-ctx.Done():
-			srv.connsMu.RLock()
-			for _, c := range srv.conns {
-				c.conn.Close()
-			}
-			srv.connsMu.RUnlock()
-			err = ctx.Err()
-			return
-		}
+// drain stops reads of connections, and waits for connections to close until
+// timeout passes.
+func (srv *TCPServer) drain(timeout time.Duration) {
+	if timeout <= 0 {
+		return
+	}
+	now := time.Now()
+	srv.connsMu.RLock()
+	for _, c := range srv.conns {
+		// deadlines are set through wrappers of the connection, so they
+		// aren't refreshed by ReadTimeout and WriteTimeout.
+		hconn := c.handlerConn()
+		hconn.SetReadDeadline(now)
+		hconn.SetWriteDeadline(now.Add(timeout))
+	}
+	srv.connsMu.RUnlock()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-srv.connsDone():
+	case <-timer.C:
 	}
 }
 
@@ -90,19 +565,15 @@ func (srv *TCPServer) Shutdown(ctx context.Context) (err error) {
 //
 // Close returns any error returned from closing the Server's underlying
 // Listener(s).
+//
+// After Close or Shutdown, the server can serve again only after Reset.
 func (srv *TCPServer) Close() (err error) {
-	err = srv.l.Close()
-	select {
-	case srv.closeCh <- struct{}{}:
-	default:
-	}
+	err = srv.closeListeners()
 
 	srv.connsMu.RLock()
 	for _, c := range srv.conns {
-		select {
-		case c.closeCh <- struct{}{}:
-		default:
-		}
+		srv.signalClose(c)
+		c.forceCloseReason(CloseForced)
 		c.conn.Close()
 	}
 	srv.connsMu.RUnlock()
@@ -110,123 +581,792 @@ func (srv *TCPServer) Close() (err error) {
 	return
 }
 
-// ListenAndServe listens on the TCP network address srv.Addr and then calls
-// Serve to handle requests on incoming connections. ListenAndServe returns a
-// nil error after Close or Shutdown method called.
-func (srv *TCPServer) ListenAndServe() error {
-	addr := srv.Addr
-	l, err := net.Listen("tcp", addr)
-	if err != nil {
-		return err
+// closeListeners marks the server as closed and closes all listeners.
+func (srv *TCPServer) closeListeners() (err error) {
+	atomic.StoreInt32(&srv.closed, 1)
+	srv.connsMu.Lock()
+	defer srv.connsMu.Unlock()
+	if srv.closedCh == nil {
+		srv.closedCh = make(chan struct{})
+	}
+	select {
+	case <-srv.closedCh:
+	default:
+		close(srv.closedCh)
+	}
+	for key, l := range srv.listeners {
+		if e := l.Close(); e != nil && err == nil {
+			err = e
+		}
+		delete(srv.listeners, key)
+	}
+	return
+}
+
+// Reset makes the server reusable after Close or Shutdown, so it can serve
+// again. It returns ErrServerRunning if any Serve hasn't returned yet or any
+// connection is still being served.
+func (srv *TCPServer) Reset() error {
+	srv.connsMu.Lock()
+	defer srv.connsMu.Unlock()
+	if len(srv.listeners) > 0 || len(srv.conns) > 0 ||
+		atomic.LoadInt32(&srv.serving) != 0 || atomic.LoadInt32(&srv.active) != 0 {
+		return ErrServerRunning
 	}
-	return srv.Serve(l)
+	atomic.StoreInt32(&srv.closed, 0)
+	atomic.StoreInt32(&srv.draining, 0)
+	srv.closedCh = nil
+	srv.acceptBkt, srv.rdBkt, srv.wrBkt = nil, nil, nil
+	srv.ipConns = nil
+	return nil
+}
+
+// ListenAndServe listens on the network addresses srv.Addr and srv.Addrs and
+// then calls Serve to handle requests on incoming connections. ListenAndServe
+// returns ErrServerClosed after Close or Shutdown method called.
+func (srv *TCPServer) ListenAndServe() error {
+	return srv.listenAndServe("tcp", srv.Serve)
 }
 
-// ListenAndServeTLS listens on the TCP network address srv.Addr and
-// then calls Serve to handle requests on incoming TLS connections.
+// ListenAndServeTLS listens on the network addresses srv.Addr and srv.Addrs
+// and then calls Serve to handle requests on incoming TLS connections.
 //
 // Filenames containing a certificate and matching private key for the
 // server must be provided if neither the Server's TLSConfig.Certificates
 // nor TLSConfig.GetCertificate are populated. If the certificate is
 // signed by a certificate authority, the certFile should be the
 // concatenation of the server's certificate, any intermediates, and
-// the CA's certificate.
+// the CA's certificate. If TLSConfig is nil, DefaultTLSConfig is used.
 func (srv *TCPServer) ListenAndServeTLS(certFile, keyFile string) error {
-	addr := srv.Addr
-	l, err := net.Listen("tcp", addr)
-	if err != nil {
-		return err
-	}
-	return srv.ServeTLS(l, certFile, keyFile)
+	return srv.listenAndServe("tcp", func(l net.Listener) error {
+		return srv.ServeTLS(l, certFile, keyFile)
+	})
 }
 
 // Serve accepts incoming connections on the Listener l, creating a new service
 // goroutine for each. The service goroutines read requests and then call
-// srv.Handler to reply to them. Serve returns a nil error after Close or
-// Shutdown method called.
+// srv.Handler to reply to them. Serve returns ErrServerClosed after Close or
+// Shutdown method called, and an *AcceptError if accepting fails.
 func (srv *TCPServer) Serve(l net.Listener) (err error) {
-	srv.l = l
-	srv.conns = make(map[net.Conn]connContext)
-	srv.closeCh = make(chan struct{}, 1)
+	return srv.ServeListener(l, nil)
+}
+
+// ServeListener is like Serve, but it overrides parameters of the server for
+// connections accepted on the Listener l by lc. If lc is nil, parameters of
+// the server are used. Serve and ServeListener can be called concurrently with
+// different listeners. Shutdown and Close close all of them, and Shutdown waits
+// for connections of all of them, including accepted ones not served yet.
+func (srv *TCPServer) ServeListener(l net.Listener, lc *ListenerConfig) (err error) {
+	atomic.AddInt32(&srv.serving, 1)
+	defer atomic.AddInt32(&srv.serving, -1)
+	if lc == nil {
+		lc = &ListenerConfig{}
+	}
+	key := l
+	if srv.ProxyProtocol {
+		l = &proxyListener{
+			Listener: l,
+			strict:   srv.ProxyProtocolStrict,
+			timeout:  srv.ProxyHeaderTimeout,
+		}
+	}
+	if lc.TLSConfig != nil {
+		l = tls.NewListener(l, lc.TLSConfig)
+	}
+	srv.connsMu.Lock()
+	if atomic.LoadInt32(&srv.closed) != 0 {
+		srv.connsMu.Unlock()
+		return ErrServerClosed
+	}
+	if _, ok := srv.listeners[key]; ok {
+		srv.connsMu.Unlock()
+		return ErrListenerInUse
+	}
+	if srv.listeners == nil {
+		srv.listeners = make(map[net.Listener]net.Listener)
+	}
+	if srv.conns == nil {
+		srv.conns = make(map[net.Conn]*connContext)
+	}
+	srv.listeners[key] = l
+	srv.connsMu.Unlock()
 	defer func() {
-		srv.l.Close()
+		l.Close()
+		srv.connsMu.Lock()
+		if srv.listeners[key] == l {
+			delete(srv.listeners, key)
+		}
+		srv.connsMu.Unlock()
 	}()
+	baseCtx := context.Background()
+	if srv.BaseContext != nil {
+		baseCtx = srv.BaseContext(l)
+		if baseCtx == nil {
+			panic("BaseContext returned a nil context")
+		}
+	}
+	ls := &listenerState{
+		config: lc,
+		ctx:    baseCtx,
+	}
+	if srv.WorkerPool != nil {
+		srv.WorkerPool.Start()
+	}
+	maxConns := srv.maxConns()
+	srv.checkMaxConns(maxConns)
+	reloaded := srv.reloaded.Load()
+	acceptBkt := srv.acceptBucket()
+	closedCh := srv.closedChan()
+	var fdWarned time.Time
+	var stormStart time.Time
+	var stormCount int
 	for {
+		if c := srv.reloaded.Load(); c != reloaded {
+			reloaded = c
+			maxConns = srv.maxConns()
+		}
+		if maxConns > 0 && srv.OverflowPolicy == OverflowBackpressure && !srv.waitConnSlot(maxConns, closedCh) {
+			err = ErrServerClosed
+			return
+		}
+		if acceptBkt != nil {
+			acceptBkt.wait(1)
+		}
 		var conn net.Conn
 		conn, err = l.Accept()
 		if err != nil {
-			select {
-			case <-srv.closeCh:
-				err = nil
+			if atomic.LoadInt32(&srv.closed) != 0 {
+				err = ErrServerClosed
 				return
-			default:
+			}
+			if isFDExhausted(err) && time.Since(fdWarned) >= 1*time.Second {
+				fdWarned = time.Now()
+				srv.logFDExhausted(err)
 			}
 			if ne, ok := err.(net.Error); ok && ne.Temporary() {
 				time.Sleep(5 * time.Millisecond)
 				continue
 			}
+			err = &AcceptError{Err: err}
 			return
 		}
-		go srv.serve(conn)
+		srv.counters.accepted.Add(1)
+		if srv.Draining() {
+			srv.counters.rejected.Add(1)
+			conn.Close()
+			continue
+		}
+		if srv.AcceptFilter != nil && srv.AcceptFilter(conn) != nil {
+			srv.counters.rejected.Add(1)
+			conn.Close()
+			continue
+		}
+		if maxConns > 0 && int(atomic.LoadInt32(&srv.active)) >= maxConns {
+			srv.counters.rejected.Add(1)
+			go srv.overflow(conn, srv.OverflowPolicy)
+			continue
+		}
+		if !ls.accept(conn) {
+			srv.counters.rejected.Add(1)
+			conn.Close()
+			continue
+		}
+		atomic.AddInt32(&srv.active, 1)
+		if srv.isClosed() {
+			// Shutdown may have seen no active connection.
+			srv.releaseActive()
+			ls.release()
+			conn.Close()
+			continue
+		}
+		var delay time.Duration
+		if srv.AcceptJitter > 0 {
+			now := time.Now()
+			if now.Sub(stormStart) >= 1*time.Second {
+				stormStart, stormCount = now, 0
+			}
+			stormCount++
+			stormRate := srv.AcceptStormRate
+			if stormRate <= 0 {
+				stormRate = DefAcceptStormRate
+			}
+			if stormCount > stormRate {
+				delay = time.Duration(rand.Int63n(int64(srv.AcceptJitter)))
+			}
+		}
+		if srv.WorkerPool == nil {
+			go srv.serve(conn, ls, delay)
+			continue
+		}
+		if !srv.WorkerPool.submit(func() { srv.serve(conn, ls, delay) }, closedCh) {
+			srv.releaseActive()
+			ls.release()
+			go srv.overflow(conn, srv.WorkerPool.Policy)
+		}
+	}
+}
+
+// isClosed reports whether the server is closed.
+func (srv *TCPServer) isClosed() bool {
+	return atomic.LoadInt32(&srv.closed) != 0
+}
+
+// closedChan returns a channel closed when the server is closed.
+func (srv *TCPServer) closedChan() <-chan struct{} {
+	srv.connsMu.Lock()
+	defer srv.connsMu.Unlock()
+	if srv.closedCh == nil {
+		srv.closedCh = make(chan struct{})
+	}
+	return srv.closedCh
+}
+
+// Drain puts the server into drain mode. In drain mode, new connections are
+// closed immediately after accept, and existing connections are served.
+// OnDrain is called for existing connections when drain mode is entered.
+func (srv *TCPServer) Drain() {
+	if atomic.CompareAndSwapInt32(&srv.draining, 0, 1) {
+		srv.notifyDrain()
+	}
+}
+
+// Resume puts the server out of drain mode.
+func (srv *TCPServer) Resume() {
+	atomic.StoreInt32(&srv.draining, 0)
+}
+
+// Draining reports whether the server is in drain mode.
+func (srv *TCPServer) Draining() bool {
+	return atomic.LoadInt32(&srv.draining) != 0
+}
+
+// DefaultTLSConfig returns a new TLS configuration with sane defaults, minimum
+// TLS 1.2 and forward secret AEAD cipher suites. ServeTLS uses it if TLSConfig
+// is nil.
+func DefaultTLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		CipherSuites: []uint16{
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+		},
 	}
 }
 
 // ServeTLS accepts incoming connections on the Listener l, creating a
 // new service goroutine for each. The service goroutines read requests and
-// then call srv.Handler to reply to them. ServeTLS returns a nil error after
-// Close or Shutdown method called.
+// then call srv.Handler to reply to them. ServeTLS returns ErrServerClosed
+// after Close or Shutdown method called.
 //
 // Additionally, files containing a certificate and matching private key for
 // the server must be provided if neither the Server's TLSConfig.Certificates
-// nor TLSConfig.GetCertificate are populated.. If the certificate is signed by
+// nor TLSConfig.GetCertificate are populated. If the certificate is signed by
 // a certificate authority, the certFile should be the concatenation of the
 // server's certificate, any intermediates, and the CA's certificate.
+//
+// TLSConfig is copied and isn't modified. If it is nil, DefaultTLSConfig is
+// used.
 func (srv *TCPServer) ServeTLS(l net.Listener, certFile, keyFile string) (err error) {
-	config := srv.TLSConfig
-	if config == nil {
-		config = &tls.Config{}
-	}
-	configHasCert := len(config.Certificates) > 0 || config.GetCertificate != nil
-	if !configHasCert || certFile != "" || keyFile != "" {
+	config := srv.tlsConfig()
+	if !hasCert(config) || certFile != "" || keyFile != "" {
 		config.Certificates = make([]tls.Certificate, 1)
 		config.Certificates[0], err = tls.LoadX509KeyPair(certFile, keyFile)
 		if err != nil {
 			return
 		}
 	}
-	tlsListener := tls.NewListener(l, config)
-	return srv.Serve(tlsListener)
+	return srv.ServeListener(l, &ListenerConfig{
+		TLSConfig: config,
+	})
+}
+
+// tlsConfig returns a copy of TLSConfig, or DefaultTLSConfig if it is nil,
+// with ClientAuth and NextProtoHandlers applied.
+func (srv *TCPServer) tlsConfig() *tls.Config {
+	var config *tls.Config
+	if srv.TLSConfig != nil {
+		config = srv.TLSConfig.Clone()
+	} else {
+		config = DefaultTLSConfig()
+	}
+	if srv.ClientAuth != nil {
+		srv.ClientAuth.apply(config)
+	}
+	if len(config.NextProtos) == 0 {
+		for proto := range srv.NextProtoHandlers {
+			config.NextProtos = append(config.NextProtos, proto)
+		}
+		sort.Strings(config.NextProtos)
+	}
+	return config
 }
 
-func (srv *TCPServer) serve(conn net.Conn) {
+// hasCert reports whether the TLS configuration has a certificate.
+func hasCert(config *tls.Config) bool {
+	return len(config.Certificates) > 0 || config.GetCertificate != nil
+}
+
+func (srv *TCPServer) serve(conn net.Conn, ls *listenerState, delay time.Duration) {
+	defer ls.release()
+
 	closeCh := make(chan struct{}, 1)
 
-	srv.connsMu.Lock()
-	srv.conns[conn] = connContext{
+	srv.setKeepAlive(conn)
+	if srv.TCPOptions != nil {
+		srv.TCPOptions.Apply(conn)
+	}
+
+	cc := &connContext{
+		id:      srv.nextConnID.Add(1),
+		start:   time.Now(),
 		conn:    conn,
 		closeCh: closeCh,
 	}
+	defer func() {
+		// hijacked connections are released by Hijack.
+		if atomic.LoadInt32(&cc.hijacked) == 0 {
+			srv.releaseActive()
+		}
+	}()
+	cc.touch()
+	hconn := srv.wrapConn(conn)
+	cc.bandwidth = findBandwidthConn(hconn)
+	var fbConn *firstByteConn
+	if srv.FirstByteTimeout > 0 {
+		fbConn = &firstByteConn{Conn: hconn}
+		hconn = fbConn
+	}
+	if srv.idleTimeout() > 0 {
+		hconn = &idleConn{Conn: hconn, cc: cc}
+		cc.idle = true
+	}
+	if srv.CountBytes || srv.AccessLog != nil {
+		cc.counter = &CountingConn{Conn: hconn, cc: cc}
+		hconn = cc.counter
+	}
+	ctx := ls.ctx
+	if srv.ConnContext != nil {
+		ctx = srv.ConnContext(ctx, hconn)
+		if ctx == nil {
+			panic("ConnContext returned nil")
+		}
+	}
+	if srv.CancelOnShutdown {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithCancel(ctx)
+		defer cancel()
+		cc.cancel = cancel
+	}
+	cc.setHandlerConn(hconn)
+	cc.ctx = ctx
+	srv.connsMu.Lock()
+	srv.conns[conn] = cc
 	srv.connsMu.Unlock()
+	if srv.isClosed() {
+		// the connection is registered after Shutdown or Close signaled
+		// connections.
+		srv.signalClose(cc)
+	}
+	if srv.idleTimeout() > 0 {
+		srv.startReaper()
+	}
+	srv.setState(cc, StateNew)
 
-	if srv.Handler != nil {
-		errorLog := srv.ErrorLog
-		if errorLog == nil {
-			errorLog = log.New(ioutil.Discard, "", log.LstdFlags)
+	handler := srv.handler()
+	if ls.config.Handler != nil {
+		handler = ls.config.Handler
+	}
+	if delay > 0 {
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-closeCh:
+			timer.Stop()
+			handler = nil
+			cc.setCloseReason(CloseShutdown)
+		}
+	}
+	if pc := findProxyConn(conn); pc != nil && handler != nil {
+		if _, _, err := pc.Header(); err != nil {
+			srv.log(ls.config, slog.LevelWarn, "PROXY protocol error",
+				slog.Uint64("conn_id", cc.id),
+				slog.String("remote_addr", pc.Conn.RemoteAddr().String()),
+				slog.Any("error", err))
+			handler = nil
+			cc.setCloseReason(CloseProxyError)
+		}
+	}
+	if srv.IPFilter != nil && handler != nil && !srv.IPFilter.allowedConn(conn) {
+		handler = nil
+		cc.setCloseReason(CloseFiltered)
+	}
+	if srv.maxConnsPerIP() > 0 && handler != nil {
+		ip := remoteIP(conn)
+		if srv.acquireIP(ip, closeCh) {
+			defer srv.releaseIP(ip)
+		} else {
+			handler = nil
+			cc.setCloseReason(CloseTooManyPerIP)
+		}
+	}
+	connected := false
+	var closeErr error
+	if handler != nil {
+		if srv.OnConnect != nil && !srv.OnConnect(hconn) {
+			handler = nil
+			cc.setCloseReason(CloseRejected)
+		} else {
+			connected = true
+		}
+	}
+	if tc, ok := conn.(*tls.Conn); ok && handler != nil {
+		srv.setState(cc, StateTLSHandshake)
+		timeout := srv.tlsHandshakeTimeout()
+		if ls.config.TLSHandshakeTimeout > 0 {
+			timeout = ls.config.TLSHandshakeTimeout
+		}
+		if timeout <= 0 {
+			timeout = DefTLSHandshakeTimeout
+		}
+		tc.SetDeadline(time.Now().Add(timeout))
+		err := tc.Handshake()
+		tc.SetDeadline(time.Time{})
+		if err != nil {
+			srv.counters.handshakeErrors.Add(1)
+			err = &TLSHandshakeError{
+				RemoteAddr: conn.RemoteAddr(),
+				Err:        err,
+			}
+			closeErr = err
+			if srv.TLSHandshakeErrorHandler != nil {
+				srv.TLSHandshakeErrorHandler(conn, err)
+			} else {
+				srv.log(ls.config, slog.LevelWarn, "TLS handshake error",
+					append(cc.connAttrs(), slog.Any("error", err.(*TLSHandshakeError).Err))...)
+			}
+			handler = nil
+			cc.setCloseReason(CloseHandshakeFailed)
+		}
+		if handler != nil {
+			state := tc.ConnectionState()
+			if h, ok := srv.NextProtoHandlers[state.NegotiatedProtocol]; ok && state.NegotiatedProtocol != "" {
+				handler = h
+			} else if h := srv.sniHandler(state.ServerName); h != nil {
+				handler = h
+			}
 		}
-		func() {
+	}
+	if fbConn != nil && handler != nil {
+		defer watchFirstByte(cc, fbConn, srv.FirstByteTimeout)()
+	}
+	if handler != nil && !srv.greet(hconn, ls.config) {
+		handler = nil
+		cc.setCloseReason(CloseGreetingFailed)
+	}
+	if handler != nil {
+		srv.setState(cc, StateActive)
+		next := srv.chain(handler)
+		serveHandler := func(ctx context.Context) {
 			defer func() {
 				e := recover()
 				if e != nil {
-					errorLog.Print(e)
+					cc.forceCloseReason(ClosePanic)
+					closeErr = ErrHandlerPanic
+					srv.counters.panics.Add(1)
+					srv.handlePanic(cc, ls.config, e, debug.Stack())
 				}
 			}()
-			srv.Handler.Serve(conn, closeCh)
-		}()
+			if hc, ok := next.(HandlerContext); ok {
+				ctx, cancel := closeContext(ctx, closeCh)
+				defer cancel()
+				hc.ServeContext(ctx, hconn)
+				return
+			}
+			next.Serve(hconn, closeCh)
+		}
+		if srv.ProfilerLabels {
+			pprof.Do(ctx, pprofLabels(conn, handler), serveHandler)
+		} else {
+			serveHandler(ctx)
+		}
 	}
 
-	conn.Close()
+	cc.setCloseReason(CloseHandlerReturned)
+	reason := cc.getCloseReason()
+	if p := cc.peerErr.Load(); p != nil && closeErr == nil {
+		closeErr = *p
+	}
 
 	srv.connsMu.Lock()
-	delete(srv.conns, conn)
+	hijacked := atomic.LoadInt32(&cc.hijacked) != 0
+	if !hijacked {
+		delete(srv.conns, conn)
+	}
 	srv.connsMu.Unlock()
+
+	if !hijacked {
+		conn.Close()
+		srv.counters.closed.Add(1)
+		srv.setState(cc, StateClosed)
+		if srv.logEnabled(ls.config, slog.LevelDebug) {
+			attrs := append(cc.connAttrs(), slog.Duration("duration", time.Since(cc.start)))
+			if cc.counter != nil {
+				bytesRead, bytesWritten, reads, writes := cc.counts()
+				attrs = append(attrs, slog.Int64("bytes_read", bytesRead), slog.Int64("bytes_written", bytesWritten),
+					slog.Int64("reads", reads), slog.Int64("writes", writes))
+			}
+			srv.log(ls.config, slog.LevelDebug, "connection closed", attrs...)
+		}
+		if connected && srv.OnDisconnect != nil {
+			var err error
+			if reason != CloseHandlerReturned {
+				err = &CloseError{Reason: reason, Err: closeErr}
+			}
+			srv.OnDisconnect(hconn, err, time.Since(cc.start))
+		}
+	}
+	if srv.AccessLog != nil {
+		srv.accessLog(cc, reason)
+	}
+}
+
+// SetHandler atomically replaces the handler of new connections, for changing
+// the protocol at runtime without restarting listeners. Connections being
+// served keep their handler. After SetHandler, Handler field is ignored.
+func (srv *TCPServer) SetHandler(h Handler) {
+	srv.handlerPtr.Store(&h)
+}
+
+// handler returns the handler of new connections.
+func (srv *TCPServer) handler() Handler {
+	if h := srv.handlerPtr.Load(); h != nil {
+		return *h
+	}
+	return srv.Handler
+}
+
+// handlePanic calls PanicHandler with the recovered value of Handler, or logs
+// it.
+func (srv *TCPServer) handlePanic(cc *connContext, lc *ListenerConfig, recovered interface{}, stack []byte) {
+	if srv.PanicHandler == nil {
+		srv.log(lc, slog.LevelError, "handler panic",
+			append(cc.connAttrs(), slog.Any("panic", recovered), slog.String("stack", string(stack)))...)
+		return
+	}
+	defer func() {
+		if e := recover(); e != nil {
+			srv.log(lc, slog.LevelError, "panic handler panic",
+				append(cc.connAttrs(), slog.Any("panic", e), slog.Any("handler_panic", recovered))...)
+		}
+	}()
+	srv.PanicHandler(cc.handlerConn(), recovered, stack)
+}
+
+// HandleSNI registers the handler for TLS connections with the SNI server
+// name. The name can be a wildcard like "*.example.com" that matches a single
+// label. Exact names take precedence over wildcards. Connections without a
+// matching name are served by Handler.
+func (srv *TCPServer) HandleSNI(name string, handler Handler) {
+	srv.sniMu.Lock()
+	defer srv.sniMu.Unlock()
+	if srv.sniHandlers == nil {
+		srv.sniHandlers = make(map[string]Handler)
+	}
+	srv.sniHandlers[strings.ToLower(name)] = handler
+}
+
+// sniHandler returns the handler registered for the SNI server name.
+func (srv *TCPServer) sniHandler(name string) Handler {
+	if name == "" {
+		return nil
+	}
+	name = strings.ToLower(name)
+	srv.sniMu.RLock()
+	defer srv.sniMu.RUnlock()
+	if h, ok := srv.sniHandlers[name]; ok {
+		return h
+	}
+	if i := strings.IndexByte(name, '.'); i >= 0 {
+		return srv.sniHandlers["*"+name[i:]]
+	}
+	return nil
+}
+
+// Context returns the context of the connection passed to Handler or the
+// underlying one. It returns context.Background() if the connection isn't
+// tracked by the server.
+func (srv *TCPServer) Context(conn net.Conn) context.Context {
+	if cc := srv.lookupConn(conn); cc != nil {
+		return cc.ctx
+	}
+	return context.Background()
+}
+
+// overflow handles the overflowed connection by the policy.
+func (srv *TCPServer) overflow(conn net.Conn, policy OverflowPolicy) {
+	defer conn.Close()
+	if policy != OverflowReject || len(srv.OverflowResponse) == 0 {
+		return
+	}
+	conn.SetWriteDeadline(time.Now().Add(DefOverflowResponseTimeout))
+	conn.Write(srv.OverflowResponse)
+}
+
+// setState sets state of the connection, and calls ConnState hook.
+func (srv *TCPServer) setState(cc *connContext, state ConnState) {
+	atomic.StoreInt32(&cc.state, int32(state))
+	if srv.ConnState != nil {
+		srv.ConnState(cc.handlerConn(), state)
+	}
+}
+
+// SetConnState reports state of the connection as StateActive or StateIdle,
+// for handlers of protocols with idle periods between requests. Other states
+// are ignored.
+func (srv *TCPServer) SetConnState(conn net.Conn, state ConnState) {
+	if state != StateActive && state != StateIdle {
+		return
+	}
+	if cc := srv.lookupConn(conn); cc != nil {
+		srv.setState(cc, state)
+	}
+}
+
+// handlerConn returns the connection passed to Handler, or the upgraded one
+// after StartTLS.
+func (cc *connContext) handlerConn() net.Conn {
+	if p := cc.hconn.Load(); p != nil {
+		return *p
+	}
+	return nil
+}
+
+func (cc *connContext) setHandlerConn(conn net.Conn) {
+	cc.hconn.Store(&conn)
+}
+
+// lookupConn returns context of the connection passed to Handler or the
+// underlying one.
+func (srv *TCPServer) lookupConn(conn net.Conn) *connContext {
+	srv.connsMu.RLock()
+	defer srv.connsMu.RUnlock()
+	if cc, ok := srv.conns[conn]; ok {
+		return cc
+	}
+	for _, cc := range srv.conns {
+		if cc.handlerConn() == conn {
+			return cc
+		}
+	}
+	return nil
+}
+
+// connCount returns count of tracked connections.
+func (srv *TCPServer) connCount() int {
+	srv.connsMu.RLock()
+	defer srv.connsMu.RUnlock()
+	return len(srv.conns)
+}
+
+// Hijack removes the connection from tracking of the server, and hands its
+// ownership to the caller. After Hijack, the server doesn't close the
+// connection, and Shutdown and Close don't wait for or close it. The
+// connection can be the one passed to Handler or the underlying one.
+func (srv *TCPServer) Hijack(conn net.Conn) error {
+	srv.connsMu.Lock()
+	var cc *connContext
+	for key, c := range srv.conns {
+		if c.conn == conn || c.handlerConn() == conn {
+			atomic.StoreInt32(&c.hijacked, 1)
+			delete(srv.conns, key)
+			cc = c
+			break
+		}
+	}
+	srv.connsMu.Unlock()
+	if cc == nil {
+		return ErrConnNotFound
+	}
+	cc.forceCloseReason(CloseHijacked)
+	srv.releaseActive()
+	srv.setState(cc, StateHijacked)
+	return nil
+}
+
+func (srv *TCPServer) errorLog(lc *ListenerConfig) *log.Logger {
+	if lc != nil && lc.ErrorLog != nil {
+		return lc.ErrorLog
+	}
+	if srv.ErrorLog == nil {
+		return log.New(ioutil.Discard, "", log.LstdFlags)
+	}
+	return srv.ErrorLog
+}
+
+// logFDExhausted logs accept error caused by file descriptor exhaustion with
+// current file descriptor budget.
+func (srv *TCPServer) logFDExhausted(err error) {
+	b, e := CurrentFDBudget()
+	if e != nil {
+		srv.log(nil, slog.LevelError, "accept error", slog.Any("error", err))
+		return
+	}
+	srv.log(nil, slog.LevelError, "accept error", slog.Any("error", err),
+		slog.Int("fds_open", b.Open), slog.Uint64("fds_limit", b.Limit))
+}
+
+// wrapConn wraps the connection to invoke Handler with.
+func (srv *TCPServer) wrapConn(conn net.Conn) net.Conn {
+	if srv.EstimateBandwidth {
+		conn = NewBandwidthConn(conn, srv.BandwidthWindow)
+	}
+	if rdBkt, wrBkt := srv.bandwidthBuckets(); rdBkt != nil || wrBkt != nil {
+		tc := &throttledConn{
+			Conn:    conn,
+			quantum: DefBandwidthQuantum,
+		}
+		if rdBkt != nil {
+			tc.rdBuckets = []*tokenBucket{rdBkt}
+		}
+		if wrBkt != nil {
+			tc.wrBuckets = []*tokenBucket{wrBkt}
+		}
+		conn = tc
+	}
+	if rdTimeout, wrTimeout := srv.readTimeout(), srv.writeTimeout(); rdTimeout > 0 || wrTimeout > 0 {
+		conn = &timeoutConn{
+			Conn:      conn,
+			rdTimeout: rdTimeout,
+			wrTimeout: wrTimeout,
+		}
+	}
+	return conn
+}
+
+// greet writes greeting to the connection, and reports whether it succeeded.
+func (srv *TCPServer) greet(conn net.Conn, lc *ListenerConfig) bool {
+	greeting, greetingFunc, timeout := srv.Greeting, srv.GreetingFunc, srv.GreetingTimeout
+	if lc.Greeting != nil || lc.GreetingFunc != nil {
+		greeting, greetingFunc = lc.Greeting, lc.GreetingFunc
+	}
+	if lc.GreetingTimeout > 0 {
+		timeout = lc.GreetingTimeout
+	}
+	if greetingFunc != nil {
+		greeting = greetingFunc(conn)
+	}
+	if len(greeting) == 0 {
+		return true
+	}
+	if timeout <= 0 {
+		timeout = DefGreetingTimeout
+	}
+	conn.SetWriteDeadline(time.Now().Add(timeout))
+	_, err := conn.Write(greeting)
+	conn.SetWriteDeadline(time.Time{})
+	return err == nil
 }
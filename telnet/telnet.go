@@ -0,0 +1,330 @@
+// Package telnet provides telnet option negotiation layer for tcpserver.
+package telnet
+
+import (
+	"bytes"
+	"net"
+	"sync"
+
+	"github.com/orkunkaraduman/go-tcpserver"
+)
+
+// Telnet commands.
+const (
+	SE   = 240
+	NOP  = 241
+	GA   = 249
+	SB   = 250
+	WILL = 251
+	WONT = 252
+	DO   = 253
+	DONT = 254
+	IAC  = 255
+)
+
+// Telnet options.
+const (
+	OptEcho            = 1
+	OptSuppressGoAhead = 3
+	OptNAWS            = 31
+)
+
+// maxSubnegotiationSize specifies maximum size of subnegotiation data.
+const maxSubnegotiationSize = 64
+
+// Server defines parameters for telnet layer as a tcpserver.Handler. It
+// wraps the connection with *Conn and invokes Handler with it, so Handler
+// receives a clean data stream without telnet commands.
+type Server struct {
+	// Handler to invoke.
+	Handler tcpserver.Handler
+
+	// SuppressGoAhead enables suppress go ahead option on accept.
+	SuppressGoAhead bool
+
+	// NAWS requests client to send window size on accept.
+	NAWS bool
+
+	// WindowSize callback. It will be called when client sends window size.
+	OnWindowSize func(conn *Conn, width, height int)
+}
+
+// Serve implements tcpserver.Handler.Serve.
+func (srv *Server) Serve(conn net.Conn, closeCh <-chan struct{}) {
+	tc := &Conn{
+		Conn: conn,
+		srv:  srv,
+	}
+	if srv.SuppressGoAhead {
+		tc.request(true, OptSuppressGoAhead, true)
+	}
+	if srv.NAWS {
+		tc.request(false, OptNAWS, true)
+	}
+	if srv.Handler != nil {
+		srv.Handler.Serve(tc, closeCh)
+	}
+}
+
+type optState struct {
+	enabled bool
+	pending bool
+}
+
+type readState int
+
+const (
+	stateData readState = iota
+	stateCR
+	stateIAC
+	stateOpt
+	stateSB
+	stateSBIAC
+)
+
+// A Conn is a net.Conn that handles telnet option negotiation. Reads from it
+// return data without telnet commands, and writes to it escape IAC bytes.
+type Conn struct {
+	net.Conn
+
+	srv *Server
+
+	rbuf  [4096]byte
+	rn    int
+	rlen  int
+	rerr  error
+	state readState
+	verb  byte
+	sb    []byte
+
+	optMu  sync.Mutex
+	local  [256]optState
+	remote [256]optState
+	width  int
+	height int
+
+	wrMu sync.Mutex
+}
+
+// Read reads data from the connection.
+func (c *Conn) Read(p []byte) (n int, err error) {
+	if len(p) == 0 {
+		return
+	}
+	for n == 0 {
+		if c.rn >= c.rlen {
+			if c.rerr != nil {
+				err = c.rerr
+				return
+			}
+			c.rlen, c.rerr = c.Conn.Read(c.rbuf[:])
+			c.rn = 0
+			continue
+		}
+		for c.rn < c.rlen && n < len(p) {
+			b := c.rbuf[c.rn]
+			c.rn++
+			if c.process(b) {
+				p[n] = b
+				n++
+			}
+		}
+	}
+	return
+}
+
+// Write writes data to the connection by escaping IAC bytes.
+func (c *Conn) Write(b []byte) (n int, err error) {
+	buf := bytes.Replace(b, []byte{IAC}, []byte{IAC, IAC}, -1)
+	c.wrMu.Lock()
+	defer c.wrMu.Unlock()
+	nn, err := c.Conn.Write(buf)
+	if nn >= len(buf) {
+		return len(b), err
+	}
+	// number of written bytes of b.
+	for i := 0; i < nn; i++ {
+		if buf[i] == IAC {
+			i++
+		}
+		n++
+	}
+	return
+}
+
+// WindowSize returns window size sent by client. It returns zeros if client
+// didn't send it.
+func (c *Conn) WindowSize() (width, height int) {
+	c.optMu.Lock()
+	defer c.optMu.Unlock()
+	return c.width, c.height
+}
+
+// HideInput requests client to stop or restart local echo. It is useful to
+// read passwords.
+func (c *Conn) HideInput(hide bool) error {
+	return c.request(true, OptEcho, hide)
+}
+
+// request requests enabling or disabling of an option for local or remote
+// side.
+func (c *Conn) request(local bool, opt byte, enable bool) error {
+	c.optMu.Lock()
+	st := &c.remote[opt]
+	verb := byte(DO)
+	if !enable {
+		verb = DONT
+	}
+	if local {
+		st = &c.local[opt]
+		verb = WILL
+		if !enable {
+			verb = WONT
+		}
+	}
+	if st.enabled == enable && !st.pending {
+		c.optMu.Unlock()
+		return nil
+	}
+	st.pending = true
+	if !enable {
+		st.enabled = false
+	}
+	c.optMu.Unlock()
+	return c.send(IAC, verb, opt)
+}
+
+func (c *Conn) send(b ...byte) error {
+	c.wrMu.Lock()
+	defer c.wrMu.Unlock()
+	_, err := c.Conn.Write(b)
+	return err
+}
+
+// process processes a byte read from the connection, and reports whether it
+// is a data byte.
+func (c *Conn) process(b byte) bool {
+	switch c.state {
+	case stateData:
+		switch b {
+		case IAC:
+			c.state = stateIAC
+			return false
+		case '\r':
+			c.state = stateCR
+		}
+		return true
+	case stateCR:
+		c.state = stateData
+		switch b {
+		case 0:
+			return false
+		case IAC:
+			c.state = stateIAC
+			return false
+		}
+		return true
+	case stateIAC:
+		c.state = stateData
+		switch b {
+		case IAC:
+			return true
+		case WILL, WONT, DO, DONT:
+			c.verb = b
+			c.state = stateOpt
+		case SB:
+			c.sb = c.sb[:0]
+			c.state = stateSB
+		}
+		return false
+	case stateOpt:
+		c.state = stateData
+		c.negotiate(c.verb, b)
+		return false
+	case stateSB:
+		if b == IAC {
+			c.state = stateSBIAC
+			return false
+		}
+		if len(c.sb) < maxSubnegotiationSize {
+			c.sb = append(c.sb, b)
+		}
+		return false
+	case stateSBIAC:
+		switch b {
+		case SE:
+			c.state = stateData
+			c.subnegotiate()
+		case IAC:
+			c.state = stateSB
+			if len(c.sb) < maxSubnegotiationSize {
+				c.sb = append(c.sb, b)
+			}
+		default:
+			c.state = stateData
+		}
+		return false
+	}
+	return false
+}
+
+func (c *Conn) supported(local bool, opt byte) bool {
+	if local {
+		return opt == OptSuppressGoAhead
+	}
+	return opt == OptSuppressGoAhead || (opt == OptNAWS && c.srv.NAWS)
+}
+
+func (c *Conn) negotiate(verb byte, opt byte) {
+	local := verb == DO || verb == DONT
+	enable := verb == DO || verb == WILL
+	c.optMu.Lock()
+	st := &c.remote[opt]
+	yes, no := byte(DO), byte(DONT)
+	if local {
+		st = &c.local[opt]
+		yes, no = WILL, WONT
+	}
+	var reply byte
+	switch {
+	case enable && !st.enabled:
+		if st.pending {
+			st.enabled = true
+			st.pending = false
+		} else if c.supported(local, opt) {
+			st.enabled = true
+			reply = yes
+		} else {
+			reply = no
+		}
+	case enable && st.enabled:
+		st.pending = false
+	case !enable && st.enabled:
+		st.enabled = false
+		if st.pending {
+			st.pending = false
+		} else {
+			reply = no
+		}
+	case !enable && !st.enabled:
+		st.pending = false
+	}
+	c.optMu.Unlock()
+	if reply != 0 {
+		c.send(IAC, reply, opt)
+	}
+}
+
+func (c *Conn) subnegotiate() {
+	if len(c.sb) < 5 || c.sb[0] != OptNAWS {
+		return
+	}
+	width := int(c.sb[1])<<8 | int(c.sb[2])
+	height := int(c.sb[3])<<8 | int(c.sb[4])
+	c.optMu.Lock()
+	c.width, c.height = width, height
+	c.optMu.Unlock()
+	if c.srv.OnWindowSize != nil {
+		c.srv.OnWindowSize(c, width, height)
+	}
+}
@@ -0,0 +1,269 @@
+package tcpserver
+
+import (
+	"crypto/tls"
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+// DefMaxTenants is the default value of Tenancy.MaxTenants.
+var DefMaxTenants = 1024
+
+// TenantLimits defines resource limits of a tenant.
+type TenantLimits struct {
+	// MaxConns specifies maximum count of concurrent connections. If it is 0,
+	// there is no limit.
+	MaxConns int
+
+	// BytesPerSecond specifies aggregate bandwidth cap of each direction for
+	// all connections of the tenant. If it is 0, there is no limit.
+	BytesPerSecond int
+}
+
+// TenantStats defines statistics of a tenant.
+type TenantStats struct {
+	Conns        int
+	TotalConns   uint64
+	Rejected     uint64
+	BytesRead    uint64
+	BytesWritten uint64
+}
+
+// A Tenancy is a Handler that tracks connection limits, bandwidth caps and
+// statistics per tenant, so a tenant of a shared server can't exhaust
+// resources of others. Tenant keys are derived from connections by KeyFunc.
+type Tenancy struct {
+	// Handler to invoke.
+	Handler Handler
+
+	// KeyFunc returns tenant key of the connection. If it is nil, TenantBySNI
+	// is used.
+	KeyFunc func(conn net.Conn) string
+
+	// DefaultLimits specifies limits of tenants without specific limits.
+	DefaultLimits TenantLimits
+
+	// Limits specifies limits of tenants by key.
+	Limits map[string]TenantLimits
+
+	// MaxTenants specifies maximum count of tracked tenants which aren't in
+	// Limits, because keys may be controlled by clients. When the count is
+	// reached, idle tenants are evicted with their statistics, and if there
+	// is no idle tenant, connections of new keys are accounted to the shared
+	// tenant with the empty key. If it is 0, DefMaxTenants is used.
+	MaxTenants int
+
+	// Reject callback. It will be called before closing a connection rejected
+	// by limits of its tenant.
+	OnReject func(conn net.Conn, key string)
+
+	mu       sync.Mutex
+	tenants  map[string]*tenant
+	dynamics int
+}
+
+type tenant struct {
+	limits       TenantLimits
+	dynamic      bool
+	conns        int
+	totalConns   uint64
+	rejected     uint64
+	bytesRead    uint64
+	bytesWritten uint64
+	rdBucket     *tokenBucket
+	wrBucket     *tokenBucket
+}
+
+// Serve implements Handler.Serve.
+func (tn *Tenancy) Serve(conn net.Conn, closeCh <-chan struct{}) {
+	keyFunc := tn.KeyFunc
+	if keyFunc == nil {
+		keyFunc = TenantBySNI
+	}
+	key := keyFunc(conn)
+
+	tn.mu.Lock()
+	if tn.tenants == nil {
+		tn.tenants = make(map[string]*tenant)
+	}
+	t := tn.tenants[key]
+	if t == nil {
+		_, ok := tn.Limits[key]
+		if !ok && key != "" && tn.dynamics >= tn.maxTenants() {
+			tn.evict()
+			if tn.dynamics >= tn.maxTenants() {
+				key = ""
+			}
+		}
+		t = tn.tenants[key]
+	}
+	if t == nil {
+		t = tn.newTenant(key)
+		tn.tenants[key] = t
+	}
+	if t.limits.MaxConns > 0 && t.conns >= t.limits.MaxConns {
+		t.rejected++
+		tn.mu.Unlock()
+		if tn.OnReject != nil {
+			tn.OnReject(conn, key)
+		}
+		return
+	}
+	t.conns++
+	t.totalConns++
+	tn.mu.Unlock()
+
+	tc := &tenantConn{
+		Conn:   conn,
+		tenant: t,
+	}
+	if t.rdBucket != nil {
+		conn = &throttledConn{
+			Conn:      tc,
+			rdBuckets: []*tokenBucket{t.rdBucket},
+			wrBuckets: []*tokenBucket{t.wrBucket},
+		}
+	} else {
+		conn = tc
+	}
+	defer func() {
+		tn.mu.Lock()
+		t.conns--
+		tn.mu.Unlock()
+	}()
+	tn.Handler.Serve(conn, closeCh)
+}
+
+func (tn *Tenancy) maxTenants() int {
+	if tn.MaxTenants <= 0 {
+		return DefMaxTenants
+	}
+	return tn.MaxTenants
+}
+
+// newTenant creates the tenant of key. It must be called with lock held.
+func (tn *Tenancy) newTenant(key string) *tenant {
+	limits, ok := tn.Limits[key]
+	if !ok {
+		limits = tn.DefaultLimits
+	}
+	t := &tenant{limits: limits}
+	if !ok && key != "" {
+		t.dynamic = true
+		tn.dynamics++
+	}
+	if limits.BytesPerSecond > 0 {
+		t.rdBucket = newTokenBucket(float64(limits.BytesPerSecond), limits.BytesPerSecond)
+		t.wrBucket = newTokenBucket(float64(limits.BytesPerSecond), limits.BytesPerSecond)
+	}
+	return t
+}
+
+// evict removes idle tenants which aren't in Limits. It must be called with
+// lock held.
+func (tn *Tenancy) evict() {
+	for key, t := range tn.tenants {
+		if t.dynamic && t.conns == 0 {
+			delete(tn.tenants, key)
+			tn.dynamics--
+		}
+	}
+}
+
+// Stats returns statistics of the tenant.
+func (tn *Tenancy) Stats(key string) TenantStats {
+	tn.mu.Lock()
+	defer tn.mu.Unlock()
+	t := tn.tenants[key]
+	if t == nil {
+		return TenantStats{}
+	}
+	return t.stats()
+}
+
+// AllStats returns statistics of all tenants by key.
+func (tn *Tenancy) AllStats() map[string]TenantStats {
+	tn.mu.Lock()
+	defer tn.mu.Unlock()
+	result := make(map[string]TenantStats, len(tn.tenants))
+	for key, t := range tn.tenants {
+		result[key] = t.stats()
+	}
+	return result
+}
+
+func (t *tenant) stats() TenantStats {
+	return TenantStats{
+		Conns:        t.conns,
+		TotalConns:   t.totalConns,
+		Rejected:     t.rejected,
+		BytesRead:    atomic.LoadUint64(&t.bytesRead),
+		BytesWritten: atomic.LoadUint64(&t.bytesWritten),
+	}
+}
+
+// tenantConn is a net.Conn counting bytes of its tenant.
+type tenantConn struct {
+	net.Conn
+
+	tenant *tenant
+}
+
+func (c *tenantConn) Read(b []byte) (n int, err error) {
+	n, err = c.Conn.Read(b)
+	atomic.AddUint64(&c.tenant.bytesRead, uint64(n))
+	return
+}
+
+func (c *tenantConn) Write(b []byte) (n int, err error) {
+	n, err = c.Conn.Write(b)
+	atomic.AddUint64(&c.tenant.bytesWritten, uint64(n))
+	return
+}
+
+func (c *tenantConn) NetConn() net.Conn {
+	return c.Conn
+}
+
+// findTLSConn returns the *tls.Conn of wrapped connection.
+func findTLSConn(conn net.Conn) *tls.Conn {
+	for {
+		if tc, ok := conn.(*tls.Conn); ok {
+			return tc
+		}
+		w, ok := conn.(interface{ NetConn() net.Conn })
+		if !ok {
+			return nil
+		}
+		conn = w.NetConn()
+	}
+}
+
+// TenantBySNI returns SNI server name of the TLS connection as tenant key.
+// It runs TLS handshake if it isn't done. It returns empty string for non-TLS
+// connections or failed handshakes.
+func TenantBySNI(conn net.Conn) string {
+	tc := findTLSConn(conn)
+	if tc == nil || tc.Handshake() != nil {
+		return ""
+	}
+	return tc.ConnectionState().ServerName
+}
+
+// TenantByClientCert returns common name of verified client certificate of
+// the TLS connection as tenant key. It runs TLS handshake if it isn't done.
+// It returns empty string if there is no verified client certificate, so
+// unverified certificates accepted by RequestClientCert or
+// RequireAnyClientCert can't claim a tenant.
+func TenantByClientCert(conn net.Conn) string {
+	tc := findTLSConn(conn)
+	if tc == nil || tc.Handshake() != nil {
+		return ""
+	}
+	chains := tc.ConnectionState().VerifiedChains
+	if len(chains) == 0 || len(chains[0]) == 0 {
+		return ""
+	}
+	return chains[0][0].Subject.CommonName
+}
@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"bytes"
 	"io"
+	"io/ioutil"
 	"net"
 )
 
@@ -29,6 +30,22 @@ type TextProtocol struct {
 	// MaxLineSize specifies maximum line size with delimiter.
 	MaxLineSize int
 
+	// FlowControl enables credit-based flow control. Contexts read a line and
+	// its data only if they have a credit, and stop reading from connection
+	// when credits are exhausted. Credits are granted by Grant method of
+	// context.
+	FlowControl bool
+
+	// InitialCredits specifies credits of contexts on accept if FlowControl
+	// is true.
+	InitialCredits int
+
+	// MemoryBudget optionally limits memory of data buffers of contexts. If
+	// a data buffer exceeds the budget, the data is discarded without calling
+	// OnReadData by MemoryReject policy, or the context is closed by
+	// MemoryEvict policy.
+	MemoryBudget *MemoryBudget
+
 	// User data to use free.
 	UserData interface{}
 }
@@ -43,6 +60,13 @@ func (prt *TextProtocol) Serve(conn net.Conn, closeCh <-chan struct{}) {
 		rd:       bufio.NewReader(conn),
 		wr:       bufio.NewWriter(conn),
 	}
+	if prt.FlowControl {
+		ctx.credits = NewCredits(prt.InitialCredits)
+	}
+	if prt.MemoryBudget != nil {
+		ctx.mem = prt.MemoryBudget.NewAccount()
+		defer ctx.mem.Close()
+	}
 	ctx.serve()
 }
 
@@ -59,6 +83,8 @@ type TextProtocolContext struct {
 
 	closeCh  <-chan struct{}
 	closeCh2 chan struct{}
+	credits  *Credits
+	mem      *MemoryAccount
 	rd       *bufio.Reader
 	wr       *bufio.Writer
 }
@@ -71,6 +97,22 @@ func (ctx *TextProtocolContext) Close() {
 	}
 }
 
+// Grant grants n credits to read lines if flow control is enabled.
+func (ctx *TextProtocolContext) Grant(n int) {
+	if ctx.credits != nil {
+		ctx.credits.Grant(n)
+	}
+}
+
+// Credits returns available credits to read lines. It returns -1 if flow
+// control isn't enabled.
+func (ctx *TextProtocolContext) Credits() int {
+	if ctx.credits == nil {
+		return -1
+	}
+	return ctx.credits.Available()
+}
+
 func (ctx *TextProtocolContext) serve() {
 	maxLineSize := ctx.Prt.MaxLineSize
 	if maxLineSize <= 0 {
@@ -88,6 +130,9 @@ mainloop:
 			break mainloop
 		default:
 		}
+		if ctx.credits != nil && !ctx.credits.Acquire(ctx.closeCh, ctx.closeCh2) {
+			break mainloop
+		}
 		line, err := ReadBytesLimit(ctx.rd, '\n', maxLineSize)
 		if err != nil {
 			ctx.Close()
@@ -99,15 +144,28 @@ mainloop:
 		if size <= 0 {
 			continue
 		}
+		if ctx.mem != nil && ctx.mem.Reserve(size) != nil {
+			if ctx.Prt.MemoryBudget.Policy == MemoryEvict {
+				ctx.Close()
+				continue
+			}
+			if _, err = io.CopyN(ioutil.Discard, ctx.rd, int64(size)); err != nil {
+				ctx.Close()
+			}
+			continue
+		}
 		buf := make([]byte, size)
 		_, err = io.ReadFull(ctx.rd, buf)
+		if err == nil && ctx.Prt.OnReadData != nil {
+			ctx.Prt.OnReadData(ctx, buf)
+		}
+		if ctx.mem != nil {
+			ctx.mem.Release(size)
+		}
 		if err != nil {
 			ctx.Close()
 			continue
 		}
-		if ctx.Prt.OnReadData != nil {
-			ctx.Prt.OnReadData(ctx, buf)
-		}
 	}
 	ctx.wr.Flush()
 	if ctx.Prt.OnQuit != nil {
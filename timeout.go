@@ -0,0 +1,73 @@
+package tcpserver
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// timeoutConn is a net.Conn refreshing deadlines of the connection on each
+// Read and Write. Deadlines set by the user are kept if they are earlier.
+type timeoutConn struct {
+	net.Conn
+
+	rdTimeout time.Duration
+	wrTimeout time.Duration
+
+	mu         sync.Mutex
+	rdDeadline time.Time
+	wrDeadline time.Time
+}
+
+// timeoutDeadline returns earlier of the user deadline and now plus timeout.
+func timeoutDeadline(user time.Time, timeout time.Duration) time.Time {
+	d := time.Now().Add(timeout)
+	if !user.IsZero() && user.Before(d) {
+		return user
+	}
+	return d
+}
+
+func (c *timeoutConn) Read(b []byte) (int, error) {
+	if c.rdTimeout > 0 {
+		c.mu.Lock()
+		c.Conn.SetReadDeadline(timeoutDeadline(c.rdDeadline, c.rdTimeout))
+		c.mu.Unlock()
+	}
+	return c.Conn.Read(b)
+}
+
+func (c *timeoutConn) Write(b []byte) (int, error) {
+	if c.wrTimeout > 0 {
+		c.mu.Lock()
+		c.Conn.SetWriteDeadline(timeoutDeadline(c.wrDeadline, c.wrTimeout))
+		c.mu.Unlock()
+	}
+	return c.Conn.Write(b)
+}
+
+func (c *timeoutConn) SetDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.rdDeadline, c.wrDeadline = t, t
+	c.mu.Unlock()
+	return c.Conn.SetDeadline(t)
+}
+
+func (c *timeoutConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.rdDeadline = t
+	c.mu.Unlock()
+	return c.Conn.SetReadDeadline(t)
+}
+
+func (c *timeoutConn) SetWriteDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.wrDeadline = t
+	c.mu.Unlock()
+	return c.Conn.SetWriteDeadline(t)
+}
+
+// NetConn returns the wrapped connection.
+func (c *timeoutConn) NetConn() net.Conn {
+	return c.Conn
+}
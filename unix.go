@@ -0,0 +1,132 @@
+package tcpserver
+
+import (
+	"errors"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrPeerCredNotSupported is returned by PeerCredentials when peer
+	// credentials aren't supported on the running platform or connection.
+	ErrPeerCredNotSupported = errors.New("peer credentials not supported")
+
+	// ErrSocketInUse is returned by ListenUnix when the socket file is in use
+	// by another listener.
+	ErrSocketInUse = errors.New("unix socket in use")
+)
+
+// UnixSocketOptions defines options of unix domain socket listeners.
+type UnixSocketOptions struct {
+	// Mode specifies file mode of socket file. If it is 0, mode isn't
+	// changed.
+	Mode os.FileMode
+
+	// Chown enables changing owner of socket file to UID and GID.
+	Chown bool
+
+	// UID and GID specify owner of socket file if Chown is true.
+	UID, GID int
+
+	// RemoveStale removes existing socket file before listening if there is
+	// no listener on it.
+	RemoveStale bool
+}
+
+// PeerCred defines credentials of the peer process of a unix domain socket
+// connection.
+type PeerCred struct {
+	PID int32
+	UID uint32
+	GID uint32
+}
+
+// isAbstractUnix reports whether the address is in abstract namespace.
+func isAbstractUnix(addr string) bool {
+	return strings.HasPrefix(addr, "@") || strings.HasPrefix(addr, "\x00")
+}
+
+// ListenUnix listens on the unix domain socket address. Addresses starting
+// with '@' are in abstract namespace on Linux, file options aren't applied to
+// them. The socket file is removed when the listener is closed.
+func ListenUnix(addr string, opts *UnixSocketOptions) (l net.Listener, err error) {
+	if opts == nil {
+		opts = &UnixSocketOptions{}
+	}
+	abstract := isAbstractUnix(addr)
+	if !abstract && opts.RemoveStale {
+		if err = removeStaleUnix(addr); err != nil {
+			return
+		}
+	}
+	l, err = net.Listen("unix", addr)
+	if err != nil || abstract {
+		return
+	}
+	if opts.Mode != 0 {
+		if err = os.Chmod(addr, opts.Mode); err != nil {
+			l.Close()
+			return nil, err
+		}
+	}
+	if opts.Chown {
+		if err = os.Chown(addr, opts.UID, opts.GID); err != nil {
+			l.Close()
+			return nil, err
+		}
+	}
+	return
+}
+
+// unixAddr returns path of the unix domain socket address in form of
+// unix:///path/to.sock or unix://@name, and reports whether addr is a unix
+// domain socket address.
+func unixAddr(addr string) (string, bool) {
+	if !strings.HasPrefix(addr, "unix://") {
+		return "", false
+	}
+	return strings.TrimPrefix(addr, "unix://"), true
+}
+
+// removeStaleUnix removes the socket file if there is no listener on it.
+func removeStaleUnix(addr string) error {
+	fi, err := os.Lstat(addr)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if fi.Mode()&os.ModeSocket == 0 {
+		return &os.PathError{Op: "remove stale", Path: addr, Err: errors.New("not a socket")}
+	}
+	conn, err := net.DialTimeout("unix", addr, 1*time.Second)
+	if err == nil {
+		conn.Close()
+		return ErrSocketInUse
+	}
+	return os.Remove(addr)
+}
+
+// unwrapConn returns the innermost connection of wrapped connection.
+func unwrapConn(conn net.Conn) net.Conn {
+	for {
+		w, ok := conn.(interface{ NetConn() net.Conn })
+		if !ok {
+			return conn
+		}
+		conn = w.NetConn()
+	}
+}
+
+// PeerCredentials returns credentials of the peer process of the unix domain
+// socket connection by SO_PEERCRED.
+func PeerCredentials(conn net.Conn) (*PeerCred, error) {
+	uc, ok := unwrapConn(conn).(*net.UnixConn)
+	if !ok {
+		return nil, ErrPeerCredNotSupported
+	}
+	return peerCredentials(uc)
+}
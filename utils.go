@@ -3,6 +3,7 @@ package tcpserver
 import (
 	"bufio"
 	"errors"
+	"net"
 )
 
 var (
@@ -49,3 +50,14 @@ func trimCrLf(buf []byte) []byte {
 	buf = buf[0:l]
 	return buf
 }
+
+// remoteIP returns IP address of remote address of the connection, or remote
+// address itself if it doesn't have a host part.
+func remoteIP(conn net.Conn) string {
+	addr := conn.RemoteAddr().String()
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
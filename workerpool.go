@@ -0,0 +1,134 @@
+package tcpserver
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// DefWorkerPoolSize specifies count of workers if WorkerPool.Size is 0.
+var DefWorkerPoolSize = 1024
+
+// A WorkerPool is a bounded pool of goroutines serving connections of
+// TCPServer instead of a goroutine per connection. Accepted connections are
+// queued, and served by free workers. When the queue is full, new connections
+// are handled by Policy.
+type WorkerPool struct {
+	// Size specifies count of workers. If it is 0, DefWorkerPoolSize is used.
+	Size int
+
+	// QueueSize specifies count of connections waiting for a free worker.
+	QueueSize int
+
+	// Policy specifies the behavior when the queue is full.
+	// OverflowBackpressure stops accepting until the queue has room,
+	// OverflowClose closes new connections, and OverflowReject writes
+	// TCPServer.OverflowResponse to new connections and closes them.
+	Policy OverflowPolicy
+
+	mu       sync.RWMutex
+	jobs     chan func()
+	wg       sync.WaitGroup
+	busy     int32
+	rejected uint64
+}
+
+// WorkerPoolStats defines statistics of WorkerPool.
+type WorkerPoolStats struct {
+	// Workers is count of workers.
+	Workers int
+
+	// Busy is count of workers serving a connection.
+	Busy int
+
+	// Queued is count of connections waiting for a free worker.
+	Queued int
+
+	// Rejected is count of connections rejected by Policy.
+	Rejected uint64
+}
+
+// Start starts workers of the pool. It is called by TCPServer if the pool
+// isn't started.
+func (p *WorkerPool) Start() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.jobs != nil {
+		return
+	}
+	size := p.Size
+	if size <= 0 {
+		size = DefWorkerPoolSize
+	}
+	p.jobs = make(chan func(), p.QueueSize)
+	for i := 0; i < size; i++ {
+		p.wg.Add(1)
+		go p.work(p.jobs)
+	}
+}
+
+// Stop stops workers of the pool after queued connections are served. It
+// should be called after TCPServer is closed.
+func (p *WorkerPool) Stop() {
+	p.mu.Lock()
+	jobs := p.jobs
+	p.jobs = nil
+	p.mu.Unlock()
+	if jobs == nil {
+		return
+	}
+	close(jobs)
+	p.wg.Wait()
+}
+
+// Stats returns statistics of the pool.
+func (p *WorkerPool) Stats() WorkerPoolStats {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	size := 0
+	if p.jobs != nil {
+		size = p.Size
+		if size <= 0 {
+			size = DefWorkerPoolSize
+		}
+	}
+	return WorkerPoolStats{
+		Workers:  size,
+		Busy:     int(atomic.LoadInt32(&p.busy)),
+		Queued:   len(p.jobs),
+		Rejected: atomic.LoadUint64(&p.rejected),
+	}
+}
+
+func (p *WorkerPool) work(jobs <-chan func()) {
+	defer p.wg.Done()
+	for job := range jobs {
+		atomic.AddInt32(&p.busy, 1)
+		job()
+		atomic.AddInt32(&p.busy, -1)
+	}
+}
+
+// submit queues the job, and reports whether it is queued. By
+// OverflowBackpressure policy, it waits until the queue has room or closedCh
+// is closed.
+func (p *WorkerPool) submit(job func(), closedCh <-chan struct{}) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.jobs == nil {
+		return false
+	}
+	select {
+	case p.jobs <- job:
+		return true
+	default:
+	}
+	if p.Policy == OverflowBackpressure {
+		select {
+		case p.jobs <- job:
+			return true
+		case <-closedCh:
+		}
+	}
+	atomic.AddUint64(&p.rejected, 1)
+	return false
+}
@@ -0,0 +1,207 @@
+package tcpserver
+
+import (
+	"errors"
+	"io"
+	"sync"
+)
+
+// DefWriteQueueSize specifies size of each lane of WriteQueue if size is 0.
+var DefWriteQueueSize = 64
+
+// Priorities of WriteQueue with 3 lanes. Lower value is higher priority.
+const (
+	PriorityControl = 0
+	PriorityNormal  = 1
+	PriorityBulk    = 2
+)
+
+var (
+	// ErrWriteQueueClosed is returned when WriteQueue is closed.
+	ErrWriteQueueClosed = errors.New("write queue closed")
+
+	// ErrWriteQueueFull is returned by WriteQueue.TryEnqueue when the lane is
+	// full.
+	ErrWriteQueueFull = errors.New("write queue full")
+
+	// ErrInvalidPriority is returned when priority is out of lanes.
+	ErrInvalidPriority = errors.New("invalid priority")
+)
+
+// A WriteQueue writes messages to a writer asynchronously. Messages are
+// queued in priority lanes, and a queued message of higher priority lane is
+// always written before lower ones. So urgent messages aren't stuck behind
+// large queued payloads.
+type WriteQueue struct {
+	w        io.Writer
+	lanes    []chan []byte
+	notifyCh chan struct{}
+	closeCh  chan struct{}
+	doneCh   chan struct{}
+
+	mu        sync.Mutex
+	err       error
+	closeOnce sync.Once
+
+	// enqueueMu is held for reading while queueing, and for writing while
+	// closing, so a queued message is always written before closing.
+	enqueueMu sync.RWMutex
+}
+
+// NewWriteQueue returns a new WriteQueue with the lanes, and starts writing
+// to w. Each lane holds size messages.
+func NewWriteQueue(w io.Writer, lanes int, size int) *WriteQueue {
+	if lanes <= 0 {
+		lanes = 1
+	}
+	if size <= 0 {
+		size = DefWriteQueueSize
+	}
+	q := &WriteQueue{
+		w:        w,
+		lanes:    make([]chan []byte, lanes),
+		notifyCh: make(chan struct{}, 1),
+		closeCh:  make(chan struct{}),
+		doneCh:   make(chan struct{}),
+	}
+	for i := range q.lanes {
+		q.lanes[i] = make(chan []byte, size)
+	}
+	go q.run()
+	return q
+}
+
+// Enqueue queues buf to the lane of priority. It blocks while the lane is
+// full. It returns error of the last write if writing failed.
+func (q *WriteQueue) Enqueue(priority int, buf []byte) error {
+	if priority < 0 || priority >= len(q.lanes) {
+		return ErrInvalidPriority
+	}
+	q.enqueueMu.RLock()
+	defer q.enqueueMu.RUnlock()
+	if err := q.Err(); err != nil {
+		return err
+	}
+	select {
+	case <-q.closeCh:
+		return ErrWriteQueueClosed
+	default:
+	}
+	select {
+	case q.lanes[priority] <- buf:
+	case <-q.doneCh:
+		return q.Err()
+	}
+	q.notify()
+	return nil
+}
+
+// TryEnqueue queues buf to the lane of priority without blocking. It returns
+// ErrWriteQueueFull if the lane is full.
+func (q *WriteQueue) TryEnqueue(priority int, buf []byte) error {
+	if priority < 0 || priority >= len(q.lanes) {
+		return ErrInvalidPriority
+	}
+	q.enqueueMu.RLock()
+	defer q.enqueueMu.RUnlock()
+	if err := q.Err(); err != nil {
+		return err
+	}
+	select {
+	case <-q.closeCh:
+		return ErrWriteQueueClosed
+	default:
+	}
+	select {
+	case q.lanes[priority] <- buf:
+	default:
+		return ErrWriteQueueFull
+	}
+	q.notify()
+	return nil
+}
+
+// Len returns count of queued messages.
+func (q *WriteQueue) Len() (n int) {
+	for _, lane := range q.lanes {
+		n += len(lane)
+	}
+	return
+}
+
+// Err returns error of the last write if writing failed.
+func (q *WriteQueue) Err() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.err
+}
+
+// Close stops queueing, waits for queued messages to be written, and returns
+// error of the last write if writing failed. Enqueue calls blocked by full
+// lanes are completed before closing.
+func (q *WriteQueue) Close() error {
+	q.enqueueMu.Lock()
+	q.closeOnce.Do(func() {
+		close(q.closeCh)
+	})
+	q.enqueueMu.Unlock()
+	<-q.doneCh
+	err := q.Err()
+	if err == ErrWriteQueueClosed {
+		err = nil
+	}
+	return err
+}
+
+func (q *WriteQueue) notify() {
+	select {
+	case q.notifyCh <- struct{}{}:
+	default:
+	}
+}
+
+// next returns the next message in order of priority.
+func (q *WriteQueue) next() ([]byte, bool) {
+	for _, lane := range q.lanes {
+		select {
+		case buf := <-lane:
+			return buf, true
+		default:
+		}
+	}
+	return nil, false
+}
+
+func (q *WriteQueue) run() {
+	defer close(q.doneCh)
+	for {
+		buf, ok := q.next()
+		if !ok {
+			select {
+			case <-q.notifyCh:
+				continue
+			case <-q.closeCh:
+				if buf, ok = q.next(); !ok {
+					q.setErr(ErrWriteQueueClosed)
+					return
+				}
+			}
+		}
+		nn, err := q.w.Write(buf)
+		if err == nil && nn < len(buf) {
+			err = io.ErrShortWrite
+		}
+		if err != nil {
+			q.setErr(err)
+			return
+		}
+	}
+}
+
+func (q *WriteQueue) setErr(err error) {
+	q.mu.Lock()
+	if q.err == nil {
+		q.err = err
+	}
+	q.mu.Unlock()
+}
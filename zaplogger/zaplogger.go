@@ -0,0 +1,47 @@
+// Package zaplogger provides tcpserver.Logger adapter for zap.
+package zaplogger
+
+import (
+	"log/slog"
+
+	"github.com/orkunkaraduman/go-tcpserver"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// New returns a tcpserver.Logger logging to l.
+func New(l *zap.Logger) tcpserver.Logger {
+	return &logger{l: l}
+}
+
+type logger struct {
+	l *zap.Logger
+}
+
+func zapLevel(level slog.Level) zapcore.Level {
+	switch {
+	case level >= slog.LevelError:
+		return zapcore.ErrorLevel
+	case level >= slog.LevelWarn:
+		return zapcore.WarnLevel
+	case level >= slog.LevelInfo:
+		return zapcore.InfoLevel
+	}
+	return zapcore.DebugLevel
+}
+
+func (z *logger) Enabled(level slog.Level) bool {
+	return z.l.Core().Enabled(zapLevel(level))
+}
+
+func (z *logger) Log(level slog.Level, msg string, attrs ...slog.Attr) {
+	ce := z.l.Check(zapLevel(level), msg)
+	if ce == nil {
+		return
+	}
+	fields := make([]zap.Field, 0, len(attrs))
+	for _, a := range attrs {
+		fields = append(fields, zap.Any(a.Key, a.Value.Resolve().Any()))
+	}
+	ce.Write(fields...)
+}
@@ -0,0 +1,82 @@
+// Package zerologger provides tcpserver.Logger adapter for zerolog.
+package zerologger
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/orkunkaraduman/go-tcpserver"
+	"github.com/rs/zerolog"
+)
+
+// New returns a tcpserver.Logger logging to l.
+func New(l zerolog.Logger) tcpserver.Logger {
+	return &logger{l: l}
+}
+
+type logger struct {
+	l zerolog.Logger
+}
+
+func zerologLevel(level slog.Level) zerolog.Level {
+	switch {
+	case level >= slog.LevelError:
+		return zerolog.ErrorLevel
+	case level >= slog.LevelWarn:
+		return zerolog.WarnLevel
+	case level >= slog.LevelInfo:
+		return zerolog.InfoLevel
+	}
+	return zerolog.DebugLevel
+}
+
+func (z *logger) Enabled(level slog.Level) bool {
+	lvl := zerologLevel(level)
+	return lvl >= z.l.GetLevel() && lvl >= zerolog.GlobalLevel()
+}
+
+func (z *logger) Log(level slog.Level, msg string, attrs ...slog.Attr) {
+	e := z.l.WithLevel(zerologLevel(level))
+	if e == nil {
+		return
+	}
+	for _, a := range attrs {
+		e = addAttr(e, a)
+	}
+	e.Msg(msg)
+}
+
+// addAttr adds the attribute to the event by its kind, so values which can't
+// be marshaled to JSON like errors aren't lost.
+func addAttr(e *zerolog.Event, a slog.Attr) *zerolog.Event {
+	v := a.Value.Resolve()
+	switch v.Kind() {
+	case slog.KindString:
+		return e.Str(a.Key, v.String())
+	case slog.KindInt64:
+		return e.Int64(a.Key, v.Int64())
+	case slog.KindUint64:
+		return e.Uint64(a.Key, v.Uint64())
+	case slog.KindFloat64:
+		return e.Float64(a.Key, v.Float64())
+	case slog.KindBool:
+		return e.Bool(a.Key, v.Bool())
+	case slog.KindDuration:
+		return e.Dur(a.Key, v.Duration())
+	case slog.KindTime:
+		return e.Time(a.Key, v.Time())
+	case slog.KindGroup:
+		d := zerolog.Dict()
+		for _, ga := range v.Group() {
+			d = addAttr(d, ga)
+		}
+		return e.Dict(a.Key, d)
+	}
+	switch x := v.Any().(type) {
+	case error:
+		return e.AnErr(a.Key, x)
+	case fmt.Stringer:
+		return e.Stringer(a.Key, x)
+	}
+	return e.Interface(a.Key, v.Any())
+}